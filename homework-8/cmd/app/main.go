@@ -2,114 +2,519 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/pflag"
 	"github.com/uber/jaeger-client-go/config"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/watch"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/metrics"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/audit"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/billing"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/cache"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/idempotency"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/infrastructure/kafka"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/outbox"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/subscription"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/audit_events"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/subscriptions"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
-	"log"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
-var (
-	ctx = context.Background()
-)
-
 func main() {
-	config, err := app.InitConfig()
-	if err != nil {
-		fmt.Printf("apperr occurred during config initialization: %s", err)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	config, err := app.InitConfig(os.Args[1:])
+	if errors.Is(err, pflag.ErrHelp) {
 		return
 	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error occurred during config initialization: %s\n", err)
+		os.Exit(2)
+	}
+
+	logger, err := logging.New(config.Logging.Level, config.Logging.Encoding)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid logging config: %s\n", err)
+		os.Exit(1)
+	}
+	logging.SetGlobal(logger)
+
+	err = run(ctx, config, logger)
+	if err != nil {
+		logger.Error("service stopped with error", zap.Error(err))
+	}
+	_ = logger.Sync()
+	if err != nil {
+		os.Exit(1)
+	}
+}
 
+// run serves until ctx is cancelled or a server fails. The error of a failed
+// initialization is returned after the resources set up so far are released.
+func run(ctx context.Context, config *app.Config, logger *zap.Logger) error {
 	db, err := database.InitDB(config)
 	if err != nil {
-		fmt.Printf("error occured during connection to db: %s", err)
-		return
+		return fmt.Errorf("cannot connect to db: %w", err)
 	}
+	// deferred calls run in reverse, so the database is closed last, after the
+	// workers and the tracer
 	defer db.Close()
 
-	if err := db.UpMigrations(); err != nil {
-		fmt.Printf("error occured while performing database migrations: %s", err)
-		return
+	if err := db.UpMigrations(config.Database.MigrationsDir); err != nil {
+		return fmt.Errorf("cannot perform database migrations: %w", err)
 	}
 
-	bankAccountRepository := account.NewBankAccountRepository(db)
-	bankAccountService := account.NewBankAccountService(bankAccountRepository)
+	prometheus.MustRegister(metrics.NewDBStatsCollector(config.Database.Name, db.Stats))
 
-	subscriptionRepository := subscription.NewSubscriptionRepository(db)
-	subscriptionService := subscription.NewSubscriptionService(subscriptionRepository)
+	isolation, err := database.ParseIsolationLevel(config.Database.IsolationLevel)
+	if err != nil {
+		return fmt.Errorf("invalid database config: %w", err)
+	}
+	txManager := database.NewTxManager(db, isolation, config.Database.TxMaxRetries)
 
-	go func() {
-		err := runGatewayServer(ctx, config.Server.GatewayPort)
+	rates, err := money.NewFileRateProvider(config.ExchangeRates.File)
+	if err != nil {
+		return fmt.Errorf("cannot load exchange rates: %w", err)
+	}
+
+	tracerCloser, err := setupTracing()
+	if err != nil {
+		return fmt.Errorf("cannot create tracer: %w", err)
+	}
+	defer tracerCloser.Close()
+
+	tokenVerifier, err := app.LoadTokenVerifier(config.Auth.HMACSecret, config.Auth.HMACSecretFile, config.Auth.RSAPublicKeyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load token keys: %w", err)
+	}
+
+	// the workers outlive ctx, they are stopped after the servers have drained
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	var workers sync.WaitGroup
+	startWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workersCtx)
+		}()
+	}
+
+	// the producer connects in the background, events stay in the outbox
+	// until Kafka can be reached
+	var producer atomic.Pointer[kafka.Producer]
+
+	auditRepository := audit.NewAuditRepository(db)
+	auditService := audit.NewAuditService(auditRepository)
+
+	healthChecks := map[string]app.HealthCheck{
+		"postgres": db.PingContext,
+		"kafka": func(ctx context.Context) error {
+			producer := producer.Load()
+			if producer == nil {
+				return errors.New("not connected")
+			}
+			return producer.Ping()
+		},
+	}
+
+	bankAccountRepository := account.NewBankAccountRepository(db, txManager)
+	var accountRepository account.Repository = bankAccountRepository
+	var subscriptionRepository subscription.Repository = subscription.NewSubscriptionRepository(db, txManager)
+
+	accountChanges := watch.NewBus()
+	var accountPublisher watch.Publisher = accountChanges
+
+	accountCache, err := newCache(config, healthChecks)
+	if err != nil {
+		return fmt.Errorf("cannot set up cache: %w", err)
+	}
+	if accountCache != nil {
+		cachingRepository := account.NewCachingRepository(bankAccountRepository, accountCache, config.Cache.TTL)
+		accountRepository = cachingRepository
+		subscriptionRepository = subscription.NewInvalidatingRepository(subscriptionRepository, cachingRepository)
+		// the cache goes first, watchers read the changed account again
+		accountPublisher = watch.Publishers{cachingRepository, accountChanges}
+	}
+
+	startWorker(func(ctx context.Context) {
+		if err := watch.NewListener(database.ConnectionString(config), accountPublisher).Run(ctx); err != nil {
+			logger.Error("cannot listen for bank account changes", zap.Error(err))
+		}
+	})
+
+	bankAccountService := account.NewAuditedService(
+		account.NewBankAccountService(accountRepository, rates, accountChanges),
+		txManager,
+		auditRepository,
+	)
+
+	subscriptionService := subscription.NewAuditedService(
+		subscription.NewSubscriptionService(subscriptionRepository),
+		txManager,
+		auditRepository,
+	)
+
+	idempotencyRepository := idempotency.NewIdempotencyRepository(db)
+	idempotencyPurger := idempotency.NewPurger(
+		idempotencyRepository,
+		config.Idempotency.PurgeInterval,
+		config.Idempotency.PurgeBatchSize,
+	)
+	startWorker(idempotencyPurger.Run)
+
+	billingWorker := billing.NewWorker(
+		billing.NewBillingRepository(db, txManager, rates),
+		config.Billing.Interval,
+		config.Billing.BatchSize,
+		billing.Backoff{BaseDelay: config.Billing.RetryBaseDelay, MaxDelay: config.Billing.RetryMaxDelay},
+	)
+	startWorker(billingWorker.Run)
+
+	startWorker(func(ctx context.Context) {
+		// ConnectProducer logs every failed attempt and retries with backoff
+		// while the service serves, the events wait in the outbox meanwhile.
+		// It gives up only on shutdown.
+		connected, err := kafka.ConnectProducer(ctx, config.Kafka.Brokers)
 		if err != nil {
-			log.Fatal(err)
+			logger.Warn("outbox relay stopped before kafka was reachable, events stay in the outbox", zap.Error(err))
+			return
 		}
+		producer.Store(connected)
+		defer connected.Close()
+
+		outbox.NewRelay(
+			outbox.NewOutboxRepository(db, txManager),
+			connected,
+			config.Kafka.EventsTopicName,
+			config.Outbox.Interval,
+			config.Outbox.BatchSize,
+		).Run(ctx)
+	})
+
+	rateLimiter := app.NewRateLimiter(config.RateLimit.Default, config.RateLimit.Methods)
+	subjectRateLimiter := app.NewRateLimiter(config.RateLimit.Subjects.Default, config.RateLimit.Subjects.Methods)
+	app.WatchConfig(func(config *app.Config) {
+		rateLimiter.Update(config.RateLimit.Default, config.RateLimit.Methods)
+		subjectRateLimiter.Update(config.RateLimit.Subjects.Default, config.RateLimit.Subjects.Methods)
+		logger.Info("rate limits reloaded")
+	})
+
+	healthServer := health.NewServer()
+	healthChecker := app.NewHealthChecker(healthServer, config.Server.HealthCheckTimeout, healthChecks)
+	startWorker(func(ctx context.Context) {
+		healthChecker.Run(ctx, config.Server.HealthCheckInterval)
+	})
+
+	grpcServer := newGrpcServer(config, logger, bankAccountService, subscriptionService, auditService, idempotencyRepository, tokenVerifier, bankAccountRepository, rateLimiter, subjectRateLimiter)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	gatewayServer, err := newGatewayServer(ctx, config, healthChecker)
+	if err != nil {
+		return fmt.Errorf("cannot set up gateway: %w", err)
+	}
+	metricsServer := newMetricsServer(config.Server.MetricsPort)
+
+	lis, err := net.Listen("tcp", config.Server.GrpcPort)
+	if err != nil {
+		return fmt.Errorf("cannot listen for grpc: %w", err)
+	}
+
+	serverErrors := make(chan error, 3)
+	go func() {
+		logger.Info("bankAccountService messages listening", zap.String("addr", config.Server.GrpcPort))
+		serverErrors <- grpcServer.Serve(lis)
+	}()
+	go func() {
+		serverErrors <- listenAndServe(gatewayServer)
+	}()
+	go func() {
+		serverErrors <- listenAndServe(metricsServer)
 	}()
 
-	if err := run(ctx, config.Server.GrpcPort, *bankAccountService, *subscriptionService); err != nil {
-		log.Fatal(err)
+	var serverErr error
+	select {
+	case <-ctx.Done():
+		logger.Info("shutting down")
+	case serverErr = <-serverErrors:
+		logger.Error("server stopped, shutting down", zap.Error(serverErr))
 	}
-}
 
-func run(ctx context.Context, addr string, bankAccountService account.BankAccountService, subscriptionService subscription.SubscriptionService) error {
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
 
-	setupTracing()
+	healthChecker.Drain()
+	// the gateway goes first, the calls it relays need the gRPC server
+	if err := gatewayServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("cannot drain gateway", zap.Error(err))
+	}
+	stopGrpcServer(shutdownCtx, grpcServer)
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("cannot drain metrics server", zap.Error(err))
+	}
+
+	stopWorkers()
+	if !wait(shutdownCtx, &workers) {
+		logger.Error("workers did not stop in time")
+	}
+	return serverErr
+}
 
+func newGrpcServer(config *app.Config, logger *zap.Logger, bankAccountService account.Service, subscriptionService subscription.Service, auditService audit.Service, idempotencyStore app.IdempotencyStore, tokenVerifier *app.TokenVerifier, accountOwners app.AccountOwners, rateLimiter *app.RateLimiter, subjectRateLimiter *app.RateLimiter) *grpc.Server {
 	grpcServer := grpc.NewServer(
 		grpc.ChainUnaryInterceptor(
-			app.ContextPropagationUnaryServerInterceptor(),
+			app.ContextPropagationUnaryServerInterceptor(logger),
+			metrics.UnaryServerInterceptor(),
 			app.UnaryErrorHandlerInterceptor(),
+			// calls are limited by address before their tokens are checked
+			app.RateLimitUnaryServerInterceptor(rateLimiter),
+			app.AuthUnaryServerInterceptor(tokenVerifier, accountOwners, config.Auth.AdminMethods),
+			app.SubjectRateLimitUnaryServerInterceptor(subjectRateLimiter),
+			app.IdempotencyUnaryServerInterceptor(idempotencyStore, config.Idempotency.TTL, config.Idempotency.LockTimeout, config.Idempotency.Methods),
+		),
+		grpc.ChainStreamInterceptor(
+			app.ContextPropagationStreamServerInterceptor(logger),
+			metrics.StreamServerInterceptor(),
+			app.StreamErrorHandlerInterceptor(),
+			app.RateLimitStreamServerInterceptor(rateLimiter),
+			app.AuthStreamServerInterceptor(tokenVerifier, accountOwners, config.Auth.AdminMethods),
+			app.SubjectRateLimitStreamServerInterceptor(subjectRateLimiter),
 		),
 	)
 
-	bank_accounts.RegisterBankAccountServiceServer(grpcServer, account.NewBankAccountGrpcImpl(&bankAccountService))
-	subscriptions.RegisterSubscriptionServiceServer(grpcServer, subscription.NewSubscriptionGrpcImpl(&subscriptionService))
+	bank_accounts.RegisterBankAccountServiceServer(grpcServer, account.NewBankAccountGrpcImpl(bankAccountService))
+	subscriptions.RegisterSubscriptionServiceServer(grpcServer, subscription.NewSubscriptionGrpcImpl(subscriptionService))
+	audit_events.RegisterAuditServiceServer(grpcServer, audit.NewAuditGrpcImpl(auditService))
+	return grpcServer
+}
 
-	lis, err := net.Listen("tcp", addr)
-	if err != nil {
+// newCache returns nil when accounts are not cached. A redis cache adds its
+// health check to healthChecks.
+func newCache(config *app.Config, healthChecks map[string]app.HealthCheck) (cache.Cache, error) {
+	switch config.Cache.Backend {
+	case "memory":
+		return cache.NewMemoryCache(), nil
+	case "redis":
+		client := redis.NewClient(&redis.Options{
+			Addr:     config.Cache.Redis.Address,
+			Password: config.Cache.Redis.Password,
+			DB:       config.Cache.Redis.DB,
+		})
+		healthChecks["redis"] = func(ctx context.Context) error {
+			return client.Ping(ctx).Err()
+		}
+		return cache.NewRedisCache(client, config.Cache.Redis.KeyPrefix), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", config.Cache.Backend)
+	}
+}
+
+// stopGrpcServer waits for the running calls to finish and cancels those
+// still running when ctx is done, e.g. WatchBankAccount streams.
+func stopGrpcServer(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+		<-stopped
+	}
+}
+
+func wait(ctx context.Context, group *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// listenAndServe does not report the shutdown of server as an error.
+func listenAndServe(server *http.Server) error {
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
+	return nil
+}
+
+func newMetricsServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
 
-	log.Printf("bankAccountService messages listening on %q", addr)
-	return grpcServer.Serve(lis)
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
 }
 
-func runGatewayServer(ctx context.Context, addr string) error {
+func newGatewayServer(ctx context.Context, config *app.Config, healthChecker *app.HealthChecker) (*http.Server, error) {
 	conn, err := grpc.DialContext(
-		context.Background(),
-		"127.0.0.1:50052",
+		ctx,
+		grpcTarget(config.Server.GrpcPort),
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 	)
 	if err != nil {
-		log.Fatalln("Failed to dial server:", err)
+		return nil, fmt.Errorf("failed to dial server: %w", err)
 	}
 
-	grpcMux := runtime.NewServeMux()
+	grpcMux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher),
+		runtime.WithForwardResponseOption(setETag),
+		runtime.WithErrorHandler(errorHandler),
+	)
 	err = bank_accounts.RegisterBankAccountServiceHandler(ctx, grpcMux, conn)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	err = subscriptions.RegisterSubscriptionServiceHandler(ctx, grpcMux, conn)
+	if err != nil {
+		return nil, err
+	}
+	err = audit_events.RegisterAuditServiceHandler(ctx, grpcMux, conn)
+	if err != nil {
+		return nil, err
+	}
+	err = account.RegisterBulkHandlers(grpcMux, bank_accounts.NewBankAccountServiceClient(conn))
+	if err != nil {
+		return nil, err
+	}
+	err = grpcMux.HandlePath(http.MethodGet, "/healthz", healthChecker.LivenessHandler)
+	if err != nil {
+		return nil, err
+	}
+	err = grpcMux.HandlePath(http.MethodGet, "/readyz", healthChecker.ReadinessHandler)
+	if err != nil {
+		return nil, err
 	}
 
-	gwServer := &http.Server{
-		Addr:    addr,
+	return &http.Server{
+		Addr:    config.Server.GatewayPort,
 		Handler: grpcMux,
+	}, nil
+}
+
+// grpcTarget is the loopback address of the gRPC server listening on addr.
+// The gateway has to call over loopback for the rate limiter to trust the
+// client address it forwards.
+func grpcTarget(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
 	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
 
-	return gwServer.ListenAndServe()
+// incomingHeaderMatcher forwards the headers read by the interceptors. The
+// gateway itself passes Authorization on as app.AuthorizationHeader.
+func incomingHeaderMatcher(key string) (string, bool) {
+	if strings.EqualFold(key, app.IdempotencyKeyHeader) {
+		return app.IdempotencyKeyHeader, true
+	}
+	if strings.EqualFold(key, app.IfMatchHeader) {
+		return app.IfMatchHeader, true
+	}
+	if strings.EqualFold(key, app.RequestIDHeader) {
+		return app.RequestIDHeader, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// outgoingHeaderMatcher answers a rate limited call with the standard
+// Retry-After header and returns the request ID as X-Request-Id.
+func outgoingHeaderMatcher(key string) (string, bool) {
+	if key == app.RetryAfterHeader {
+		return "Retry-After", true
+	}
+	if key == app.RequestIDHeader {
+		return "X-Request-Id", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+// setETag exposes the version of a returned bank account as its ETag, so that
+// clients can send it back in If-Match on update.
+func setETag(ctx context.Context, w http.ResponseWriter, message proto.Message) error {
+	response, ok := message.(interface {
+		GetAccount() *bank_accounts.BankAccountDto
+	})
+	// a read mask may leave the version out
+	if ok && response.GetAccount().GetVersion() != 0 {
+		w.Header().Set("ETag", app.FormatETag(response.GetAccount().GetVersion()))
+	}
+	return nil
 }
 
-func setupTracing() {
+// errorHandler answers a stale write with 412 instead of the 400 the gateway
+// uses for FailedPrecondition by default, and asks for a bearer token on 401.
+func errorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	switch status.Code(err) {
+	case codes.FailedPrecondition:
+		w = &statusWriter{ResponseWriter: w, status: http.StatusPreconditionFailed}
+	case codes.Unauthenticated:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	runtime.DefaultHTTPErrorHandler(ctx, mux, marshaler, w, r, err)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(int) {
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// setupTracing installs the global tracer. Closing it flushes the spans
+// not yet reported.
+func setupTracing() (io.Closer, error) {
 	cfg := config.Configuration{
 		Sampler: &config.SamplerConfig{
 			Type:  "const",
@@ -124,10 +529,9 @@ func setupTracing() {
 		"bank-account-service",
 	)
 	if err != nil {
-		fmt.Printf("cannot create tracer: %v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
-	defer closer.Close()
 
 	opentracing.SetGlobalTracer(tracer)
+	return closer, nil
 }
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE transfer
+(
+    id              UUID PRIMARY KEY,
+    from_account_id UUID      NOT NULL,
+    to_account_id   UUID      NOT NULL,
+    amount          INT       NOT NULL CHECK (amount > 0),
+    idempotency_key VARCHAR(255) UNIQUE,
+    created_at      TIMESTAMP NOT NULL,
+    FOREIGN KEY (from_account_id) REFERENCES bank_account (id) ON DELETE CASCADE,
+    FOREIGN KEY (to_account_id) REFERENCES bank_account (id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE transfer;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE ledger_entry
+(
+    id             UUID PRIMARY KEY,
+    transaction_id UUID         NOT NULL,
+    account_id     UUID,
+    amount         INT          NOT NULL,
+    balance_after  INT,
+    description    VARCHAR(255) NOT NULL,
+    created_at     TIMESTAMP    NOT NULL,
+    FOREIGN KEY (account_id) REFERENCES bank_account (id) ON DELETE CASCADE
+);
+
+CREATE INDEX ledger_entry_account_id_created_at_idx ON ledger_entry (account_id, created_at DESC, id DESC);
+CREATE INDEX ledger_entry_transaction_id_idx ON ledger_entry (transaction_id);
+
+-- Opening entries for balances that existed before the ledger. An entry with
+-- NULL account_id is the external side of a deposit or withdrawal, so the
+-- amounts of every transaction sum up to zero.
+CREATE TEMPORARY TABLE opening_balance AS
+SELECT uuid_generate_v4() AS transaction_id, id AS account_id, balance, opening_date
+FROM bank_account
+WHERE balance <> 0;
+
+INSERT INTO ledger_entry (id, transaction_id, account_id, amount, balance_after, description, created_at)
+SELECT uuid_generate_v4(), transaction_id, account_id, balance, balance, 'opening balance', opening_date
+FROM opening_balance;
+
+INSERT INTO ledger_entry (id, transaction_id, account_id, amount, balance_after, description, created_at)
+SELECT uuid_generate_v4(), transaction_id, NULL, -balance, NULL, 'opening balance', opening_date
+FROM opening_balance;
+
+DROP TABLE opening_balance;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE ledger_entry;
+-- +goose StatementEnd
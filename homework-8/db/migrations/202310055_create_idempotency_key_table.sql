@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE idempotency_key
+(
+    key          VARCHAR(255) NOT NULL,
+    method       VARCHAR(255) NOT NULL,
+    request_hash VARCHAR(64)  NOT NULL,
+    response     BYTEA,
+    created_at   TIMESTAMP    NOT NULL,
+    expires_at   TIMESTAMP    NOT NULL,
+    PRIMARY KEY (key, method)
+);
+
+CREATE INDEX idempotency_key_expires_at_idx ON idempotency_key (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE idempotency_key;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX bank_account_opening_date_id_idx ON bank_account (opening_date, id);
+CREATE INDEX bank_account_balance_id_idx ON bank_account (balance, id);
+CREATE INDEX bank_account_holder_name_id_idx ON bank_account (holder_name, id);
+CREATE INDEX bank_account_holder_name_prefix_idx ON bank_account (holder_name text_pattern_ops);
+CREATE INDEX bank_account_bank_name_idx ON bank_account (bank_name);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX bank_account_bank_name_idx;
+DROP INDEX bank_account_holder_name_prefix_idx;
+DROP INDEX bank_account_holder_name_id_idx;
+DROP INDEX bank_account_balance_id_idx;
+DROP INDEX bank_account_opening_date_id_idx;
+-- +goose StatementEnd
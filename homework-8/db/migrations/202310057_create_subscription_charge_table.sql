@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE subscription
+    ADD COLUMN status          VARCHAR(16) NOT NULL DEFAULT 'active',
+    ADD COLUMN next_charge_at  TIMESTAMP,
+    ADD COLUMN retry_at        TIMESTAMP,
+    ADD COLUMN failed_attempts INT         NOT NULL DEFAULT 0;
+
+UPDATE subscription SET next_charge_at = start_date;
+
+ALTER TABLE subscription ALTER COLUMN next_charge_at SET NOT NULL;
+
+CREATE INDEX subscription_charge_due_at_idx ON subscription ((COALESCE(retry_at, next_charge_at)));
+
+CREATE TABLE subscription_charge
+(
+    id              UUID PRIMARY KEY,
+    subscription_id UUID        NOT NULL,
+    account_id      UUID        NOT NULL,
+    amount          INT         NOT NULL,
+    status          VARCHAR(16) NOT NULL,
+    attempt         INT         NOT NULL,
+    due_at          TIMESTAMP   NOT NULL,
+    created_at      TIMESTAMP   NOT NULL,
+    FOREIGN KEY (subscription_id) REFERENCES subscription (id) ON DELETE CASCADE,
+    FOREIGN KEY (account_id) REFERENCES bank_account (id) ON DELETE CASCADE
+);
+
+CREATE INDEX subscription_charge_subscription_id_idx ON subscription_charge (subscription_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE subscription_charge;
+DROP INDEX subscription_charge_due_at_idx;
+ALTER TABLE subscription
+    DROP COLUMN failed_attempts,
+    DROP COLUMN retry_at,
+    DROP COLUMN next_charge_at,
+    DROP COLUMN status;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE outbox
+(
+    id             BIGSERIAL PRIMARY KEY,
+    aggregate_type VARCHAR(64) NOT NULL,
+    aggregate_id   UUID        NOT NULL,
+    event_type     VARCHAR(64) NOT NULL,
+    payload        JSONB       NOT NULL,
+    created_at     TIMESTAMP   NOT NULL,
+    published_at   TIMESTAMP
+);
+
+CREATE INDEX outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE outbox;
+-- +goose StatementEnd
@@ -0,0 +1,61 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Amounts were stored in whole roubles. From now on every amount is kept in
+-- minor units of its currency, so the existing values are converted to kopecks.
+ALTER TABLE bank_account
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'RUB',
+    ALTER COLUMN balance TYPE BIGINT USING balance::BIGINT * 100;
+ALTER TABLE bank_account ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE subscription
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'RUB',
+    ALTER COLUMN price TYPE BIGINT USING price::BIGINT * 100;
+ALTER TABLE subscription ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE ledger_entry
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'RUB',
+    ALTER COLUMN amount TYPE BIGINT USING amount::BIGINT * 100,
+    ALTER COLUMN balance_after TYPE BIGINT USING balance_after::BIGINT * 100;
+ALTER TABLE ledger_entry ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE transfer
+    ALTER COLUMN amount TYPE BIGINT USING amount::BIGINT * 100,
+    ADD COLUMN to_amount BIGINT;
+UPDATE transfer SET to_amount = amount;
+ALTER TABLE transfer
+    ALTER COLUMN to_amount SET NOT NULL,
+    ADD CONSTRAINT transfer_to_amount_check CHECK (to_amount > 0);
+
+ALTER TABLE subscription_charge
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'RUB',
+    ALTER COLUMN amount TYPE BIGINT USING amount::BIGINT * 100;
+ALTER TABLE subscription_charge ALTER COLUMN currency DROP DEFAULT;
+
+CREATE INDEX bank_account_currency_idx ON bank_account (currency);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX bank_account_currency_idx;
+
+ALTER TABLE subscription_charge
+    DROP COLUMN currency,
+    ALTER COLUMN amount TYPE INT USING amount / 100;
+
+ALTER TABLE transfer
+    DROP COLUMN to_amount,
+    ALTER COLUMN amount TYPE INT USING amount / 100;
+
+ALTER TABLE ledger_entry
+    DROP COLUMN currency,
+    ALTER COLUMN amount TYPE INT USING amount / 100,
+    ALTER COLUMN balance_after TYPE INT USING balance_after / 100;
+
+ALTER TABLE subscription
+    DROP COLUMN currency,
+    ALTER COLUMN price TYPE INT USING price / 100;
+
+ALTER TABLE bank_account
+    DROP COLUMN currency,
+    ALTER COLUMN balance TYPE INT USING balance / 100;
+-- +goose StatementEnd
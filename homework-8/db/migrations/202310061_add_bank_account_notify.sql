@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE FUNCTION notify_bank_account_change() RETURNS TRIGGER AS
+$$
+DECLARE
+    account_id UUID;
+    op         TEXT := TG_OP;
+BEGIN
+    IF TG_TABLE_NAME = 'bank_account' THEN
+        account_id := COALESCE(NEW.id, OLD.id);
+    ELSE
+        -- a subscription change is a change of the account it belongs to
+        account_id := COALESCE(NEW.account_id, OLD.account_id);
+        op := 'UPDATE';
+    END IF;
+
+    PERFORM pg_notify('bank_account_changes', json_build_object('account_id', account_id, 'op', op)::TEXT);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER bank_account_notify
+    AFTER INSERT OR UPDATE OR DELETE
+    ON bank_account
+    FOR EACH ROW
+EXECUTE FUNCTION notify_bank_account_change();
+
+CREATE TRIGGER subscription_notify
+    AFTER INSERT OR UPDATE OR DELETE
+    ON subscription
+    FOR EACH ROW
+EXECUTE FUNCTION notify_bank_account_change();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER subscription_notify ON subscription;
+DROP TRIGGER bank_account_notify ON bank_account;
+DROP FUNCTION notify_bank_account_change();
+-- +goose StatementEnd
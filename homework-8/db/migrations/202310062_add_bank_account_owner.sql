@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- owner_id is the subject of the access token of the holder. Accounts created
+-- before authentication have no owner and are only accessible to admins.
+ALTER TABLE bank_account ADD COLUMN owner_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX bank_account_owner_id_idx ON bank_account (owner_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX bank_account_owner_id_idx;
+
+ALTER TABLE bank_account DROP COLUMN owner_id;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Accounts are closed rather than deleted, so that their ledger and
+-- subscriptions stay. Frozen accounts cannot be debited.
+ALTER TABLE bank_account
+    ADD COLUMN status    TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'frozen', 'closed')),
+    ADD COLUMN closed_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE bank_account
+    DROP COLUMN closed_at,
+    DROP COLUMN status;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE audit_event
+(
+    id          UUID PRIMARY KEY,
+    entity_type VARCHAR(64)  NOT NULL,
+    entity_id   UUID         NOT NULL,
+    actor       VARCHAR(255) NOT NULL,
+    method      VARCHAR(64)  NOT NULL,
+    diff        JSONB        NOT NULL,
+    request_id  VARCHAR(255) NOT NULL,
+    created_at  TIMESTAMP    NOT NULL
+);
+
+CREATE INDEX audit_event_entity_idx ON audit_event (entity_type, entity_id, created_at DESC, id DESC);
+CREATE INDEX audit_event_created_at_idx ON audit_event (created_at DESC, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE audit_event;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Keys are scoped by the caller, so that one caller cannot replay the response
+-- of another. A request holds its key until locked_until, after which a retry
+-- may take the key over, e.g. when the server crashed while running it.
+ALTER TABLE idempotency_key
+    ADD COLUMN principal    VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN locked_until TIMESTAMP;
+
+UPDATE idempotency_key SET locked_until = created_at WHERE response IS NULL;
+
+ALTER TABLE idempotency_key
+    DROP CONSTRAINT idempotency_key_pkey,
+    ADD PRIMARY KEY (principal, key, method);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM idempotency_key a
+USING idempotency_key b
+WHERE a.key = b.key AND a.method = b.method AND a.principal > b.principal;
+
+ALTER TABLE idempotency_key
+    DROP CONSTRAINT idempotency_key_pkey,
+    ADD PRIMARY KEY (key, method),
+    DROP COLUMN locked_until,
+    DROP COLUMN principal;
+-- +goose StatementEnd
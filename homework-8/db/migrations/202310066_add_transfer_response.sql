@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The accounts a transfer left behind, returned again when its idempotency key
+-- is replayed. Transfers made before have none.
+ALTER TABLE transfer ADD COLUMN response BYTEA;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE transfer DROP COLUMN response;
+-- +goose StatementEnd
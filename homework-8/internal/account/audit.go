@@ -0,0 +1,198 @@
+package account
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/audit"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+)
+
+// auditedRead reads an account before its change. Closed accounts are read
+// too, so that e.g. a repeated close fails with the error of the wrapped
+// service. The row is locked, so no other change can commit between the read
+// and the change. Subscriptions are audited on their own and left out.
+var auditedRead = model.ReadOptions{
+	IncludeClosed: true,
+	Mask:          accountFields(),
+	ForUpdate:     true,
+}
+
+// AuditedService records every change made through the wrapped Service in the
+// audit log. The change and its record share a transaction, so neither is kept
+// without the other. Reads are passed through.
+type AuditedService struct {
+	Service
+	txManager database.TxManager
+	auditLog  audit.Repository
+}
+
+func NewAuditedService(service Service, txManager database.TxManager, auditLog audit.Repository) *AuditedService {
+	return &AuditedService{
+		Service:   service,
+		txManager: txManager,
+		auditLog:  auditLog,
+	}
+}
+
+func (s *AuditedService) CreateBankAccount(ctx context.Context, account *model.BankAccount) (created *model.BankAccount, err error) {
+	err = s.txManager.Do(ctx, func(ctx context.Context) error {
+		if created, err = s.Service.CreateBankAccount(ctx, account); err != nil {
+			return err
+		}
+		return s.record(ctx, "CreateBankAccount", nil, created)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *AuditedService) UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount, mask model.UpdateMask) (*model.BankAccount, error) {
+	return s.change(ctx, "UpdateBankAccount", id, func(ctx context.Context) (*model.BankAccount, error) {
+		return s.Service.UpdateBankAccount(ctx, id, account, mask)
+	})
+}
+
+func (s *AuditedService) DeleteBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	return s.change(ctx, "DeleteBankAccount", id, func(ctx context.Context) (*model.BankAccount, error) {
+		return s.Service.DeleteBankAccount(ctx, id)
+	})
+}
+
+func (s *AuditedService) CloseBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	return s.change(ctx, "CloseBankAccount", id, func(ctx context.Context) (*model.BankAccount, error) {
+		return s.Service.CloseBankAccount(ctx, id)
+	})
+}
+
+func (s *AuditedService) FreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	return s.change(ctx, "FreezeBankAccount", id, func(ctx context.Context) (*model.BankAccount, error) {
+		return s.Service.FreezeBankAccount(ctx, id)
+	})
+}
+
+func (s *AuditedService) UnfreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	return s.change(ctx, "UnfreezeBankAccount", id, func(ctx context.Context) (*model.BankAccount, error) {
+		return s.Service.UnfreezeBankAccount(ctx, id)
+	})
+}
+
+// Transfer records the change of both accounts. A replayed transfer changes
+// nothing and is not recorded. The accounts are locked in the order the
+// transfer locks them in.
+func (s *AuditedService) Transfer(ctx context.Context, transfer *model.Transfer) (from *model.BankAccount, to *model.BankAccount, err error) {
+	err = s.txManager.Do(ctx, func(ctx context.Context) error {
+		firstID, secondID := transfer.LockOrder()
+		first, err := s.Service.GetBankAccountById(ctx, firstID, auditedRead)
+		if err != nil {
+			return err
+		}
+		second, err := s.Service.GetBankAccountById(ctx, secondID, auditedRead)
+		if err != nil {
+			return err
+		}
+		fromBefore, toBefore := first, second
+		if firstID != transfer.FromID {
+			fromBefore, toBefore = second, first
+		}
+
+		if from, to, err = s.Service.Transfer(ctx, transfer); err != nil {
+			return err
+		}
+
+		if err = s.record(ctx, "Transfer", fromBefore, from); err != nil {
+			return err
+		}
+		return s.record(ctx, "Transfer", toBefore, to)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return from, to, nil
+}
+
+// ImportBankAccounts records the creation of every imported account of the
+// batch at once.
+func (s *AuditedService) ImportBankAccounts(ctx context.Context, rows []model.ImportRow) (result *model.ImportResult, err error) {
+	err = s.txManager.Do(ctx, func(ctx context.Context) error {
+		if result, err = s.Service.ImportBankAccounts(ctx, rows); err != nil {
+			return err
+		}
+
+		failed := make(map[int]struct{}, len(result.Failures))
+		for _, failure := range result.Failures {
+			failed[failure.Index] = struct{}{}
+		}
+		events := make([]audit.Event, 0, result.Imported)
+		for _, row := range rows {
+			if _, ok := failed[row.Index]; ok {
+				continue
+			}
+			event, err := audit.NewEvent(ctx, audit.EntityAccount, row.Account.ID, "ImportBankAccounts", nil, row.Account.MapToDto())
+			if err != nil {
+				return apperr.NewInternalServerError("Internal server error")
+			}
+			events = append(events, *event)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		return s.auditLog.AddEvents(ctx, events...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// change locks and reads the account before fn changes it in the same
+// transaction.
+func (s *AuditedService) change(ctx context.Context, method string, id uuid.UUID, fn func(ctx context.Context) (*model.BankAccount, error)) (changed *model.BankAccount, err error) {
+	err = s.txManager.Do(ctx, func(ctx context.Context) error {
+		before, err := s.Service.GetBankAccountById(ctx, id, auditedRead)
+		if err != nil {
+			return err
+		}
+		if changed, err = fn(ctx); err != nil {
+			return err
+		}
+		return s.record(ctx, method, before, changed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+func (s *AuditedService) record(ctx context.Context, method string, before *model.BankAccount, after *model.BankAccount) error {
+	var beforeDto *bank_accounts.BankAccountDto
+	if before != nil {
+		beforeDto = before.MapToDto()
+		auditedRead.Mask.Apply(beforeDto)
+	}
+	afterDto := after.MapToDto()
+	auditedRead.Mask.Apply(afterDto)
+	event, err := audit.NewEvent(ctx, audit.EntityAccount, after.ID, method, beforeDto, afterDto)
+	if err != nil {
+		return apperr.NewInternalServerError("Internal server error")
+	}
+	if event == nil {
+		return nil
+	}
+	return s.auditLog.AddEvents(ctx, *event)
+}
+
+// accountFields selects every field of an account but its subscriptions.
+func accountFields() model.ReadMask {
+	mask := make(model.ReadMask)
+	fields := (&bank_accounts.BankAccountDto{}).ProtoReflect().Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if name := string(fields.Get(i).Name()); name != model.FieldSubscriptions {
+			mask[name] = struct{}{}
+		}
+	}
+	return mask
+}
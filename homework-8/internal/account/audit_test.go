@@ -0,0 +1,203 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mock_account "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/mocks"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/audit"
+	mock_audit "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/audit/mocks"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/tests/fixtures"
+	"testing"
+)
+
+func TestAuditedService_UpdateBankAccount(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = app.WithPrincipal(context.Background(), &app.Principal{Subject: "dima"})
+		before  = fixtures.NewBankAccountBuilder().Valid().Build()
+		after   = fixtures.NewBankAccountBuilder().Valid().HolderName("Dima Petrov").Version(2).Build()
+		failure = apperr.NewPreconditionFailedError("Bank account was modified")
+	)
+
+	tests := []struct {
+		name          string
+		mock          func(db sqlmock.Sqlmock, service *mock_account.MockService, auditLog *mock_audit.MockRepository)
+		expectedError error
+	}{
+		{
+			name: "Change is recorded with the actor and the diff",
+			mock: func(db sqlmock.Sqlmock, service *mock_account.MockService, auditLog *mock_audit.MockRepository) {
+				db.ExpectBegin()
+				service.EXPECT().GetBankAccountById(gomock.Any(), before.ID, auditedRead).Return(before, nil)
+				service.EXPECT().UpdateBankAccount(gomock.Any(), before.ID, after, model.DefaultUpdateMask()).Return(after, nil)
+				auditLog.EXPECT().AddEvents(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, events ...audit.Event) error {
+					require.Len(t, events, 1)
+					assert.Equal(t, audit.EntityAccount, events[0].EntityType)
+					assert.Equal(t, before.ID, events[0].EntityID)
+					assert.Equal(t, "dima", events[0].Actor)
+					assert.Equal(t, "UpdateBankAccount", events[0].Method)
+					assert.Equal(t, audit.Diff{
+						"holderName": {Before: "Dima Sudakov", After: "Dima Petrov"},
+						"version":    {Before: "1", After: "2"},
+					}, events[0].Diff)
+					return nil
+				})
+				db.ExpectCommit()
+			},
+		},
+		{
+			name: "Failed change is not recorded",
+			mock: func(db sqlmock.Sqlmock, service *mock_account.MockService, auditLog *mock_audit.MockRepository) {
+				db.ExpectBegin()
+				service.EXPECT().GetBankAccountById(gomock.Any(), before.ID, auditedRead).Return(before, nil)
+				service.EXPECT().UpdateBankAccount(gomock.Any(), before.ID, after, model.DefaultUpdateMask()).Return(nil, failure)
+				db.ExpectRollback()
+			},
+			expectedError: failure,
+		},
+		{
+			name: "Failed record rolls the change back",
+			mock: func(db sqlmock.Sqlmock, service *mock_account.MockService, auditLog *mock_audit.MockRepository) {
+				db.ExpectBegin()
+				service.EXPECT().GetBankAccountById(gomock.Any(), before.ID, auditedRead).Return(before, nil)
+				service.EXPECT().UpdateBankAccount(gomock.Any(), before.ID, after, model.DefaultUpdateMask()).Return(after, nil)
+				auditLog.EXPECT().AddEvents(gomock.Any(), gomock.Any()).Return(apperr.NewInternalServerError("Internal server error"))
+				db.ExpectRollback()
+			},
+			expectedError: apperr.NewInternalServerError("Internal server error"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctrl := gomock.NewController(t)
+			sqlDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			db, err := database.InitDBWithPool(sqlDB)
+			require.NoError(t, err)
+			service := mock_account.NewMockService(ctrl)
+			auditLog := mock_audit.NewMockRepository(ctrl)
+			tc.mock(mock, service, auditLog)
+
+			audited := NewAuditedService(service, database.NewTxManager(db, sql.LevelDefault, 0), auditLog)
+			updated, err := audited.UpdateBankAccount(ctx, before.ID, after, model.DefaultUpdateMask())
+
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err)
+				assert.Nil(t, updated)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, after, updated)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuditedService_Transfer(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx      = context.Background()
+		from     = fixtures.NewBankAccountBuilder().Valid().Build()
+		to       = fixtures.NewBankAccountBuilder().Valid().ID(uuid.New()).Build()
+		transfer = &model.Transfer{FromID: from.ID, ToID: to.ID, Amount: 300}
+	)
+	fromAfter, toAfter := *from, *to
+	fromAfter.Balance, toAfter.Balance = 700, 1300
+
+	ctrl := gomock.NewController(t)
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := database.InitDBWithPool(sqlDB)
+	require.NoError(t, err)
+	service := mock_account.NewMockService(ctrl)
+	auditLog := mock_audit.NewMockRepository(ctrl)
+	audited := NewAuditedService(service, database.NewTxManager(db, sql.LevelDefault, 0), auditLog)
+
+	var recorded []audit.Event
+	record := func(ctx context.Context, events ...audit.Event) error {
+		recorded = append(recorded, events...)
+		return nil
+	}
+
+	// the accounts are locked before the transfer, in the order it locks them
+	accounts := map[uuid.UUID]*model.BankAccount{from.ID: from, to.ID: to}
+	firstID, secondID := transfer.LockOrder()
+	mock.ExpectBegin()
+	gomock.InOrder(
+		service.EXPECT().GetBankAccountById(gomock.Any(), firstID, auditedRead).Return(accounts[firstID], nil),
+		service.EXPECT().GetBankAccountById(gomock.Any(), secondID, auditedRead).Return(accounts[secondID], nil),
+		service.EXPECT().Transfer(gomock.Any(), transfer).Return(&fromAfter, &toAfter, nil),
+	)
+	auditLog.EXPECT().AddEvents(gomock.Any(), gomock.Any()).DoAndReturn(record).Times(2)
+	mock.ExpectCommit()
+
+	_, _, err = audited.Transfer(ctx, transfer)
+	require.NoError(t, err)
+	require.Len(t, recorded, 2)
+	assert.Equal(t, audit.ActorSystem, recorded[0].Actor)
+	assert.Equal(t, audit.Diff{"balance": {Before: "1000", After: "700"}}, recorded[0].Diff)
+	assert.Equal(t, audit.Diff{"balance": {Before: "1000", After: "1300"}}, recorded[1].Diff)
+
+	// a replayed transfer returns the balances unchanged
+	mock.ExpectBegin()
+	service.EXPECT().GetBankAccountById(gomock.Any(), from.ID, auditedRead).Return(&fromAfter, nil)
+	service.EXPECT().GetBankAccountById(gomock.Any(), to.ID, auditedRead).Return(&toAfter, nil)
+	service.EXPECT().Transfer(gomock.Any(), transfer).Return(&fromAfter, &toAfter, nil)
+	mock.ExpectCommit()
+
+	_, _, err = audited.Transfer(ctx, transfer)
+	require.NoError(t, err)
+	assert.Len(t, recorded, 2, "nothing changed, nothing is recorded")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuditedService_ImportBankAccounts(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx      = context.Background()
+		imported = fixtures.NewBankAccountBuilder().Valid().ID(uuid.New()).Build()
+		invalid  = fixtures.NewBankAccountBuilder().Invalid().Build()
+		rows     = []model.ImportRow{{Index: 0, Account: imported}, {Index: 1, Account: invalid}}
+		result   = &model.ImportResult{Imported: 1, Failures: []model.ImportFailure{{Index: 1, Message: "HolderName: must be in a valid format."}}}
+	)
+
+	ctrl := gomock.NewController(t)
+	sqlDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := database.InitDBWithPool(sqlDB)
+	require.NoError(t, err)
+	service := mock_account.NewMockService(ctrl)
+	auditLog := mock_audit.NewMockRepository(ctrl)
+	audited := NewAuditedService(service, database.NewTxManager(db, sql.LevelDefault, 0), auditLog)
+
+	mock.ExpectBegin()
+	service.EXPECT().ImportBankAccounts(gomock.Any(), rows).Return(result, nil)
+	auditLog.EXPECT().AddEvents(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, events ...audit.Event) error {
+		require.Len(t, events, 1)
+		assert.Equal(t, imported.ID, events[0].EntityID)
+		assert.Equal(t, "ImportBankAccounts", events[0].Method)
+		assert.Equal(t, audit.Change{After: "Dima Sudakov"}, events[0].Diff["holderName"])
+		return nil
+	})
+	mock.ExpectCommit()
+
+	got, err := audited.ImportBankAccounts(ctx, rows)
+	require.NoError(t, err)
+	assert.Equal(t, result, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
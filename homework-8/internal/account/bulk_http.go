@@ -0,0 +1,294 @@
+package account
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	contentTypeCSV    = "text/csv"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// maxNDJSONLineSize bounds one account of an NDJSON import. An account carries
+// its subscriptions, so a line may well exceed the 64 KiB bufio.Scanner
+// accepts by default.
+const maxNDJSONLineSize = 8 << 20
+
+// csvImportColumns are the columns accepted by the CSV import. Only
+// holder_name and bank_name are required; accounts without an owner_id are
+// owned by the caller.
+var csvImportColumns = map[string]bool{
+	"id":          true,
+	"holder_name": true,
+	"balance":     true,
+	"currency":    true,
+	"bank_name":   true,
+	"owner_id":    true,
+}
+
+// csvExportColumns are the columns of the CSV export. CSV has no place for the
+// subscriptions of an account; NDJSON carries them.
+var csvExportColumns = []string{"id", "holder_name", "balance", "currency", "bank_name", "owner_id", "opening_date", "version", "status"}
+
+// RegisterBulkHandlers serves ImportBankAccounts and ExportBankAccounts over
+// HTTP in CSV and NDJSON. grpc-gateway only maps streaming RPCs to its own
+// JSON framing, so these routes call the streaming RPCs through client. They
+// have to be registered after the generated handlers to take precedence over
+// GET /bank-accounts/{id}.
+func RegisterBulkHandlers(mux *runtime.ServeMux, client bank_accounts.BankAccountServiceClient) error {
+	if err := mux.HandlePath(http.MethodPost, "/bank-accounts/import", importHandler(mux, client)); err != nil {
+		return err
+	}
+	return mux.HandlePath(http.MethodGet, "/bank-accounts/export", exportHandler(mux, client))
+}
+
+func importHandler(mux *runtime.ServeMux, client bank_accounts.BankAccountServiceClient) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		_, outbound := runtime.MarshalerForRequest(mux, r)
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r, bank_accounts.BankAccountService_ImportBankAccounts_FullMethodName)
+		if err != nil {
+			runtime.HTTPError(r.Context(), mux, outbound, w, r, err)
+			return
+		}
+
+		var read func() (*bank_accounts.BankAccountDto, error)
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case contentTypeCSV:
+			read, err = csvAccountReader(r.Body)
+		case contentTypeNDJSON:
+			read = ndjsonAccountReader(r.Body)
+		default:
+			err = fmt.Errorf("content type must be %s or %s", contentTypeCSV, contentTypeNDJSON)
+		}
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, apperr.NewBadRequestError(err.Error()).GRPCStatus().Err())
+			return
+		}
+
+		response, err := importAccounts(ctx, client, read)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		runtime.ForwardResponseMessage(ctx, mux, outbound, w, r, response)
+	}
+}
+
+// importAccounts sends the accounts returned by read until io.EOF. A body that
+// cannot be parsed cancels the whole import.
+func importAccounts(ctx context.Context, client bank_accounts.BankAccountServiceClient, read func() (*bank_accounts.BankAccountDto, error)) (*bank_accounts.ImportBankAccountsResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.ImportBankAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		account, err := read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, apperr.NewBadRequestError(err.Error()).GRPCStatus().Err()
+		}
+		if err = stream.Send(&bank_accounts.ImportBankAccountsRequest{Account: account}); err != nil {
+			// the server has failed, CloseAndRecv returns its status
+			break
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+func csvAccountReader(body io.Reader) (func() (*bank_accounts.BankAccountDto, error), error) {
+	reader := csv.NewReader(body)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the CSV header: %w", err)
+	}
+	columns := make([]string, len(header))
+	for i, column := range header {
+		if !csvImportColumns[column] {
+			return nil, fmt.Errorf("unknown CSV column %q", column)
+		}
+		columns[i] = column
+	}
+
+	return func() (*bank_accounts.BankAccountDto, error) {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		account := &bank_accounts.BankAccountDto{}
+		for i, value := range record {
+			switch columns[i] {
+			case "id":
+				if value != "" {
+					account.Id = &bank_accounts.UUID{Value: value}
+				}
+			case "holder_name":
+				account.HolderName = value
+			case "balance":
+				if value == "" {
+					continue
+				}
+				line, _ := reader.FieldPos(i)
+				account.Balance, err = strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: balance must be an integer amount of minor units", line)
+				}
+			case "currency":
+				account.Currency = value
+			case "bank_name":
+				account.BankName = value
+			case "owner_id":
+				account.OwnerId = value
+			}
+		}
+		return account, nil
+	}, nil
+}
+
+func ndjsonAccountReader(body io.Reader) func() (*bank_accounts.BankAccountDto, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxNDJSONLineSize)
+	line := 0
+	return func() (*bank_accounts.BankAccountDto, error) {
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			account := &bank_accounts.BankAccountDto{}
+			if err := protojson.Unmarshal(scanner.Bytes(), account); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			return account, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+1, err)
+		}
+		return nil, io.EOF
+	}
+}
+
+func exportHandler(mux *runtime.ServeMux, client bank_accounts.BankAccountServiceClient) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		_, outbound := runtime.MarshalerForRequest(mux, r)
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r, bank_accounts.BankAccountService_ExportBankAccounts_FullMethodName)
+		if err != nil {
+			runtime.HTTPError(r.Context(), mux, outbound, w, r, err)
+			return
+		}
+
+		var write func(account *bank_accounts.BankAccountDto) error
+		switch format := r.URL.Query().Get("format"); format {
+		case "csv":
+			w.Header().Set("Content-Type", contentTypeCSV)
+			write = csvAccountWriter(w)
+		case "", "ndjson":
+			w.Header().Set("Content-Type", contentTypeNDJSON)
+			write = ndjsonAccountWriter(w)
+		default:
+			err = apperr.NewBadRequestError(fmt.Sprintf("unknown export format %q", format)).GRPCStatus().Err()
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+
+		stream, err := client.ExportBankAccounts(ctx, &bank_accounts.ExportBankAccountsRequest{})
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		// the status can still be changed until the first account is written
+		response, err := stream.Recv()
+		if err != nil && !errors.Is(err, io.EOF) {
+			w.Header().Del("Content-Type")
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+
+		flusher, _ := w.(http.Flusher)
+		for err == nil {
+			if err = write(response.GetAccount()); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			response, err = stream.Recv()
+		}
+		if !errors.Is(err, io.EOF) {
+			// the client finds a truncated body, the status is already sent
+			return
+		}
+		_ = write(nil)
+	}
+}
+
+// csvAccountWriter writes the header before the first account. A nil account
+// ends the export.
+func csvAccountWriter(w io.Writer) func(account *bank_accounts.BankAccountDto) error {
+	writer := csv.NewWriter(w)
+	headerWritten := false
+	return func(account *bank_accounts.BankAccountDto) error {
+		if !headerWritten {
+			if err := writer.Write(csvExportColumns); err != nil {
+				return err
+			}
+			headerWritten = true
+		}
+		if account != nil {
+			err := writer.Write([]string{
+				account.GetId().GetValue(),
+				account.GetHolderName(),
+				strconv.FormatInt(account.GetBalance(), 10),
+				account.GetCurrency(),
+				account.GetBankName(),
+				account.GetOwnerId(),
+				account.GetOpeningDate().GetValue().AsTime().Format(time.RFC3339),
+				strconv.FormatInt(account.GetVersion(), 10),
+				account.GetStatus(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+}
+
+func ndjsonAccountWriter(w io.Writer) func(account *bank_accounts.BankAccountDto) error {
+	return func(account *bank_accounts.BankAccountDto) error {
+		if account == nil {
+			return nil
+		}
+		line, err := protojson.Marshal(account)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(line, '\n'))
+		return err
+	}
+}
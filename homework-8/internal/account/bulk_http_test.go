@@ -0,0 +1,138 @@
+package account
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, read func() (*bank_accounts.BankAccountDto, error)) ([]*bank_accounts.BankAccountDto, error) {
+	t.Helper()
+	var accounts []*bank_accounts.BankAccountDto
+	for {
+		account, err := read()
+		if errors.Is(err, io.EOF) {
+			return accounts, nil
+		}
+		if err != nil {
+			return accounts, err
+		}
+		accounts = append(accounts, account)
+	}
+}
+
+func TestCSVAccountReader(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		body     string
+		expected []*bank_accounts.BankAccountDto
+		err      string
+	}{
+		{
+			name: "success",
+			body: "holder_name,bank_name,balance,currency,id,owner_id\n" +
+				"Ivan Ivanov,Tinkoff,100,USD,331684ab-5af8-439a-8a4f-62a571013283,ivan\n" +
+				"Petr Petrov,Sber,,,,\n",
+			expected: []*bank_accounts.BankAccountDto{
+				{
+					Id:         &bank_accounts.UUID{Value: "331684ab-5af8-439a-8a4f-62a571013283"},
+					HolderName: "Ivan Ivanov",
+					BankName:   "Tinkoff",
+					Balance:    100,
+					Currency:   "USD",
+					OwnerId:    "ivan",
+				},
+				{HolderName: "Petr Petrov", BankName: "Sber"},
+			},
+		},
+		{
+			name: "unknown column",
+			body: "holder_name,owner\n",
+			err:  `unknown CSV column "owner"`,
+		},
+		{
+			name: "invalid balance",
+			body: "holder_name,balance\nIvan Ivanov,1.5\n",
+			err:  "line 2: balance must be an integer amount of minor units",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			read, err := csvAccountReader(strings.NewReader(tt.body))
+			if err == nil {
+				var accounts []*bank_accounts.BankAccountDto
+				accounts, err = readAll(t, read)
+				if tt.err == "" {
+					assert.Equal(t, tt.expected, accounts)
+				}
+			}
+
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNDJSONAccountReader(t *testing.T) {
+	t.Parallel()
+
+	body := `{"holderName": "Ivan Ivanov", "bankName": "Tinkoff", "balance": "100"}` + "\n\n" + `{"holderName": 1}` + "\n"
+
+	accounts, err := readAll(t, ndjsonAccountReader(strings.NewReader(body)))
+
+	require.Len(t, accounts, 1)
+	assert.Equal(t, "Ivan Ivanov", accounts[0].HolderName)
+	assert.Equal(t, int64(100), accounts[0].Balance)
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "line 3: "))
+}
+
+func TestNDJSONAccountReader_LongLines(t *testing.T) {
+	t.Parallel()
+
+	holderName := strings.Repeat("a", 2*bufio.MaxScanTokenSize)
+	body := `{"holderName": "` + holderName + `", "bankName": "Tinkoff"}` + "\n" +
+		`{"holderName": "` + strings.Repeat("a", maxNDJSONLineSize) + `"}` + "\n"
+
+	accounts, err := readAll(t, ndjsonAccountReader(strings.NewReader(body)))
+
+	require.Len(t, accounts, 1)
+	assert.Equal(t, holderName, accounts[0].HolderName)
+	assert.ErrorIs(t, err, bufio.ErrTooLong)
+	assert.True(t, strings.HasPrefix(err.Error(), "line 2: "))
+}
+
+func TestCSVAccountWriter(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	write := csvAccountWriter(&buf)
+
+	require.NoError(t, write(&bank_accounts.BankAccountDto{
+		Id:         &bank_accounts.UUID{Value: "331684ab-5af8-439a-8a4f-62a571013283"},
+		HolderName: "Ivan, Ivanov",
+		Balance:    100,
+		Currency:   "RUB",
+		BankName:   "Tinkoff",
+		OwnerId:    "ivan",
+		Version:    2,
+		Status:     "closed",
+	}))
+	require.NoError(t, write(nil))
+
+	assert.Equal(t, "id,holder_name,balance,currency,bank_name,owner_id,opening_date,version,status\n"+
+		"331684ab-5af8-439a-8a4f-62a571013283,\"Ivan, Ivanov\",100,RUB,Tinkoff,ivan,1970-01-01T00:00:00Z,2,closed\n", buf.String())
+}
@@ -0,0 +1,196 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/watch"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/cache"
+	"sync/atomic"
+	"time"
+)
+
+// invalidateTimeout bounds the removal of changed accounts from the cache. It
+// runs after the commit, when the context of the call may already be done.
+const invalidateTimeout = 5 * time.Second
+
+// tombstoneTTL is how long a changed account stays marked as dropped. A read
+// that loaded the account before the change and fills the cache within this
+// time cannot store the old state.
+const tombstoneTTL = 30 * time.Second
+
+// tombstone replaces a dropped account. No account encodes to it.
+var tombstone = []byte{}
+
+// CachingRepository reads accounts by ID through a cache. Other reads and all
+// writes are passed through.
+//
+// A cached account is dropped after the commit of every change made through
+// the repository. Changes made elsewhere, e.g. by billing, by a subscription
+// change or on another replica, are dropped when the watch.Listener publishes
+// them to the repository. The TTL bounds the life of an account whose change
+// could not be dropped because the cache was unreachable.
+//
+// A read filling the cache races with the drop of a change committed after it
+// loaded the account. Dropped accounts are therefore replaced by a tombstone
+// and the cache is only filled where no value is cached.
+type CachingRepository struct {
+	Repository
+	cache cache.Cache
+	ttl   time.Duration
+	// generation counts PublishAll calls, a read started before one does not
+	// fill the cache
+	generation atomic.Uint64
+}
+
+func NewCachingRepository(repository Repository, cache cache.Cache, ttl time.Duration) *CachingRepository {
+	return &CachingRepository{
+		Repository: repository,
+		cache:      cache,
+		ttl:        ttl,
+	}
+}
+
+func cacheKey(id uuid.UUID) string {
+	return "bank_account:" + id.String()
+}
+
+// GetBankAccountByID reads from the database inside a transaction, which must
+// see its own changes and should not cache any that may be rolled back. A
+// failing cache is logged and the account is read from the database.
+//
+// Accounts are cached with their subscriptions. A read without them is served
+// from the cache too, but a miss is not filled by it.
+func (r *CachingRepository) GetBankAccountByID(ctx context.Context, id uuid.UUID, withSubscriptions bool) (*model.BankAccount, error) {
+	if _, ok := database.TxFromContext(ctx); ok {
+		return r.Repository.GetBankAccountByID(ctx, id, withSubscriptions)
+	}
+
+	generation := r.generation.Load()
+	value, err := r.cache.Get(ctx, cacheKey(id))
+	if err == nil && len(value) == 0 {
+		// changed a moment ago, reads go to the database until it expires
+		err = cache.ErrMiss
+	}
+	if err == nil {
+		var bankAccount model.BankAccount
+		if err = json.Unmarshal(value, &bankAccount); err == nil {
+			if !withSubscriptions {
+				bankAccount.Subscriptions = nil
+			}
+			return &bankAccount, nil
+		}
+	}
+	if !errors.Is(err, cache.ErrMiss) {
+		logging.Errorf(ctx, "cannot read bank account %s from cache: %s", id, err)
+	}
+
+	bankAccount, err := r.Repository.GetBankAccountByID(ctx, id, withSubscriptions)
+	if err != nil || !withSubscriptions {
+		return bankAccount, err
+	}
+
+	if err := r.fill(ctx, generation, bankAccount); err != nil {
+		logging.Errorf(ctx, "cannot cache bank account %s: %s", id, err)
+	}
+	return bankAccount, nil
+}
+
+// fill caches an account loaded in the generation, unless the cache has been
+// cleared since or holds the account or its tombstone already.
+func (r *CachingRepository) fill(ctx context.Context, generation uint64, bankAccount *model.BankAccount) error {
+	value, err := json.Marshal(bankAccount)
+	if err != nil {
+		return err
+	}
+	if r.generation.Load() != generation {
+		return nil
+	}
+	_, err = r.cache.Add(ctx, cacheKey(bankAccount.ID), value, r.ttl)
+	return err
+}
+
+func (r *CachingRepository) UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount, mask model.UpdateMask) (*model.BankAccount, error) {
+	bankAccount, err := r.Repository.UpdateBankAccount(ctx, id, account, mask)
+	if err != nil {
+		return nil, err
+	}
+	r.Invalidate(ctx, id)
+	return bankAccount, nil
+}
+
+func (r *CachingRepository) CloseBankAccount(ctx context.Context, id uuid.UUID, closedAt time.Time) (*model.BankAccount, error) {
+	bankAccount, err := r.Repository.CloseBankAccount(ctx, id, closedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.Invalidate(ctx, id)
+	return bankAccount, nil
+}
+
+func (r *CachingRepository) FreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	bankAccount, err := r.Repository.FreezeBankAccount(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.Invalidate(ctx, id)
+	return bankAccount, nil
+}
+
+func (r *CachingRepository) UnfreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	bankAccount, err := r.Repository.UnfreezeBankAccount(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.Invalidate(ctx, id)
+	return bankAccount, nil
+}
+
+func (r *CachingRepository) Transfer(ctx context.Context, transfer *model.Transfer) (*model.BankAccount, *model.BankAccount, error) {
+	from, to, err := r.Repository.Transfer(ctx, transfer)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.Invalidate(ctx, transfer.FromID, transfer.ToID)
+	return from, to, nil
+}
+
+// Invalidate drops the accounts from the cache once the transaction of ctx is
+// committed. Until then other readers still see the old state in the database.
+func (r *CachingRepository) Invalidate(ctx context.Context, ids ...uuid.UUID) {
+	database.AfterCommit(ctx, func() {
+		r.drop(ctx, ids...)
+	})
+}
+
+// Publish drops an account changed outside of the repository. Together with
+// PublishAll it makes the repository a watch.Publisher.
+func (r *CachingRepository) Publish(change watch.Change) {
+	r.drop(context.Background(), change.AccountID)
+}
+
+// PublishAll drops every account, changes may have been missed.
+func (r *CachingRepository) PublishAll() {
+	r.generation.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), invalidateTimeout)
+	defer cancel()
+	if err := r.cache.Clear(ctx); err != nil {
+		logging.Errorf(ctx, "cannot clear bank account cache: %s", err)
+	}
+}
+
+// drop replaces the accounts by tombstones. It logs with ctx, which may be
+// done already, and writes with a context of its own.
+func (r *CachingRepository) drop(ctx context.Context, ids ...uuid.UUID) {
+	dropCtx, cancel := context.WithTimeout(context.Background(), invalidateTimeout)
+	defer cancel()
+	for _, id := range ids {
+		if err := r.cache.Set(dropCtx, cacheKey(id), tombstone, tombstoneTTL); err != nil {
+			logging.Errorf(ctx, "cannot drop bank account %s from cache: %s", id, err)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mock_account "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/mocks"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/watch"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/cache"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/subscription"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/tests/fixtures"
+	"testing"
+	"time"
+)
+
+// unreachableCache fails like a cache server that is down.
+type unreachableCache struct{}
+
+var errUnreachable = errors.New("connection refused")
+
+func (unreachableCache) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, errUnreachable
+}
+
+func (unreachableCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return errUnreachable
+}
+
+func (unreachableCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return false, errUnreachable
+}
+
+func (unreachableCache) Delete(ctx context.Context, keys ...string) error {
+	return errUnreachable
+}
+
+func (unreachableCache) Clear(ctx context.Context) error {
+	return errUnreachable
+}
+
+func TestCachingRepository_GetBankAccountByID(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx         = context.Background()
+		bankAccount = fixtures.NewBankAccountBuilder().Valid().Build()
+		notFound    = apperr.NewNotFoundError("Bank account with ID: " + bankAccount.ID.String() + " not found")
+	)
+
+	t.Run("Second read is served from the cache", func(t *testing.T) {
+		t.Parallel()
+		repo := mock_account.NewMockRepository(gomock.NewController(t))
+		repo.EXPECT().GetBankAccountByID(ctx, bankAccount.ID, true).Return(bankAccount, nil).Times(1)
+		caching := NewCachingRepository(repo, cache.NewMemoryCache(), time.Minute)
+
+		for i := 0; i < 2; i++ {
+			got, err := caching.GetBankAccountByID(ctx, bankAccount.ID, true)
+			require.NoError(t, err)
+			assert.Equal(t, bankAccount, got)
+		}
+	})
+
+	t.Run("Missing account is not cached", func(t *testing.T) {
+		t.Parallel()
+		repo := mock_account.NewMockRepository(gomock.NewController(t))
+		repo.EXPECT().GetBankAccountByID(ctx, bankAccount.ID, true).Return(nil, notFound).Times(2)
+		caching := NewCachingRepository(repo, cache.NewMemoryCache(), time.Minute)
+
+		for i := 0; i < 2; i++ {
+			_, err := caching.GetBankAccountByID(ctx, bankAccount.ID, true)
+			assert.Equal(t, notFound, err)
+		}
+	})
+
+	t.Run("Read without subscriptions", func(t *testing.T) {
+		t.Parallel()
+		withSubscriptions := fixtures.NewBankAccountBuilder().Valid().Subscriptions([]subscription.Subscription{{ID: uuid.New(), Name: "Music"}}).Build()
+		withoutSubscriptions := fixtures.NewBankAccountBuilder().Valid().Build()
+		repo := mock_account.NewMockRepository(gomock.NewController(t))
+		gomock.InOrder(
+			repo.EXPECT().GetBankAccountByID(ctx, withSubscriptions.ID, false).Return(withoutSubscriptions, nil),
+			repo.EXPECT().GetBankAccountByID(ctx, withSubscriptions.ID, true).Return(withSubscriptions, nil),
+		)
+		caching := NewCachingRepository(repo, cache.NewMemoryCache(), time.Minute)
+
+		got, err := caching.GetBankAccountByID(ctx, withSubscriptions.ID, false)
+		require.NoError(t, err)
+		assert.Empty(t, got.Subscriptions)
+		got, err = caching.GetBankAccountByID(ctx, withSubscriptions.ID, true)
+		require.NoError(t, err)
+		assert.Len(t, got.Subscriptions, 1, "a read without subscriptions does not fill the cache")
+
+		got, err = caching.GetBankAccountByID(ctx, withSubscriptions.ID, false)
+		require.NoError(t, err)
+		assert.Empty(t, got.Subscriptions, "served from the cache without them")
+	})
+
+	t.Run("Unreachable cache falls back to the database", func(t *testing.T) {
+		t.Parallel()
+		repo := mock_account.NewMockRepository(gomock.NewController(t))
+		repo.EXPECT().GetBankAccountByID(ctx, bankAccount.ID, true).Return(bankAccount, nil)
+		caching := NewCachingRepository(repo, unreachableCache{}, time.Minute)
+
+		got, err := caching.GetBankAccountByID(ctx, bankAccount.ID, true)
+		require.NoError(t, err)
+		assert.Equal(t, bankAccount, got)
+	})
+
+	t.Run("Change dropped while the account is loaded is not cached", func(t *testing.T) {
+		t.Parallel()
+		var (
+			stale   = fixtures.NewBankAccountBuilder().Valid().Balance(1000).Build()
+			changed = fixtures.NewBankAccountBuilder().Valid().Balance(700).Version(2).Build()
+			repo    = mock_account.NewMockRepository(gomock.NewController(t))
+			caching = NewCachingRepository(repo, cache.NewMemoryCache(), time.Minute)
+		)
+		gomock.InOrder(
+			// the transfer commits after the reader loaded the old row but
+			// before the reader fills the cache
+			repo.EXPECT().GetBankAccountByID(ctx, stale.ID, true).DoAndReturn(
+				func(ctx context.Context, id uuid.UUID, withSubscriptions bool) (*model.BankAccount, error) {
+					caching.Invalidate(ctx, id)
+					return stale, nil
+				}),
+			repo.EXPECT().GetBankAccountByID(ctx, stale.ID, true).Return(changed, nil),
+		)
+
+		got, err := caching.GetBankAccountByID(ctx, stale.ID, true)
+		require.NoError(t, err)
+		assert.Equal(t, stale, got)
+		got, err = caching.GetBankAccountByID(ctx, stale.ID, true)
+		require.NoError(t, err)
+		assert.Equal(t, changed, got, "the old balance was not cached")
+	})
+
+	t.Run("Clear while the account is loaded is not cached", func(t *testing.T) {
+		t.Parallel()
+		var (
+			repo    = mock_account.NewMockRepository(gomock.NewController(t))
+			memory  = cache.NewMemoryCache()
+			caching = NewCachingRepository(repo, memory, time.Minute)
+		)
+		repo.EXPECT().GetBankAccountByID(ctx, bankAccount.ID, true).DoAndReturn(
+			func(ctx context.Context, id uuid.UUID, withSubscriptions bool) (*model.BankAccount, error) {
+				caching.PublishAll()
+				return bankAccount, nil
+			})
+
+		_, err := caching.GetBankAccountByID(ctx, bankAccount.ID, true)
+		require.NoError(t, err)
+		_, err = memory.Get(ctx, cacheKey(bankAccount.ID))
+		assert.ErrorIs(t, err, cache.ErrMiss)
+	})
+
+	t.Run("Transaction reads the database", func(t *testing.T) {
+		t.Parallel()
+		sqlDB, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		db, err := database.InitDBWithPool(sqlDB)
+		require.NoError(t, err)
+		repo := mock_account.NewMockRepository(gomock.NewController(t))
+		repo.EXPECT().GetBankAccountByID(gomock.Any(), bankAccount.ID, true).Return(bankAccount, nil).Times(2)
+		memory := cache.NewMemoryCache()
+		caching := NewCachingRepository(repo, memory, time.Minute)
+
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+		err = database.NewTxManager(db, sql.LevelDefault, 0).Do(ctx, func(ctx context.Context) error {
+			_, err := caching.GetBankAccountByID(ctx, bankAccount.ID, true)
+			return err
+		})
+		require.NoError(t, err)
+
+		_, err = memory.Get(ctx, cacheKey(bankAccount.ID))
+		assert.ErrorIs(t, err, cache.ErrMiss, "nothing is cached in a transaction")
+		_, err = caching.GetBankAccountByID(ctx, bankAccount.ID, true)
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCachingRepository_Invalidation(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		before  = fixtures.NewBankAccountBuilder().Valid().Build()
+		updated = fixtures.NewBankAccountBuilder().Valid().Balance(700).Version(2).Build()
+		to      = fixtures.NewBankAccountBuilder().Valid().ID(uuid.New()).Build()
+	)
+
+	// cached reads every account once and returns whether it is still cached.
+	cached := func(t *testing.T, memory *cache.MemoryCache, ids ...uuid.UUID) bool {
+		for _, id := range ids {
+			if value, err := memory.Get(ctx, cacheKey(id)); err != nil || len(value) == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	tests := []struct {
+		name   string
+		ids    []uuid.UUID
+		mock   func(repo *mock_account.MockRepository)
+		change func(ctx context.Context, caching *CachingRepository) error
+	}{
+		{
+			name: "Update",
+			ids:  []uuid.UUID{before.ID},
+			mock: func(repo *mock_account.MockRepository) {
+				repo.EXPECT().UpdateBankAccount(gomock.Any(), before.ID, updated, model.DefaultUpdateMask()).Return(updated, nil)
+			},
+			change: func(ctx context.Context, caching *CachingRepository) error {
+				_, err := caching.UpdateBankAccount(ctx, before.ID, updated, model.DefaultUpdateMask())
+				return err
+			},
+		},
+		{
+			name: "Close",
+			ids:  []uuid.UUID{before.ID},
+			mock: func(repo *mock_account.MockRepository) {
+				repo.EXPECT().CloseBankAccount(gomock.Any(), before.ID, gomock.Any()).Return(updated, nil)
+			},
+			change: func(ctx context.Context, caching *CachingRepository) error {
+				_, err := caching.CloseBankAccount(ctx, before.ID, time.Now())
+				return err
+			},
+		},
+		{
+			name: "Freeze",
+			ids:  []uuid.UUID{before.ID},
+			mock: func(repo *mock_account.MockRepository) {
+				repo.EXPECT().FreezeBankAccount(gomock.Any(), before.ID).Return(updated, nil)
+			},
+			change: func(ctx context.Context, caching *CachingRepository) error {
+				_, err := caching.FreezeBankAccount(ctx, before.ID)
+				return err
+			},
+		},
+		{
+			name: "Transfer drops both accounts",
+			ids:  []uuid.UUID{before.ID, to.ID},
+			mock: func(repo *mock_account.MockRepository) {
+				repo.EXPECT().Transfer(gomock.Any(), gomock.Any()).Return(updated, to, nil)
+			},
+			change: func(ctx context.Context, caching *CachingRepository) error {
+				_, _, err := caching.Transfer(ctx, &model.Transfer{FromID: before.ID, ToID: to.ID, Amount: 300})
+				return err
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			sqlDB, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			db, err := database.InitDBWithPool(sqlDB)
+			require.NoError(t, err)
+			repo := mock_account.NewMockRepository(gomock.NewController(t))
+			repo.EXPECT().GetBankAccountByID(ctx, before.ID, true).Return(before, nil)
+			repo.EXPECT().GetBankAccountByID(ctx, to.ID, true).Return(to, nil)
+			tc.mock(repo)
+			memory := cache.NewMemoryCache()
+			caching := NewCachingRepository(repo, memory, time.Minute)
+
+			for _, id := range []uuid.UUID{before.ID, to.ID} {
+				_, err = caching.GetBankAccountByID(ctx, id, true)
+				require.NoError(t, err)
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectCommit()
+			err = database.NewTxManager(db, sql.LevelDefault, 0).Do(ctx, func(ctx context.Context) error {
+				if err := tc.change(ctx, caching); err != nil {
+					return err
+				}
+				assert.True(t, cached(t, memory, tc.ids...), "kept until the commit")
+				return nil
+			})
+			require.NoError(t, err)
+
+			for _, id := range tc.ids {
+				assert.False(t, cached(t, memory, id), "dropped after the commit")
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("Failed change keeps the account", func(t *testing.T) {
+		t.Parallel()
+		repo := mock_account.NewMockRepository(gomock.NewController(t))
+		repo.EXPECT().GetBankAccountByID(ctx, before.ID, true).Return(before, nil)
+		repo.EXPECT().FreezeBankAccount(ctx, before.ID).Return(nil, apperr.NewBadRequestError("Bank account is frozen"))
+		memory := cache.NewMemoryCache()
+		caching := NewCachingRepository(repo, memory, time.Minute)
+
+		_, err := caching.GetBankAccountByID(ctx, before.ID, true)
+		require.NoError(t, err)
+		_, err = caching.FreezeBankAccount(ctx, before.ID)
+		require.Error(t, err)
+		assert.True(t, cached(t, memory, before.ID))
+	})
+
+	t.Run("Published changes drop accounts", func(t *testing.T) {
+		t.Parallel()
+		repo := mock_account.NewMockRepository(gomock.NewController(t))
+		repo.EXPECT().GetBankAccountByID(ctx, before.ID, true).Return(before, nil)
+		repo.EXPECT().GetBankAccountByID(ctx, to.ID, true).Return(to, nil)
+		memory := cache.NewMemoryCache()
+		caching := NewCachingRepository(repo, memory, time.Minute)
+		for _, id := range []uuid.UUID{before.ID, to.ID} {
+			_, err := caching.GetBankAccountByID(ctx, id, true)
+			require.NoError(t, err)
+		}
+
+		// e.g. billing charged the account or one of its subscriptions changed
+		caching.Publish(watch.Change{AccountID: before.ID})
+		assert.False(t, cached(t, memory, before.ID))
+		assert.True(t, cached(t, memory, to.ID))
+
+		caching.PublishAll()
+		assert.False(t, cached(t, memory, to.ID))
+	})
+}
@@ -7,6 +7,7 @@ package mock_account
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	uuid "github.com/google/uuid"
@@ -36,6 +37,21 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
+// CloseBankAccount mocks base method.
+func (m *MockRepository) CloseBankAccount(ctx context.Context, id uuid.UUID, closedAt time.Time) (*model.BankAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloseBankAccount", ctx, id, closedAt)
+	ret0, _ := ret[0].(*model.BankAccount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CloseBankAccount indicates an expected call of CloseBankAccount.
+func (mr *MockRepositoryMockRecorder) CloseBankAccount(ctx, id, closedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseBankAccount", reflect.TypeOf((*MockRepository)(nil).CloseBankAccount), ctx, id, closedAt)
+}
+
 // CreateBankAccount mocks base method.
 func (m *MockRepository) CreateBankAccount(ctx context.Context, account *model.BankAccount) (*model.BankAccount, error) {
 	m.ctrl.T.Helper()
@@ -51,47 +67,193 @@ func (mr *MockRepositoryMockRecorder) CreateBankAccount(ctx, account interface{}
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBankAccount", reflect.TypeOf((*MockRepository)(nil).CreateBankAccount), ctx, account)
 }
 
-// DeleteBankAccount mocks base method.
-func (m *MockRepository) DeleteBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+// ExportBankAccounts mocks base method.
+func (m *MockRepository) ExportBankAccounts(ctx context.Context, pageSize int, fn func([]model.BankAccount) error) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteBankAccount", ctx, id)
+	ret := m.ctrl.Call(m, "ExportBankAccounts", ctx, pageSize, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportBankAccounts indicates an expected call of ExportBankAccounts.
+func (mr *MockRepositoryMockRecorder) ExportBankAccounts(ctx, pageSize, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportBankAccounts", reflect.TypeOf((*MockRepository)(nil).ExportBankAccounts), ctx, pageSize, fn)
+}
+
+// FreezeBankAccount mocks base method.
+func (m *MockRepository) FreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FreezeBankAccount", ctx, id)
 	ret0, _ := ret[0].(*model.BankAccount)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// DeleteBankAccount indicates an expected call of DeleteBankAccount.
-func (mr *MockRepositoryMockRecorder) DeleteBankAccount(ctx, id interface{}) *gomock.Call {
+// FreezeBankAccount indicates an expected call of FreezeBankAccount.
+func (mr *MockRepositoryMockRecorder) FreezeBankAccount(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBankAccount", reflect.TypeOf((*MockRepository)(nil).DeleteBankAccount), ctx, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FreezeBankAccount", reflect.TypeOf((*MockRepository)(nil).FreezeBankAccount), ctx, id)
 }
 
 // GetBankAccountByID mocks base method.
-func (m *MockRepository) GetBankAccountByID(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+func (m *MockRepository) GetBankAccountByID(ctx context.Context, id uuid.UUID, withSubscriptions bool) (*model.BankAccount, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetBankAccountByID", ctx, id)
+	ret := m.ctrl.Call(m, "GetBankAccountByID", ctx, id, withSubscriptions)
 	ret0, _ := ret[0].(*model.BankAccount)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetBankAccountByID indicates an expected call of GetBankAccountByID.
-func (mr *MockRepositoryMockRecorder) GetBankAccountByID(ctx, id interface{}) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) GetBankAccountByID(ctx, id, withSubscriptions interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBankAccountByID", reflect.TypeOf((*MockRepository)(nil).GetBankAccountByID), ctx, id, withSubscriptions)
+}
+
+// ImportBankAccounts mocks base method.
+func (m *MockRepository) ImportBankAccounts(ctx context.Context, accounts []model.BankAccount) ([]uuid.UUID, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportBankAccounts", ctx, accounts)
+	ret0, _ := ret[0].([]uuid.UUID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImportBankAccounts indicates an expected call of ImportBankAccounts.
+func (mr *MockRepositoryMockRecorder) ImportBankAccounts(ctx, accounts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportBankAccounts", reflect.TypeOf((*MockRepository)(nil).ImportBankAccounts), ctx, accounts)
+}
+
+// ListBankAccounts mocks base method.
+func (m *MockRepository) ListBankAccounts(ctx context.Context, filter *model.BankAccountFilter) ([]model.BankAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListBankAccounts", ctx, filter)
+	ret0, _ := ret[0].([]model.BankAccount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListBankAccounts indicates an expected call of ListBankAccounts.
+func (mr *MockRepositoryMockRecorder) ListBankAccounts(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListBankAccounts", reflect.TypeOf((*MockRepository)(nil).ListBankAccounts), ctx, filter)
+}
+
+// ListLedgerEntries mocks base method.
+func (m *MockRepository) ListLedgerEntries(ctx context.Context, filter *model.LedgerFilter) ([]model.LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListLedgerEntries", ctx, filter)
+	ret0, _ := ret[0].([]model.LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListLedgerEntries indicates an expected call of ListLedgerEntries.
+func (mr *MockRepositoryMockRecorder) ListLedgerEntries(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListLedgerEntries", reflect.TypeOf((*MockRepository)(nil).ListLedgerEntries), ctx, filter)
+}
+
+// LockBankAccount mocks base method.
+func (m *MockRepository) LockBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockBankAccount", ctx, id)
+	ret0, _ := ret[0].(*model.BankAccount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LockBankAccount indicates an expected call of LockBankAccount.
+func (mr *MockRepositoryMockRecorder) LockBankAccount(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBankAccountByID", reflect.TypeOf((*MockRepository)(nil).GetBankAccountByID), ctx, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockBankAccount", reflect.TypeOf((*MockRepository)(nil).LockBankAccount), ctx, id)
+}
+
+// Transfer mocks base method.
+func (m *MockRepository) Transfer(ctx context.Context, transfer *model.Transfer) (*model.BankAccount, *model.BankAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Transfer", ctx, transfer)
+	ret0, _ := ret[0].(*model.BankAccount)
+	ret1, _ := ret[1].(*model.BankAccount)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Transfer indicates an expected call of Transfer.
+func (mr *MockRepositoryMockRecorder) Transfer(ctx, transfer interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Transfer", reflect.TypeOf((*MockRepository)(nil).Transfer), ctx, transfer)
+}
+
+// UnfreezeBankAccount mocks base method.
+func (m *MockRepository) UnfreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnfreezeBankAccount", ctx, id)
+	ret0, _ := ret[0].(*model.BankAccount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UnfreezeBankAccount indicates an expected call of UnfreezeBankAccount.
+func (mr *MockRepositoryMockRecorder) UnfreezeBankAccount(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnfreezeBankAccount", reflect.TypeOf((*MockRepository)(nil).UnfreezeBankAccount), ctx, id)
 }
 
 // UpdateBankAccount mocks base method.
-func (m *MockRepository) UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount) (*model.BankAccount, error) {
+func (m *MockRepository) UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount, mask model.UpdateMask) (*model.BankAccount, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateBankAccount", ctx, id, account)
+	ret := m.ctrl.Call(m, "UpdateBankAccount", ctx, id, account, mask)
 	ret0, _ := ret[0].(*model.BankAccount)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // UpdateBankAccount indicates an expected call of UpdateBankAccount.
-func (mr *MockRepositoryMockRecorder) UpdateBankAccount(ctx, id, account interface{}) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) UpdateBankAccount(ctx, id, account, mask interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBankAccount", reflect.TypeOf((*MockRepository)(nil).UpdateBankAccount), ctx, id, account, mask)
+}
+
+// MockrowScanner is a mock of rowScanner interface.
+type MockrowScanner struct {
+	ctrl     *gomock.Controller
+	recorder *MockrowScannerMockRecorder
+}
+
+// MockrowScannerMockRecorder is the mock recorder for MockrowScanner.
+type MockrowScannerMockRecorder struct {
+	mock *MockrowScanner
+}
+
+// NewMockrowScanner creates a new mock instance.
+func NewMockrowScanner(ctrl *gomock.Controller) *MockrowScanner {
+	mock := &MockrowScanner{ctrl: ctrl}
+	mock.recorder = &MockrowScannerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockrowScanner) EXPECT() *MockrowScannerMockRecorder {
+	return m.recorder
+}
+
+// Scan mocks base method.
+func (m *MockrowScanner) Scan(dest ...interface{}) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range dest {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Scan", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Scan indicates an expected call of Scan.
+func (mr *MockrowScannerMockRecorder) Scan(dest ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBankAccount", reflect.TypeOf((*MockRepository)(nil).UpdateBankAccount), ctx, id, account)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Scan", reflect.TypeOf((*MockrowScanner)(nil).Scan), dest...)
 }
@@ -36,6 +36,21 @@ func (m *MockService) EXPECT() *MockServiceMockRecorder {
 	return m.recorder
 }
 
+// CloseBankAccount mocks base method.
+func (m *MockService) CloseBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloseBankAccount", ctx, id)
+	ret0, _ := ret[0].(*model.BankAccount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CloseBankAccount indicates an expected call of CloseBankAccount.
+func (mr *MockServiceMockRecorder) CloseBankAccount(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseBankAccount", reflect.TypeOf((*MockService)(nil).CloseBankAccount), ctx, id)
+}
+
 // CreateBankAccount mocks base method.
 func (m *MockService) CreateBankAccount(ctx context.Context, account *model.BankAccount) (*model.BankAccount, error) {
 	m.ctrl.T.Helper()
@@ -66,32 +81,153 @@ func (mr *MockServiceMockRecorder) DeleteBankAccount(ctx, id interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBankAccount", reflect.TypeOf((*MockService)(nil).DeleteBankAccount), ctx, id)
 }
 
+// ExportBankAccounts mocks base method.
+func (m *MockService) ExportBankAccounts(ctx context.Context, send func(*model.BankAccount) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportBankAccounts", ctx, send)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportBankAccounts indicates an expected call of ExportBankAccounts.
+func (mr *MockServiceMockRecorder) ExportBankAccounts(ctx, send interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportBankAccounts", reflect.TypeOf((*MockService)(nil).ExportBankAccounts), ctx, send)
+}
+
+// FreezeBankAccount mocks base method.
+func (m *MockService) FreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FreezeBankAccount", ctx, id)
+	ret0, _ := ret[0].(*model.BankAccount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FreezeBankAccount indicates an expected call of FreezeBankAccount.
+func (mr *MockServiceMockRecorder) FreezeBankAccount(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FreezeBankAccount", reflect.TypeOf((*MockService)(nil).FreezeBankAccount), ctx, id)
+}
+
 // GetBankAccountById mocks base method.
-func (m *MockService) GetBankAccountById(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+func (m *MockService) GetBankAccountById(ctx context.Context, id uuid.UUID, options model.ReadOptions) (*model.BankAccount, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetBankAccountById", ctx, id)
+	ret := m.ctrl.Call(m, "GetBankAccountById", ctx, id, options)
 	ret0, _ := ret[0].(*model.BankAccount)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetBankAccountById indicates an expected call of GetBankAccountById.
-func (mr *MockServiceMockRecorder) GetBankAccountById(ctx, id interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) GetBankAccountById(ctx, id, options interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBankAccountById", reflect.TypeOf((*MockService)(nil).GetBankAccountById), ctx, id, options)
+}
+
+// ImportBankAccounts mocks base method.
+func (m *MockService) ImportBankAccounts(ctx context.Context, rows []model.ImportRow) (*model.ImportResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportBankAccounts", ctx, rows)
+	ret0, _ := ret[0].(*model.ImportResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImportBankAccounts indicates an expected call of ImportBankAccounts.
+func (mr *MockServiceMockRecorder) ImportBankAccounts(ctx, rows interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportBankAccounts", reflect.TypeOf((*MockService)(nil).ImportBankAccounts), ctx, rows)
+}
+
+// ListAccountTransactions mocks base method.
+func (m *MockService) ListAccountTransactions(ctx context.Context, filter *model.LedgerFilter) ([]model.LedgerEntry, string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAccountTransactions", ctx, filter)
+	ret0, _ := ret[0].([]model.LedgerEntry)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ListAccountTransactions indicates an expected call of ListAccountTransactions.
+func (mr *MockServiceMockRecorder) ListAccountTransactions(ctx, filter interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBankAccountById", reflect.TypeOf((*MockService)(nil).GetBankAccountById), ctx, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAccountTransactions", reflect.TypeOf((*MockService)(nil).ListAccountTransactions), ctx, filter)
+}
+
+// ListBankAccounts mocks base method.
+func (m *MockService) ListBankAccounts(ctx context.Context, filter *model.BankAccountFilter) ([]model.BankAccount, string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListBankAccounts", ctx, filter)
+	ret0, _ := ret[0].([]model.BankAccount)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ListBankAccounts indicates an expected call of ListBankAccounts.
+func (mr *MockServiceMockRecorder) ListBankAccounts(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListBankAccounts", reflect.TypeOf((*MockService)(nil).ListBankAccounts), ctx, filter)
+}
+
+// Transfer mocks base method.
+func (m *MockService) Transfer(ctx context.Context, transfer *model.Transfer) (*model.BankAccount, *model.BankAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Transfer", ctx, transfer)
+	ret0, _ := ret[0].(*model.BankAccount)
+	ret1, _ := ret[1].(*model.BankAccount)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Transfer indicates an expected call of Transfer.
+func (mr *MockServiceMockRecorder) Transfer(ctx, transfer interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Transfer", reflect.TypeOf((*MockService)(nil).Transfer), ctx, transfer)
+}
+
+// UnfreezeBankAccount mocks base method.
+func (m *MockService) UnfreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnfreezeBankAccount", ctx, id)
+	ret0, _ := ret[0].(*model.BankAccount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UnfreezeBankAccount indicates an expected call of UnfreezeBankAccount.
+func (mr *MockServiceMockRecorder) UnfreezeBankAccount(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnfreezeBankAccount", reflect.TypeOf((*MockService)(nil).UnfreezeBankAccount), ctx, id)
 }
 
 // UpdateBankAccount mocks base method.
-func (m *MockService) UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount) (*model.BankAccount, error) {
+func (m *MockService) UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount, mask model.UpdateMask) (*model.BankAccount, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateBankAccount", ctx, id, account)
+	ret := m.ctrl.Call(m, "UpdateBankAccount", ctx, id, account, mask)
 	ret0, _ := ret[0].(*model.BankAccount)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // UpdateBankAccount indicates an expected call of UpdateBankAccount.
-func (mr *MockServiceMockRecorder) UpdateBankAccount(ctx, id, account interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) UpdateBankAccount(ctx, id, account, mask interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBankAccount", reflect.TypeOf((*MockService)(nil).UpdateBankAccount), ctx, id, account, mask)
+}
+
+// WatchBankAccount mocks base method.
+func (m *MockService) WatchBankAccount(ctx context.Context, id uuid.UUID, send func(model.WatchEvent) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WatchBankAccount", ctx, id, send)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WatchBankAccount indicates an expected call of WatchBankAccount.
+func (mr *MockServiceMockRecorder) WatchBankAccount(ctx, id, send interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBankAccount", reflect.TypeOf((*MockService)(nil).UpdateBankAccount), ctx, id, account)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WatchBankAccount", reflect.TypeOf((*MockService)(nil).WatchBankAccount), ctx, id, send)
 }
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"errors"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+	"sort"
+)
+
+// ImportBatchSize is the number of accounts created in one transaction.
+const ImportBatchSize = 500
+
+// ExportPageSize is the number of accounts read from the database at once.
+const ExportPageSize = 500
+
+// ImportRow is an account of an import together with its position in the
+// import, so that failures can be reported against it.
+type ImportRow struct {
+	Index   int
+	Account *BankAccount
+}
+
+type ImportFailure struct {
+	Index      int
+	Message    string
+	Violations []apperr.FieldViolation
+}
+
+// NewImportFailure describes why the row at index was not imported.
+func NewImportFailure(index int, err error) ImportFailure {
+	failure := ImportFailure{Index: index, Message: err.Error()}
+	var badRequest *apperr.BadRequestError
+	if errors.As(err, &badRequest) {
+		failure.Violations = badRequest.Violations
+	}
+	return failure
+}
+
+type ImportResult struct {
+	Imported int
+	Failures []ImportFailure
+}
+
+// Add merges the result of another batch. The failures are left in the order
+// they were added; Sort orders them once the import is over.
+func (r *ImportResult) Add(other *ImportResult) {
+	r.Imported += other.Imported
+	r.Failures = append(r.Failures, other.Failures...)
+}
+
+// Sort orders the failures by their position in the import.
+func (r *ImportResult) Sort() {
+	sort.SliceStable(r.Failures, func(i, j int) bool {
+		return r.Failures[i].Index < r.Failures[j].Index
+	})
+}
+
+func (r ImportResult) MapToDto() *bank_accounts.ImportBankAccountsResponse {
+	failures := make([]*bank_accounts.ImportFailure, len(r.Failures))
+	for i, failure := range r.Failures {
+		violations := make([]*bank_accounts.FieldViolation, len(failure.Violations))
+		for j, violation := range failure.Violations {
+			violations[j] = &bank_accounts.FieldViolation{
+				Field:       violation.Field,
+				Description: violation.Description,
+			}
+		}
+		failures[i] = &bank_accounts.ImportFailure{
+			Index:      int32(failure.Index),
+			Message:    failure.Message,
+			Violations: violations,
+		}
+	}
+
+	return &bank_accounts.ImportBankAccountsResponse{
+		Imported: int32(r.Imported),
+		Failures: failures,
+	}
+}
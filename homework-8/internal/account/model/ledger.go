@@ -0,0 +1,197 @@
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LedgerEntry is one side of a balance change. Every transaction consists of
+// entries whose amounts sum up to zero in each currency; an entry with
+// uuid.Nil AccountID is the external side of a deposit, a withdrawal or a
+// currency exchange.
+type LedgerEntry struct {
+	ID            uuid.UUID `db:"id"`
+	TransactionID uuid.UUID `db:"transaction_id"`
+	AccountID     uuid.UUID `db:"account_id"`
+	Amount        int64     `db:"amount"`
+	BalanceAfter  int64     `db:"balance_after"`
+	Currency      string    `db:"currency"`
+	Description   string    `db:"description"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+// NewDepositEntries records a balance change of an account against the
+// external side.
+func NewDepositEntries(account *BankAccount, amount int64, description string, createdAt time.Time) []LedgerEntry {
+	transactionID := uuid.New()
+	return []LedgerEntry{
+		{
+			ID:            uuid.New(),
+			TransactionID: transactionID,
+			AccountID:     account.ID,
+			Amount:        amount,
+			BalanceAfter:  account.Balance,
+			Currency:      account.Currency,
+			Description:   description,
+			CreatedAt:     createdAt,
+		},
+		{
+			ID:            uuid.New(),
+			TransactionID: transactionID,
+			Amount:        -amount,
+			Currency:      account.Currency,
+			Description:   description,
+			CreatedAt:     createdAt,
+		},
+	}
+}
+
+// NewTransferEntries records a transfer between two accounts whose balances
+// are already updated. A transfer between currencies goes through the external
+// side, so that the entries of each currency still sum up to zero.
+func NewTransferEntries(transfer *Transfer, from *BankAccount, to *BankAccount) []LedgerEntry {
+	entries := []LedgerEntry{
+		{
+			ID:            uuid.New(),
+			TransactionID: transfer.ID,
+			AccountID:     from.ID,
+			Amount:        -transfer.Amount,
+			BalanceAfter:  from.Balance,
+			Currency:      from.Currency,
+			Description:   fmt.Sprintf("transfer to %s", to.ID),
+			CreatedAt:     transfer.CreatedAt,
+		},
+		{
+			ID:            uuid.New(),
+			TransactionID: transfer.ID,
+			AccountID:     to.ID,
+			Amount:        transfer.ToAmount,
+			BalanceAfter:  to.Balance,
+			Currency:      to.Currency,
+			Description:   fmt.Sprintf("transfer from %s", from.ID),
+			CreatedAt:     transfer.CreatedAt,
+		},
+	}
+	if from.Currency == to.Currency {
+		return entries
+	}
+
+	description := fmt.Sprintf("exchange %s to %s", from.Currency, to.Currency)
+	return append(entries,
+		LedgerEntry{
+			ID:            uuid.New(),
+			TransactionID: transfer.ID,
+			Amount:        transfer.Amount,
+			Currency:      from.Currency,
+			Description:   description,
+			CreatedAt:     transfer.CreatedAt,
+		},
+		LedgerEntry{
+			ID:            uuid.New(),
+			TransactionID: transfer.ID,
+			Amount:        -transfer.ToAmount,
+			Currency:      to.Currency,
+			Description:   description,
+			CreatedAt:     transfer.CreatedAt,
+		},
+	)
+}
+
+func (e LedgerEntry) MapToDto() *bank_accounts.LedgerEntryDto {
+	return &bank_accounts.LedgerEntryDto{
+		Id:            &bank_accounts.UUID{Value: e.ID.String()},
+		TransactionId: &bank_accounts.UUID{Value: e.TransactionID.String()},
+		AccountId:     &bank_accounts.UUID{Value: e.AccountID.String()},
+		Amount:        e.Amount,
+		BalanceAfter:  e.BalanceAfter,
+		Currency:      e.Currency,
+		Description:   e.Description,
+		CreatedAt:     &bank_accounts.Timestamp{Value: timestamppb.New(e.CreatedAt)},
+	}
+}
+
+func MapLedgerEntriesToDto(entries []LedgerEntry) []*bank_accounts.LedgerEntryDto {
+	result := make([]*bank_accounts.LedgerEntryDto, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.MapToDto()
+	}
+	return result
+}
+
+// LedgerCursor points at the last entry of a returned page. Entries are listed
+// from the newest to the oldest, so the next page starts strictly after it.
+type LedgerCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+type LedgerFilter struct {
+	AccountID uuid.UUID
+	From      time.Time
+	To        time.Time
+	After     *LedgerCursor
+	Limit     int
+}
+
+func (c LedgerCursor) Encode() string {
+	raw := fmt.Sprintf("%d|%s", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeLedgerCursor(token string) (*LedgerCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	invalid := errors.New("invalid page token")
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, invalid
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 2 {
+		return nil, invalid
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, invalid
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return nil, invalid
+	}
+
+	return &LedgerCursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+func MapLedgerFilterFromRequest(request *bank_accounts.ListAccountTransactionsRequest) (*LedgerFilter, error) {
+	accountID, err := uuid.Parse(request.GetAccountId().GetValue())
+	if err != nil {
+		return nil, errors.New("invalid account_id")
+	}
+	cursor, err := DecodeLedgerCursor(request.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &LedgerFilter{
+		AccountID: accountID,
+		After:     cursor,
+		Limit:     int(request.GetPageSize()),
+	}
+	if request.GetFrom().GetValue() != nil {
+		filter.From = request.GetFrom().GetValue().AsTime()
+	}
+	if request.GetTo().GetValue() != nil {
+		filter.To = request.GetTo().GetValue().AsTime()
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,160 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+type SortField int
+
+const (
+	SortByOpeningDate SortField = iota
+	SortByBalance
+	SortByHolderName
+)
+
+type BankAccountFilter struct {
+	BankName         string
+	HolderNamePrefix string
+	Currency         string
+	// OwnerID limits the list to the accounts of one holder when it is set.
+	OwnerID string
+	// IncludeClosed lists closed accounts as well.
+	IncludeClosed bool
+	MinBalance    *int64
+	MaxBalance    *int64
+	OpenedAfter   time.Time
+	OpenedBefore  time.Time
+	SortBy        SortField
+	Descending    bool
+	After         *BankAccountCursor
+	Limit         int
+	// Mask selects the returned fields, subscriptions are loaded if it has them.
+	Mask ReadMask
+}
+
+// BankAccountCursor points at the last account of a returned page. It keeps
+// the sort order it was issued for, so it cannot be reused with another one.
+type BankAccountCursor struct {
+	SortBy     SortField `json:"s"`
+	Descending bool      `json:"d"`
+	Value      string    `json:"v"`
+	ID         uuid.UUID `json:"id"`
+}
+
+func NewBankAccountCursor(filter *BankAccountFilter, account BankAccount) BankAccountCursor {
+	cursor := BankAccountCursor{
+		SortBy:     filter.SortBy,
+		Descending: filter.Descending,
+		ID:         account.ID,
+	}
+	switch filter.SortBy {
+	case SortByBalance:
+		cursor.Value = strconv.FormatInt(account.Balance, 10)
+	case SortByHolderName:
+		cursor.Value = account.HolderName
+	default:
+		cursor.Value = strconv.FormatInt(account.OpeningDate.UnixNano(), 10)
+	}
+	return cursor
+}
+
+// SortValue converts the cursor value back to the type of the sorted column.
+func (c BankAccountCursor) SortValue() (interface{}, error) {
+	switch c.SortBy {
+	case SortByBalance:
+		return strconv.ParseInt(c.Value, 10, 64)
+	case SortByHolderName:
+		return c.Value, nil
+	default:
+		nanos, err := strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(0, nanos).UTC(), nil
+	}
+}
+
+func (c BankAccountCursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func DecodeBankAccountCursor(token string) (*BankAccountCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	invalid := errors.New("invalid page token")
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, invalid
+	}
+	var cursor BankAccountCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, invalid
+	}
+	if _, err := cursor.SortValue(); err != nil {
+		return nil, invalid
+	}
+
+	return &cursor, nil
+}
+
+func MapBankAccountFilterFromRequest(request *bank_accounts.ListBankAccountsRequest) (*BankAccountFilter, error) {
+	cursor, err := DecodeBankAccountCursor(request.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	mask, err := MapReadMask(request.GetReadMask())
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &BankAccountFilter{
+		BankName:         request.GetBankName(),
+		HolderNamePrefix: request.GetHolderNamePrefix(),
+		Currency:         money.NormalizeCurrency(request.GetCurrency()),
+		IncludeClosed:    request.GetIncludeClosed(),
+		Descending:       request.GetDescending(),
+		After:            cursor,
+		Limit:            int(request.GetPageSize()),
+		Mask:             mask,
+	}
+	if request.MinBalance != nil {
+		minBalance := request.GetMinBalance()
+		filter.MinBalance = &minBalance
+	}
+	if request.MaxBalance != nil {
+		maxBalance := request.GetMaxBalance()
+		filter.MaxBalance = &maxBalance
+	}
+	if request.GetOpenedAfter().GetValue() != nil {
+		filter.OpenedAfter = request.GetOpenedAfter().GetValue().AsTime()
+	}
+	if request.GetOpenedBefore().GetValue() != nil {
+		filter.OpenedBefore = request.GetOpenedBefore().GetValue().AsTime()
+	}
+
+	switch request.GetSortBy() {
+	case bank_accounts.BankAccountSortField_BALANCE:
+		filter.SortBy = SortByBalance
+	case bank_accounts.BankAccountSortField_HOLDER_NAME:
+		filter.SortBy = SortByHolderName
+	default:
+		filter.SortBy = SortByOpeningDate
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// FieldSubscriptions is the BankAccountDto field the subscriptions of an
+// account are returned in. They are the only field that costs a query of its
+// own.
+const FieldSubscriptions = "subscriptions"
+
+// ReadMask holds the BankAccountDto fields a read returns. The nil mask holds
+// every field.
+type ReadMask map[string]struct{}
+
+// MapReadMask accepts the top level fields of BankAccountDto by their proto
+// names. An empty mask selects every field.
+func MapReadMask(mask *fieldmaskpb.FieldMask) (ReadMask, error) {
+	if len(mask.GetPaths()) == 0 {
+		return nil, nil
+	}
+
+	fields := (&bank_accounts.BankAccountDto{}).ProtoReflect().Descriptor().Fields()
+	readMask := make(ReadMask, len(mask.GetPaths()))
+	for _, path := range mask.GetPaths() {
+		if fields.ByName(protoreflect.Name(path)) == nil {
+			return nil, fmt.Errorf("unknown field %q in read mask", path)
+		}
+		readMask[path] = struct{}{}
+	}
+	return readMask, nil
+}
+
+// Has reports whether the mask selects the field.
+func (m ReadMask) Has(field string) bool {
+	if m == nil {
+		return true
+	}
+	_, ok := m[field]
+	return ok
+}
+
+// Apply clears the fields of dto the mask does not select.
+func (m ReadMask) Apply(dto *bank_accounts.BankAccountDto) {
+	if m == nil || dto == nil {
+		return
+	}
+	message := dto.ProtoReflect()
+	message.Range(func(field protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if !m.Has(string(field.Name())) {
+			message.Clear(field)
+		}
+		return true
+	})
+}
+
+// UpdateMask holds the BankAccountDto fields an update changes.
+type UpdateMask map[string]struct{}
+
+// updatableFields are the fields an update may change. The status has calls
+// of its own and subscriptions are changed through SubscriptionService. Only
+// admins may change the balance.
+var updatableFields = []string{"holder_name", "balance", "bank_name"}
+
+// defaultUpdateFields leave out the balance: a client replacing the account
+// with a stale copy must not undo the deposits and charges made meanwhile.
+var defaultUpdateFields = []string{"holder_name", "bank_name"}
+
+// versionField is accepted in an update mask, since a PATCH body carrying the
+// version it is based on has it in its inferred mask. The version is checked,
+// not changed.
+const versionField = "version"
+
+// DefaultUpdateMask replaces every field an update may change but the
+// balance. It is used for requests without a mask.
+func DefaultUpdateMask() UpdateMask {
+	return newUpdateMask(defaultUpdateFields)
+}
+
+func newUpdateMask(fields []string) UpdateMask {
+	mask := make(UpdateMask, len(fields))
+	for _, field := range fields {
+		mask[field] = struct{}{}
+	}
+	return mask
+}
+
+// MapUpdateMask rejects fields that cannot be updated, e.g. id or
+// opening_date. An empty mask is DefaultUpdateMask, the balance is only
+// changed when the mask lists it.
+func MapUpdateMask(mask *fieldmaskpb.FieldMask) (UpdateMask, error) {
+	if len(mask.GetPaths()) == 0 {
+		return DefaultUpdateMask(), nil
+	}
+
+	fields := (&bank_accounts.BankAccountDto{}).ProtoReflect().Descriptor().Fields()
+	updatable := newUpdateMask(updatableFields)
+	updateMask := make(UpdateMask, len(mask.GetPaths()))
+	for _, path := range mask.GetPaths() {
+		if fields.ByName(protoreflect.Name(path)) == nil {
+			return nil, fmt.Errorf("unknown field %q in update mask", path)
+		}
+		if path == versionField {
+			continue
+		}
+		if !updatable.Has(path) {
+			return nil, fmt.Errorf("field %q cannot be updated", path)
+		}
+		updateMask[path] = struct{}{}
+	}
+	if len(updateMask) == 0 {
+		return nil, errors.New("update mask has no field to update")
+	}
+	return updateMask, nil
+}
+
+// Has reports whether the update changes the field.
+func (m UpdateMask) Has(field string) bool {
+	_, ok := m[field]
+	return ok
+}
+
+// Apply copies the fields the mask changes from changes to account.
+func (m UpdateMask) Apply(account *BankAccount, changes *BankAccount) {
+	if m.Has("holder_name") {
+		account.HolderName = changes.HolderName
+	}
+	if m.Has("balance") {
+		account.Balance = changes.Balance
+	}
+	if m.Has("bank_name") {
+		account.BankName = changes.BankName
+	}
+}
+
+// ReadOptions tune a read of one account.
+type ReadOptions struct {
+	// IncludeClosed finds closed accounts too.
+	IncludeClosed bool
+	Mask          ReadMask
+	// ForUpdate locks the account until the transaction of the read ends and
+	// reads it past any cache. Subscriptions are not read with it.
+	ForUpdate bool
+}
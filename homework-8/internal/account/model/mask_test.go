@@ -0,0 +1,69 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/subscriptions"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"testing"
+)
+
+func TestReadMask(t *testing.T) {
+	t.Parallel()
+
+	mask, err := MapReadMask(nil)
+	require.NoError(t, err)
+	assert.True(t, mask.Has(FieldSubscriptions), "no mask selects every field")
+
+	_, err = MapReadMask(&fieldmaskpb.FieldMask{Paths: []string{"id", "subscriptions.price"}})
+	assert.EqualError(t, err, `unknown field "subscriptions.price" in read mask`)
+
+	mask, err = MapReadMask(&fieldmaskpb.FieldMask{Paths: []string{"id", "balance"}})
+	require.NoError(t, err)
+	assert.False(t, mask.Has(FieldSubscriptions))
+
+	dto := &bank_accounts.BankAccountDto{
+		Id:            &bank_accounts.UUID{Value: "a7115d4e-65af-487f-a3ca-bf7ca9747c4c"},
+		HolderName:    "Dima Sudakov",
+		Balance:       1000,
+		Subscriptions: []*subscriptions.SubscriptionDto{{SubscriptionName: "Music"}},
+	}
+	mask.Apply(dto)
+	assert.Equal(t, "a7115d4e-65af-487f-a3ca-bf7ca9747c4c", dto.GetId().GetValue())
+	assert.Equal(t, int64(1000), dto.GetBalance())
+	assert.Empty(t, dto.GetHolderName())
+	assert.Empty(t, dto.GetSubscriptions())
+}
+
+func TestUpdateMask(t *testing.T) {
+	t.Parallel()
+
+	mask, err := MapUpdateMask(nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultUpdateMask(), mask)
+	assert.Equal(t, UpdateMask{"holder_name": {}, "bank_name": {}}, mask, "no mask leaves the balance alone")
+
+	tests := []struct {
+		paths         []string
+		expectedError string
+	}{
+		{paths: []string{"holder_name", "owner"}, expectedError: `unknown field "owner" in update mask`},
+		{paths: []string{"holder_name", "id"}, expectedError: `field "id" cannot be updated`},
+		{paths: []string{"opening_date"}, expectedError: `field "opening_date" cannot be updated`},
+		{paths: []string{"currency"}, expectedError: `field "currency" cannot be updated`},
+		{paths: []string{"version"}, expectedError: "update mask has no field to update"},
+	}
+	for _, tc := range tests {
+		_, err = MapUpdateMask(&fieldmaskpb.FieldMask{Paths: tc.paths})
+		assert.EqualError(t, err, tc.expectedError)
+	}
+
+	mask, err = MapUpdateMask(&fieldmaskpb.FieldMask{Paths: []string{"balance", "version"}})
+	require.NoError(t, err)
+	assert.Equal(t, UpdateMask{"balance": {}}, mask)
+
+	account := &BankAccount{HolderName: "Dima Sudakov", Balance: 1000, BankName: "Ozon Bank"}
+	mask.Apply(account, &BankAccount{HolderName: "Dima Petrov", Balance: 700})
+	assert.Equal(t, &BankAccount{HolderName: "Dima Sudakov", Balance: 700, BankName: "Ozon Bank"}, account)
+}
@@ -2,8 +2,10 @@ package model
 
 import (
 	"errors"
+	"fmt"
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/subscription"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -14,28 +16,98 @@ import (
 type BankAccount struct {
 	ID            uuid.UUID                   `db:"id"`
 	HolderName    string                      `db:"holder_name"`
-	Balance       int                         `db:"balance"`
+	Balance       int64                       `db:"balance"`
 	OpeningDate   time.Time                   `db:"opening_date"`
 	BankName      string                      `db:"bank_name"`
 	Subscriptions []subscription.Subscription `db:"subscriptions"`
+	Version       int64                       `db:"version"`
+	Currency      string                      `db:"currency"`
+	OwnerID       string                      `db:"owner_id"`
+	Status        string                      `db:"status"`
+	ClosedAt      time.Time                   `db:"closed_at"`
+}
+
+// InitialVersion is the version of a newly created account.
+const InitialVersion = 1
+
+const (
+	StatusActive = "active"
+	// StatusFrozen accounts accept credits but no debits.
+	StatusFrozen = "frozen"
+	// StatusClosed accounts cannot be changed and are left out of reads unless
+	// they are asked for.
+	StatusClosed = "closed"
+)
+
+// IsClosed reports whether the account is closed.
+func (a BankAccount) IsClosed() bool {
+	return a.Status == StatusClosed
+}
+
+// CanBeDebited reports whether money may be taken from the account.
+func (a BankAccount) CanBeDebited() bool {
+	return a.Status == StatusActive
 }
 
 func (a BankAccount) Validate() error {
-	return validation.ValidateStruct(&a,
-		validation.Field(&a.HolderName,
+	return validateFields(&a, func(string) bool { return true })
+}
+
+// ValidateUpdate validates only the fields the mask changes.
+func (a BankAccount) ValidateUpdate(mask UpdateMask) error {
+	return validateFields(&a, mask.Has)
+}
+
+// validateFields validates the fields, named as in BankAccountDto, that
+// selected accepts.
+func validateFields(a *BankAccount, selected func(field string) bool) error {
+	rules := []struct {
+		field string
+		rules *validation.FieldRules
+	}{
+		{"holder_name", validation.Field(&a.HolderName,
 			validation.Required,
 			validation.Length(3, 100),
 			validation.Match(regexp.MustCompile("^[a-zA-Z ]+$")),
-		),
-		validation.Field(&a.Balance,
-			validation.Min(0),
-		),
-		validation.Field(&a.BankName,
+		)},
+		{"balance", validation.Field(&a.Balance,
+			validation.Min(int64(0)),
+		)},
+		{"currency", validation.Field(&a.Currency,
+			validation.By(money.ValidateCurrency),
+		)},
+		{"bank_name", validation.Field(&a.BankName,
 			validation.Required,
 			validation.Length(3, 20),
 			validation.Match(regexp.MustCompile("^[a-zA-Z0-9 ]+$")),
-		),
-	)
+		)},
+		{FieldSubscriptions, validation.Field(&a.Subscriptions,
+			validation.By(uniqueActiveSubscriptionNames),
+		)},
+	}
+
+	var fields []*validation.FieldRules
+	for _, rule := range rules {
+		if selected(rule.field) {
+			fields = append(fields, rule.rules)
+		}
+	}
+	return validation.ValidateStruct(a, fields...)
+}
+
+func uniqueActiveSubscriptionNames(value interface{}) error {
+	now := time.Now()
+	names := make(map[string]struct{})
+	for _, sub := range value.([]subscription.Subscription) {
+		if !sub.IsActive(now) {
+			continue
+		}
+		if _, ok := names[sub.Name]; ok {
+			return fmt.Errorf("duplicate active subscription %q", sub.Name)
+		}
+		names[sub.Name] = struct{}{}
+	}
+	return nil
 }
 
 func MapFromDto(dto *bank_accounts.BankAccountDto) (*BankAccount, error) {
@@ -50,20 +122,44 @@ func MapFromDto(dto *bank_accounts.BankAccountDto) (*BankAccount, error) {
 	return &BankAccount{
 		ID:            id,
 		HolderName:    dto.GetHolderName(),
-		Balance:       int(dto.GetBalance()),
+		Balance:       dto.GetBalance(),
 		OpeningDate:   dto.GetOpeningDate().GetValue().AsTime(),
 		BankName:      dto.GetBankName(),
 		Subscriptions: subs,
+		Version:       dto.GetVersion(),
+		Currency:      money.NormalizeCurrency(dto.GetCurrency()),
+		OwnerID:       dto.GetOwnerId(),
+		Status:        dto.GetStatus(),
 	}, nil
 }
 
+// MapUpdateFromDto maps the fields an update may change. The account is
+// identified by the request, so the ID of dto is not required.
+func MapUpdateFromDto(dto *bank_accounts.BankAccountDto) *BankAccount {
+	return &BankAccount{
+		HolderName: dto.GetHolderName(),
+		Balance:    dto.GetBalance(),
+		BankName:   dto.GetBankName(),
+		Version:    dto.GetVersion(),
+		Currency:   money.NormalizeCurrency(dto.GetCurrency()),
+	}
+}
+
 func (a BankAccount) MapToDto() *bank_accounts.BankAccountDto {
-	return &bank_accounts.BankAccountDto{
+	dto := &bank_accounts.BankAccountDto{
 		Id:            &bank_accounts.UUID{Value: a.ID.String()},
 		HolderName:    a.HolderName,
-		Balance:       int32(a.Balance),
+		Balance:       a.Balance,
 		OpeningDate:   &bank_accounts.Timestamp{Value: timestamppb.New(a.OpeningDate)},
 		BankName:      a.BankName,
 		Subscriptions: subscription.MapToDtoList(a.Subscriptions),
+		Version:       a.Version,
+		Currency:      a.Currency,
+		OwnerId:       a.OwnerID,
+		Status:        a.Status,
+	}
+	if !a.ClosedAt.IsZero() {
+		dto.ClosedAt = &bank_accounts.Timestamp{Value: timestamppb.New(a.ClosedAt)}
 	}
+	return dto
 }
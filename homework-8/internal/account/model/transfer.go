@@ -0,0 +1,71 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+	"time"
+)
+
+type Transfer struct {
+	ID     uuid.UUID `db:"id"`
+	FromID uuid.UUID `db:"from_account_id"`
+	ToID   uuid.UUID `db:"to_account_id"`
+	Amount int64     `db:"amount"`
+	// ToAmount is Amount converted into the currency of the destination account.
+	ToAmount       int64     `db:"to_amount"`
+	IdempotencyKey string    `db:"idempotency_key"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// LockOrder returns the accounts of the transfer in the order their rows are
+// locked in, so that concurrent opposite transfers cannot deadlock.
+func (t Transfer) LockOrder() (first uuid.UUID, second uuid.UUID) {
+	if bytes.Compare(t.FromID[:], t.ToID[:]) > 0 {
+		return t.ToID, t.FromID
+	}
+	return t.FromID, t.ToID
+}
+
+func (t Transfer) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.FromID,
+			validation.Required,
+		),
+		validation.Field(&t.ToID,
+			validation.Required,
+			validation.By(func(value interface{}) error {
+				if value.(uuid.UUID) == t.FromID {
+					return errors.New("must differ from FromID")
+				}
+				return nil
+			}),
+		),
+		validation.Field(&t.Amount,
+			validation.Required,
+			validation.Min(int64(1)),
+		),
+		validation.Field(&t.IdempotencyKey,
+			validation.Length(0, 255),
+		),
+	)
+}
+
+func MapTransferFromRequest(request *bank_accounts.TransferRequest) (*Transfer, error) {
+	fromID, err := uuid.Parse(request.GetFromId().GetValue())
+	if err != nil {
+		return nil, errors.New("invalid from_id")
+	}
+	toID, err := uuid.Parse(request.GetToId().GetValue())
+	if err != nil {
+		return nil, errors.New("invalid to_id")
+	}
+	return &Transfer{
+		FromID:         fromID,
+		ToID:           toID,
+		Amount:         request.GetAmount(),
+		IdempotencyKey: request.GetIdempotencyKey(),
+	}, nil
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTransfer_LockOrder(t *testing.T) {
+	t.Parallel()
+
+	var (
+		low  = uuid.MustParse("331684ab-5af8-439a-8a4f-62a571013283")
+		high = uuid.MustParse("a7115d4e-65af-487f-a3ca-bf7ca9747c4c")
+	)
+
+	first, second := Transfer{FromID: high, ToID: low}.LockOrder()
+	assert.Equal(t, []uuid.UUID{low, high}, []uuid.UUID{first, second})
+	first, second = Transfer{FromID: low, ToID: high}.LockOrder()
+	assert.Equal(t, []uuid.UUID{low, high}, []uuid.UUID{first, second}, "opposite transfers lock in the same order")
+}
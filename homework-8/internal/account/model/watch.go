@@ -0,0 +1,37 @@
+package model
+
+import (
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+)
+
+type WatchEventType int
+
+const (
+	// WatchSnapshot is the state of the account when the watch started.
+	WatchSnapshot WatchEventType = iota + 1
+	WatchUpdated
+	// WatchDeleted carries the last known state of a deleted account.
+	WatchDeleted
+)
+
+type WatchEvent struct {
+	Type    WatchEventType
+	Account *BankAccount
+}
+
+func (e WatchEvent) MapToDto() *bank_accounts.WatchBankAccountResponse {
+	var eventType bank_accounts.WatchEventType
+	switch e.Type {
+	case WatchSnapshot:
+		eventType = bank_accounts.WatchEventType_SNAPSHOT
+	case WatchUpdated:
+		eventType = bank_accounts.WatchEventType_UPDATED
+	case WatchDeleted:
+		eventType = bank_accounts.WatchEventType_DELETED
+	}
+
+	return &bank_accounts.WatchBankAccountResponse{
+		Type:    eventType,
+		Account: e.Account.MapToDto(),
+	}
+}
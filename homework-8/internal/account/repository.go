@@ -5,157 +5,812 @@ package account
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/outbox"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/subscription"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type Repository interface {
 	CreateBankAccount(ctx context.Context, account *model.BankAccount) (*model.BankAccount, error)
-	GetBankAccountByID(ctx context.Context, id uuid.UUID) (*model.BankAccount, error)
-	UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount) (*model.BankAccount, error)
-	DeleteBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error)
+	// GetBankAccountByID leaves Subscriptions nil unless withSubscriptions is set.
+	GetBankAccountByID(ctx context.Context, id uuid.UUID, withSubscriptions bool) (*model.BankAccount, error)
+	// LockBankAccount reads an account without its subscriptions and locks it
+	// until the transaction of ctx ends.
+	LockBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error)
+	// ListBankAccounts loads the subscriptions of the whole page with one query
+	// if the mask of the filter has them.
+	ListBankAccounts(ctx context.Context, filter *model.BankAccountFilter) ([]model.BankAccount, error)
+	// UpdateBankAccount copies the fields of the mask from account to the
+	// stored account.
+	UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount, mask model.UpdateMask) (*model.BankAccount, error)
+	// CloseBankAccount closes an empty account and ends its active
+	// subscriptions at closedAt.
+	CloseBankAccount(ctx context.Context, id uuid.UUID, closedAt time.Time) (*model.BankAccount, error)
+	FreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error)
+	UnfreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error)
+	Transfer(ctx context.Context, transfer *model.Transfer) (*model.BankAccount, *model.BankAccount, error)
+	ListLedgerEntries(ctx context.Context, filter *model.LedgerFilter) ([]model.LedgerEntry, error)
+	// ImportBankAccounts creates the accounts in one transaction. Accounts whose
+	// ID is already taken are skipped and their IDs are returned.
+	ImportBankAccounts(ctx context.Context, accounts []model.BankAccount) ([]uuid.UUID, error)
+	// ExportBankAccounts passes all accounts ordered by ID to fn page by page.
+	// Every page is read from the same snapshot of the database.
+	ExportBankAccounts(ctx context.Context, pageSize int, fn func(accounts []model.BankAccount) error) error
 }
 
 type BankAccountRepository struct {
-	db database.Database
+	db        database.Database
+	txManager database.TxManager
 }
 
-func NewBankAccountRepository(db database.Database) *BankAccountRepository {
+func NewBankAccountRepository(db database.Database, txManager database.TxManager) *BankAccountRepository {
 	return &BankAccountRepository{
-		db: db,
+		db:        db,
+		txManager: txManager,
 	}
 }
 
-func (r *BankAccountRepository) CreateBankAccount(ctx context.Context, account *model.BankAccount) (*model.BankAccount, error) {
-	tx, err := r.db.BeginTx()
+const bankAccountColumns = "id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at"
+
+// uniqueViolation is the SQLSTATE of an insert that breaks a unique constraint.
+const uniqueViolation = "23505"
+
+// transferIdempotencyKeyConstraint is the name Postgres gave the UNIQUE
+// constraint of transfer.idempotency_key.
+const transferIdempotencyKeyConstraint = "transfer_idempotency_key_key"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanBankAccount reads the bankAccountColumns of a row.
+func scanBankAccount(row rowScanner) (*model.BankAccount, error) {
+	var (
+		bankAccount model.BankAccount
+		closedAt    sql.NullTime
+	)
+	err := row.Scan(
+		&bankAccount.ID,
+		&bankAccount.HolderName,
+		&bankAccount.Balance,
+		&bankAccount.OpeningDate,
+		&bankAccount.BankName,
+		&bankAccount.Version,
+		&bankAccount.Currency,
+		&bankAccount.OwnerID,
+		&bankAccount.Status,
+		&closedAt,
+	)
 	if err != nil {
-		return nil, apperr.NewInternalServerError("Internal server error")
+		return nil, err
 	}
-	defer func() {
+	bankAccount.ClosedAt = closedAt.Time
+
+	return &bankAccount, nil
+}
+
+func (r *BankAccountRepository) CreateBankAccount(ctx context.Context, account *model.BankAccount) (*model.BankAccount, error) {
+	err := r.txManager.Do(ctx, func(ctx context.Context) error {
+		query := `INSERT INTO bank_account (id, holder_name, balance, opening_date, bank_name, currency, owner_id) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+		_, err := r.db.ExecuteContext(ctx, query, account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Currency, account.OwnerID)
 		if err != nil {
-			tx.Rollback()
-			return
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+
+		if account.Balance != 0 {
+			err = InsertLedgerEntries(ctx, r.db, model.NewDepositEntries(account, account.Balance, "opening balance", account.OpeningDate))
+			if err != nil {
+				return err
+			}
 		}
-		err = tx.Commit()
-	}()
 
-	query := `INSERT INTO bank_account (id, holder_name, balance, opening_date, bank_name) VALUES ($1, $2, $3, $4, $5) RETURNING id`
-	_, err = tx.Exec(query, account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName)
+		for _, sub := range account.Subscriptions {
+			query = `
+				INSERT INTO subscription (id, account_id, subscription_name, price, start_date, next_charge_at, currency) 
+				VALUES ($1, $2, $3, $4, $5, $5, $6) 
+				RETURNING id`
+			_, err = r.db.ExecuteContext(ctx, query, sub.ID, account.ID, sub.Name, sub.Price, sub.StartDate, sub.Currency)
+			if err != nil {
+				return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError(fmt.Sprintf("Internal server error: %s", err)))
+			}
+		}
+
+		return AddAccountEvent(ctx, r.db, outbox.AccountCreated, account)
+	})
 	if err != nil {
-		return nil, apperr.NewInternalServerError("Internal server error")
+		return nil, err
 	}
 
-	for _, sub := range account.Subscriptions {
-		query = `
-			INSERT INTO subscription (id, account_id, subscription_name, price, start_date) 
-			VALUES ($1, $2, $3, $4, $5) 
-			RETURNING id`
-		_, err = tx.Exec(query, sub.ID, account.ID, sub.Name, sub.Price, sub.StartDate)
+	return account, nil
+}
+
+var bankAccountCopyColumns = []string{"id", "holder_name", "balance", "opening_date", "bank_name", "currency", "version", "owner_id"}
+
+var subscriptionCopyColumns = []string{"id", "account_id", "subscription_name", "price", "start_date", "next_charge_at", "currency"}
+
+func (r *BankAccountRepository) ImportBankAccounts(ctx context.Context, accounts []model.BankAccount) (conflicts []uuid.UUID, err error) {
+	err = r.txManager.Do(ctx, func(ctx context.Context) error {
+		conflicts = nil
+
+		ids := make([]string, len(accounts))
+		for i := range accounts {
+			ids[i] = accounts[i].ID.String()
+		}
+		rows, err := r.db.QueryRowsContext(ctx, "SELECT id FROM bank_account WHERE id = ANY($1::uuid[])", pq.StringArray(ids))
 		if err != nil {
-			return nil, apperr.NewInternalServerError(fmt.Sprintf("Internal server error: %s", err))
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+		existing := make(map[uuid.UUID]bool)
+		for rows.Next() {
+			var id uuid.UUID
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+			}
+			existing[id] = true
 		}
+		rows.Close()
+
+		var (
+			accountRows      [][]interface{}
+			ledgerRows       [][]interface{}
+			subscriptionRows [][]interface{}
+			events           []outbox.Event
+		)
+		for i := range accounts {
+			account := &accounts[i]
+			if existing[account.ID] {
+				conflicts = append(conflicts, account.ID)
+				continue
+			}
+
+			accountRows = append(accountRows, []interface{}{
+				account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Currency, account.Version, account.OwnerID,
+			})
+			if account.Balance != 0 {
+				for _, entry := range model.NewDepositEntries(account, account.Balance, "opening balance", account.OpeningDate) {
+					ledgerRows = append(ledgerRows, ledgerEntryRow(entry))
+				}
+			}
+			for _, sub := range account.Subscriptions {
+				subscriptionRows = append(subscriptionRows, []interface{}{
+					sub.ID, account.ID, sub.Name, sub.Price, sub.StartDate, sub.StartDate, sub.Currency,
+				})
+			}
+
+			event, err := outbox.NewEvent(outbox.AggregateAccount, account.ID, outbox.AccountCreated, account.MapToDto())
+			if err != nil {
+				return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+			}
+			events = append(events, event)
+		}
+
+		if err = r.db.CopyInContext(ctx, "bank_account", bankAccountCopyColumns, accountRows); err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+		if err = r.db.CopyInContext(ctx, "ledger_entry", ledgerEntryColumns, ledgerRows); err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+		if err = r.db.CopyInContext(ctx, "subscription", subscriptionCopyColumns, subscriptionRows); err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+
+		return outbox.AddBatch(ctx, r.db, events)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return account, nil
+	return conflicts, nil
 }
 
-func (r *BankAccountRepository) GetBankAccountByID(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
-	query := "SELECT * FROM bank_account WHERE id = $1"
-	row := r.db.QueryRow(query, id)
+// ExportBankAccounts reads in a repeatable read transaction, so that accounts
+// changed during a long export are exported as they were when it started.
+func (r *BankAccountRepository) ExportBankAccounts(ctx context.Context, pageSize int, fn func(accounts []model.BankAccount) error) error {
+	return r.txManager.DoWithIsolation(ctx, sql.LevelRepeatableRead, func(ctx context.Context) error {
+		query := "SELECT " + bankAccountColumns + " FROM bank_account WHERE id > $1 ORDER BY id LIMIT $2"
+
+		after := uuid.Nil
+		for {
+			rows, err := r.db.QueryRowsContext(ctx, query, after, pageSize)
+			if err != nil {
+				return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+			}
+			var accounts []model.BankAccount
+			for rows.Next() {
+				bankAccount, err := scanBankAccount(rows)
+				if err != nil {
+					rows.Close()
+					return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+				}
+				accounts = append(accounts, *bankAccount)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+			}
+			if len(accounts) == 0 {
+				return nil
+			}
 
-	var bankAccount model.BankAccount
+			if err = r.loadSubscriptions(ctx, pointers(accounts)...); err != nil {
+				return err
+			}
 
-	if err := row.Scan(&bankAccount.ID, &bankAccount.HolderName, &bankAccount.Balance, &bankAccount.OpeningDate, &bankAccount.BankName); err != nil {
+			if err = fn(accounts); err != nil {
+				return err
+			}
+			if len(accounts) < pageSize {
+				return nil
+			}
+			after = accounts[len(accounts)-1].ID
+		}
+	})
+}
+
+func (r *BankAccountRepository) GetBankAccountByID(ctx context.Context, id uuid.UUID, withSubscriptions bool) (*model.BankAccount, error) {
+	query := "SELECT " + bankAccountColumns + " FROM bank_account WHERE id = $1"
+
+	bankAccount, err := scanBankAccount(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %s not found", id.String()))
 		}
-		return nil, apperr.NewInternalServerError("Internal server error")
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
 	}
 
-	subscriptions, err := r.getSubscripctionsByBankAccountID(id)
+	if withSubscriptions {
+		if err = r.loadSubscriptions(ctx, bankAccount); err != nil {
+			return nil, err
+		}
+	}
+
+	return bankAccount, nil
+}
+
+// GetBankAccountOwner returns the owner_id of the account for authorization.
+func (r *BankAccountRepository) GetBankAccountOwner(ctx context.Context, id uuid.UUID) (string, error) {
+	var owner string
+	err := r.db.QueryRowContext(ctx, "SELECT owner_id FROM bank_account WHERE id = $1", id).Scan(&owner)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %s not found", id.String()))
+		}
+		return "", apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
 	}
-	bankAccount.Subscriptions = subscriptions
+	return owner, nil
+}
 
-	return &bankAccount, nil
+// GetSubscriptionOwner returns the owner_id of the account the subscription
+// belongs to.
+func (r *BankAccountRepository) GetSubscriptionOwner(ctx context.Context, id uuid.UUID) (string, error) {
+	query := "SELECT a.owner_id FROM subscription s JOIN bank_account a ON a.id = s.account_id WHERE s.id = $1"
+	var owner string
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&owner); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", apperr.NewNotFoundError(fmt.Sprintf("Subscription with ID: %s not found", id.String()))
+		}
+		return "", apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
+	return owner, nil
 }
 
-func (r *BankAccountRepository) UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount) (*model.BankAccount, error) {
-	query := "UPDATE bank_account SET id = $1, holder_name = $2, balance = $3, bank_name = $4 WHERE id = $5 RETURNING id, holder_name, balance, opening_date, bank_name"
+// ListBankAccounts returns accounts matching the filter ordered by the sort
+// column and id, starting strictly after filter.After when it is set.
+func (r *BankAccountRepository) ListBankAccounts(ctx context.Context, filter *model.BankAccountFilter) ([]model.BankAccount, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	addCondition := func(condition string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = len(args)
+		}
+		conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+	}
 
-	var updatedAccount model.BankAccount
-	err := r.db.QueryRow(query, account.ID, account.HolderName, account.Balance, account.BankName, id).
-		Scan(&updatedAccount.ID, &updatedAccount.HolderName, &updatedAccount.Balance, &updatedAccount.OpeningDate, &updatedAccount.BankName)
+	if filter.BankName != "" {
+		addCondition("bank_name = $%d", filter.BankName)
+	}
+	if filter.Currency != "" {
+		addCondition("currency = $%d", filter.Currency)
+	}
+	if filter.OwnerID != "" {
+		addCondition("owner_id = $%d", filter.OwnerID)
+	}
+	if !filter.IncludeClosed {
+		addCondition("status <> $%d", model.StatusClosed)
+	}
+	if filter.HolderNamePrefix != "" {
+		addCondition(`holder_name LIKE $%d ESCAPE '\'`, likePrefix(filter.HolderNamePrefix))
+	}
+	if filter.MinBalance != nil {
+		addCondition("balance >= $%d", *filter.MinBalance)
+	}
+	if filter.MaxBalance != nil {
+		addCondition("balance <= $%d", *filter.MaxBalance)
+	}
+	if !filter.OpenedAfter.IsZero() {
+		addCondition("opening_date >= $%d", filter.OpenedAfter)
+	}
+	if !filter.OpenedBefore.IsZero() {
+		addCondition("opening_date < $%d", filter.OpenedBefore)
+	}
+
+	column := sortColumn(filter.SortBy)
+	comparison, direction := ">", "ASC"
+	if filter.Descending {
+		comparison, direction = "<", "DESC"
+	}
+	if filter.After != nil {
+		value, err := filter.After.SortValue()
+		if err != nil {
+			return nil, apperr.NewBadRequestError("invalid page token")
+		}
+		addCondition(fmt.Sprintf("(%s, id) %s ($%%d, $%%d)", column, comparison), value, filter.After.ID)
+	}
 
+	query := "SELECT " + bankAccountColumns + " FROM bank_account"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", column, direction, direction, len(args))
+
+	rows, err := r.db.QueryRowsContext(ctx, query, args...)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, apperr.NewNotFoundError(fmt.Sprintf("bank account with ID: %s not found", id))
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
+	defer rows.Close()
+
+	accounts := make([]model.BankAccount, 0)
+	for rows.Next() {
+		bankAccount, err := scanBankAccount(rows)
+		if err != nil {
+			return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+		accounts = append(accounts, *bankAccount)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
+
+	if filter.Mask.Has(model.FieldSubscriptions) {
+		if err = r.loadSubscriptions(ctx, pointers(accounts)...); err != nil {
+			return nil, err
+		}
+	}
+
+	return accounts, nil
+}
+
+func sortColumn(field model.SortField) string {
+	switch field {
+	case model.SortByBalance:
+		return "balance"
+	case model.SortByHolderName:
+		return "holder_name"
+	default:
+		return "opening_date"
+	}
+}
+
+func likePrefix(prefix string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return replacer.Replace(prefix) + "%"
+}
+
+func (r *BankAccountRepository) UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount, mask model.UpdateMask) (*model.BankAccount, error) {
+	var updatedAccount *model.BankAccount
+	err := r.txManager.Do(ctx, func(ctx context.Context) error {
+		current, err := r.LockBankAccount(ctx, id)
+		if err != nil {
+			return err
+		}
+		if current.IsClosed() {
+			return apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s is closed", id))
+		}
+		if current.Version != account.Version {
+			return apperr.NewPreconditionFailedError(fmt.Sprintf("Bank account with ID: %s was modified, current version is %d", id, current.Version))
+		}
+		if account.Currency != "" && account.Currency != current.Currency {
+			return apperr.NewBadRequestError(fmt.Sprintf("Currency of bank account with ID: %s cannot be changed", id))
+		}
+		changed := *current
+		mask.Apply(&changed, account)
+		if changed.Balance < current.Balance && !current.CanBeDebited() {
+			return apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s is frozen, its balance cannot be decreased", id))
+		}
+
+		query := "UPDATE bank_account SET holder_name = $1, balance = $2, bank_name = $3, version = version + 1 WHERE id = $4 RETURNING " + bankAccountColumns
+
+		updatedAccount, err = scanBankAccount(r.db.QueryRowContext(ctx, query, changed.HolderName, changed.Balance, changed.BankName, id))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperr.NewNotFoundError(fmt.Sprintf("bank account with ID: %s not found", id))
+			}
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+
+		if delta := updatedAccount.Balance - current.Balance; delta != 0 {
+			err = InsertLedgerEntries(ctx, r.db, model.NewDepositEntries(updatedAccount, delta, "balance adjustment", time.Now()))
+			if err != nil {
+				return err
+			}
 		}
-		return nil, apperr.NewInternalServerError("Internal server error")
+
+		return AddAccountEvent(ctx, r.db, outbox.AccountUpdated, updatedAccount)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return &updatedAccount, nil
+	return updatedAccount, nil
 }
 
-func (r *BankAccountRepository) DeleteBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
-	tx, err := r.db.BeginTx()
+// CloseBankAccount keeps the account and its history. Active subscriptions
+// end at closedAt, so that they are not charged anymore.
+func (r *BankAccountRepository) CloseBankAccount(ctx context.Context, id uuid.UUID, closedAt time.Time) (*model.BankAccount, error) {
+	var closedAccount *model.BankAccount
+	err := r.txManager.Do(ctx, func(ctx context.Context) error {
+		current, err := r.LockBankAccount(ctx, id)
+		if err != nil {
+			return err
+		}
+		if current.Status != model.StatusActive {
+			return apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s is %s", id, current.Status))
+		}
+		if current.Balance != 0 {
+			return apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s has a balance of %d, only an empty account can be closed", id, current.Balance))
+		}
+
+		query := `
+			UPDATE subscription 
+			SET end_date = $1 
+			WHERE account_id = $2 AND (end_date IS NULL OR end_date > $1) 
+			RETURNING id, subscription_name, price, start_date, end_date, account_id, status, currency`
+		rows, err := r.db.QueryRowsContext(ctx, query, closedAt, id)
+		if err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+		var cancelled []subscription.Subscription
+		for rows.Next() {
+			var (
+				sub     subscription.Subscription
+				endDate sql.NullTime
+			)
+			if err = rows.Scan(&sub.ID, &sub.Name, &sub.Price, &sub.StartDate, &endDate, &sub.AccountID, &sub.Status, &sub.Currency); err != nil {
+				rows.Close()
+				return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+			}
+			sub.EndDate = endDate.Time
+			cancelled = append(cancelled, sub)
+		}
+		rows.Close()
+
+		for _, sub := range cancelled {
+			event, err := outbox.NewEvent(outbox.AggregateSubscription, sub.ID, outbox.SubscriptionCancelled, sub.MapToDto())
+			if err != nil {
+				return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+			}
+			if err = outbox.Add(ctx, r.db, event); err != nil {
+				return err
+			}
+		}
+
+		query = "UPDATE bank_account SET status = $1, closed_at = $2, version = version + 1 WHERE id = $3 RETURNING " + bankAccountColumns
+		closedAccount, err = scanBankAccount(r.db.QueryRowContext(ctx, query, model.StatusClosed, closedAt, id))
+		if err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+		if err = r.loadSubscriptions(ctx, closedAccount); err != nil {
+			return err
+		}
+
+		return AddAccountEvent(ctx, r.db, outbox.AccountClosed, closedAccount)
+	})
 	if err != nil {
-		return nil, apperr.NewInternalServerError("Internal server error")
+		return nil, err
 	}
-	defer func() {
+
+	return closedAccount, nil
+}
+
+func (r *BankAccountRepository) FreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	return r.changeStatus(ctx, id, model.StatusActive, model.StatusFrozen, outbox.AccountFrozen)
+}
+
+func (r *BankAccountRepository) UnfreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	return r.changeStatus(ctx, id, model.StatusFrozen, model.StatusActive, outbox.AccountUnfrozen)
+}
+
+func (r *BankAccountRepository) changeStatus(ctx context.Context, id uuid.UUID, from string, to string, eventType string) (*model.BankAccount, error) {
+	var changedAccount *model.BankAccount
+	err := r.txManager.Do(ctx, func(ctx context.Context) error {
+		current, err := r.LockBankAccount(ctx, id)
+		if err != nil {
+			return err
+		}
+		if current.Status != from {
+			return apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s is %s", id, current.Status))
+		}
+
+		query := "UPDATE bank_account SET status = $1, version = version + 1 WHERE id = $2 RETURNING " + bankAccountColumns
+		changedAccount, err = scanBankAccount(r.db.QueryRowContext(ctx, query, to, id))
+		if err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+		if err = r.loadSubscriptions(ctx, changedAccount); err != nil {
+			return err
+		}
+
+		return AddAccountEvent(ctx, r.db, eventType, changedAccount)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return changedAccount, nil
+}
+
+// Transfer moves money between two accounts in a single transaction. Both rows
+// are locked in id order so that concurrent opposite transfers cannot deadlock.
+// A repeated idempotency key returns the accounts as the first transfer left
+// them without charging again; transfers recorded before the accounts were
+// stored with them return the current accounts. A transfer that loses the race
+// for its key to a concurrent one is aborted, its retry is a replay.
+func (r *BankAccountRepository) Transfer(ctx context.Context, transfer *model.Transfer) (from *model.BankAccount, to *model.BankAccount, err error) {
+	err = r.txManager.Do(ctx, func(ctx context.Context) error {
+		firstID, secondID := transfer.LockOrder()
+		first, err := r.LockBankAccount(ctx, firstID)
+		if err != nil {
+			return err
+		}
+		second, err := r.LockBankAccount(ctx, secondID)
+		if err != nil {
+			return err
+		}
+		from, to = first, second
+		if first.ID != transfer.FromID {
+			from, to = second, first
+		}
+
+		if transfer.IdempotencyKey != "" {
+			var (
+				existing model.Transfer
+				response []byte
+			)
+			query := `SELECT from_account_id, to_account_id, amount, response FROM transfer WHERE idempotency_key = $1`
+			err = r.db.QueryRowContext(ctx, query, transfer.IdempotencyKey).Scan(&existing.FromID, &existing.ToID, &existing.Amount, &response)
+			if err == nil {
+				if existing.FromID != transfer.FromID || existing.ToID != transfer.ToID || existing.Amount != transfer.Amount {
+					return apperr.NewBadRequestError("Idempotency key was already used for another transfer")
+				}
+				if response == nil {
+					return nil
+				}
+				var result transferResult
+				if err = json.Unmarshal(response, &result); err != nil {
+					return apperr.NewInternalServerError("Internal server error")
+				}
+				from, to = result.From, result.To
+				return nil
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+			}
+		}
+
+		if !from.CanBeDebited() {
+			return apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s is %s", from.ID, from.Status))
+		}
+		if to.IsClosed() {
+			return apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s is closed", to.ID))
+		}
+		if from.Balance < transfer.Amount {
+			return apperr.NewBadRequestError(fmt.Sprintf("Insufficient funds on bank account with ID: %s", from.ID))
+		}
+
+		query := `UPDATE bank_account SET balance = balance + $1, version = version + 1 WHERE id = $2 RETURNING balance, version`
+		if err = r.db.QueryRowContext(ctx, query, -transfer.Amount, from.ID).Scan(&from.Balance, &from.Version); err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+		if err = r.db.QueryRowContext(ctx, query, transfer.ToAmount, to.ID).Scan(&to.Balance, &to.Version); err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+
+		var response []byte
+		if transfer.IdempotencyKey != "" {
+			if response, err = json.Marshal(transferResult{From: from, To: to}); err != nil {
+				return apperr.NewInternalServerError("Internal server error")
+			}
+		}
+		query = `
+			INSERT INTO transfer (id, from_account_id, to_account_id, amount, to_amount, idempotency_key, created_at, response) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+		_, err = r.db.ExecuteContext(ctx, query, transfer.ID, transfer.FromID, transfer.ToID, transfer.Amount, transfer.ToAmount,
+			sql.NullString{String: transfer.IdempotencyKey, Valid: transfer.IdempotencyKey != ""}, transfer.CreatedAt, response)
 		if err != nil {
-			tx.Rollback()
-			return
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && pqErr.Constraint == transferIdempotencyKeyConstraint {
+				// a concurrent transfer with the key committed first
+				return apperr.NewAbortedError("A transfer with the same idempotency key ran concurrently, retry to get its result")
+			}
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+
+		if err = InsertLedgerEntries(ctx, r.db, model.NewTransferEntries(transfer, from, to)); err != nil {
+			return err
 		}
-		err = tx.Commit()
-	}()
 
-	rows, err := tx.Query("DELETE FROM subscription WHERE account_id = $1 RETURNING id, subscription_name, price, start_date, end_date, account_id", id)
+		if err = AddAccountEvent(ctx, r.db, outbox.AccountUpdated, from); err != nil {
+			return err
+		}
+		return AddAccountEvent(ctx, r.db, outbox.AccountUpdated, to)
+	})
 	if err != nil {
-		return nil, apperr.NewInternalServerError("Internal server error")
+		return nil, nil, err
+	}
+
+	return from, to, nil
+}
+
+// transferResult is stored with a transfer that has an idempotency key.
+type transferResult struct {
+	From *model.BankAccount
+	To   *model.BankAccount
+}
+
+// ListLedgerEntries returns entries of a single account from the newest to the
+// oldest, starting strictly after filter.After when it is set.
+func (r *BankAccountRepository) ListLedgerEntries(ctx context.Context, filter *model.LedgerFilter) ([]model.LedgerEntry, error) {
+	query := `
+		SELECT id, transaction_id, account_id, amount, balance_after, currency, description, created_at
+		FROM ledger_entry
+		WHERE account_id = $1`
+	args := []interface{}{filter.AccountID}
+
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.CreatedAt, filter.After.ID)
+		query += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.QueryRowsContext(ctx, query, args...)
+	if err != nil {
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
 	}
 	defer rows.Close()
 
-	var deletedSubscriptions []subscription.Subscription
+	entries := make([]model.LedgerEntry, 0)
 	for rows.Next() {
-		var sub subscription.Subscription
-		err := rows.Scan(&sub.ID, &sub.Name, &sub.Price, &sub.StartDate, &sql.NullTime{Time: sub.EndDate, Valid: true}, &sub.AccountID)
+		var entry model.LedgerEntry
+		err := rows.Scan(
+			&entry.ID,
+			&entry.TransactionID,
+			&entry.AccountID,
+			&entry.Amount,
+			&entry.BalanceAfter,
+			&entry.Currency,
+			&entry.Description,
+			&entry.CreatedAt,
+		)
 		if err != nil {
-			return nil, apperr.NewInternalServerError("Internal server error")
+			return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
 		}
-		deletedSubscriptions = append(deletedSubscriptions, sub)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
 	}
 
+	return entries, nil
+}
+
+// InsertLedgerEntries writes ledger rows inside the transaction that changed
+// the balances they describe, so ctx must carry that transaction.
+func InsertLedgerEntries(ctx context.Context, db database.Database, entries []model.LedgerEntry) error {
 	query := `
-		DELETE FROM bank_account 
-		WHERE id = $1 
-		RETURNING id, holder_name, balance, opening_date, bank_name`
+		INSERT INTO ledger_entry (id, transaction_id, account_id, amount, balance_after, currency, description, created_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
 
-	var deletedAccount model.BankAccount
-	err = tx.QueryRow(query, id).
-		Scan(&deletedAccount.ID, &deletedAccount.HolderName, &deletedAccount.Balance, &deletedAccount.OpeningDate, &deletedAccount.BankName)
+	for _, entry := range entries {
+		_, err := db.ExecuteContext(ctx, query, ledgerEntryRow(entry)...)
+		if err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+	}
+
+	return nil
+}
+
+var ledgerEntryColumns = []string{"id", "transaction_id", "account_id", "amount", "balance_after", "currency", "description", "created_at"}
+
+// ledgerEntryRow returns the values of ledgerEntryColumns. The external side
+// has neither an account nor a balance.
+func ledgerEntryRow(entry model.LedgerEntry) []interface{} {
+	accountID := uuid.NullUUID{UUID: entry.AccountID, Valid: entry.AccountID != uuid.Nil}
+	balanceAfter := sql.NullInt64{Int64: entry.BalanceAfter, Valid: accountID.Valid}
+	return []interface{}{entry.ID, entry.TransactionID, accountID, entry.Amount, balanceAfter, entry.Currency, entry.Description, entry.CreatedAt}
+}
 
+// AddAccountEvent writes an event about account to the outbox inside the
+// transaction that changed it, so ctx must carry that transaction.
+func AddAccountEvent(ctx context.Context, db database.Database, eventType string, account *model.BankAccount) error {
+	event, err := outbox.NewEvent(outbox.AggregateAccount, account.ID, eventType, account.MapToDto())
+	if err != nil {
+		return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
+	return outbox.Add(ctx, db, event)
+}
+
+func (r *BankAccountRepository) LockBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	query := "SELECT " + bankAccountColumns + " FROM bank_account WHERE id = $1 FOR UPDATE"
+
+	bankAccount, err := scanBankAccount(r.db.QueryRowContext(ctx, query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, apperr.NewNotFoundError(fmt.Sprintf("bank account with ID: %s does not exist", id.String()))
+			return nil, apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %s not found", id.String()))
 		}
-		return nil, apperr.NewInternalServerError("Internal server error")
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
 	}
 
-	deletedAccount.Subscriptions = deletedSubscriptions
+	return bankAccount, nil
+}
+
+// loadSubscriptions sets the subscriptions of the accounts with one query, no
+// matter how many accounts there are.
+func (r *BankAccountRepository) loadSubscriptions(ctx context.Context, accounts ...*model.BankAccount) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(accounts))
+	for i, account := range accounts {
+		ids[i] = account.ID
+	}
 
-	return &deletedAccount, nil
+	subscriptions, err := r.getSubscriptionsByBankAccountIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		account.Subscriptions = subscriptions[account.ID]
+	}
+	return nil
 }
 
-func (r *BankAccountRepository) getSubscripctionsByBankAccountID(id uuid.UUID) ([]subscription.Subscription, error) {
+func pointers(accounts []model.BankAccount) []*model.BankAccount {
+	result := make([]*model.BankAccount, len(accounts))
+	for i := range accounts {
+		result[i] = &accounts[i]
+	}
+	return result
+}
+
+// getSubscriptionsByBankAccountIDs loads the subscriptions of several accounts
+// with one query and groups them by account.
+func (r *BankAccountRepository) getSubscriptionsByBankAccountIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID][]subscription.Subscription, error) {
 	query := `
         SELECT 
             s.id,
@@ -163,31 +818,38 @@ func (r *BankAccountRepository) getSubscripctionsByBankAccountID(id uuid.UUID) (
             s.price,
             s.start_date,
             s.end_date,
-            s.account_id
+            s.account_id,
+            s.status,
+            s.currency
         FROM subscription s
-        WHERE s.account_id = $1`
+        WHERE s.account_id = ANY($1::uuid[])
+        ORDER BY s.account_id, s.start_date, s.id`
 
-	rows, err := r.db.QueryRows(query, id)
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = id.String()
+	}
+	rows, err := r.db.QueryRowsContext(ctx, query, pq.StringArray(values))
 	if err != nil {
-		return nil, apperr.NewInternalServerError("Internal server error")
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
 	}
 	defer rows.Close()
 
-	var subscriptions []subscription.Subscription
+	subscriptions := make(map[uuid.UUID][]subscription.Subscription, len(ids))
 	for rows.Next() {
-		var sub subscription.Subscription
-		err := rows.Scan(
-			&sub.ID,
-			&sub.Name,
-			&sub.Price,
-			&sub.StartDate,
-			&sql.NullTime{Time: sub.EndDate, Valid: true},
-			&sub.AccountID,
+		var (
+			sub     subscription.Subscription
+			endDate sql.NullTime
 		)
+		err := rows.Scan(&sub.ID, &sub.Name, &sub.Price, &sub.StartDate, &endDate, &sub.AccountID, &sub.Status, &sub.Currency)
 		if err != nil {
-			return nil, apperr.NewInternalServerError("Internal server error")
+			return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
 		}
-		subscriptions = append(subscriptions, sub)
+		sub.EndDate = endDate.Time
+		subscriptions[sub.AccountID] = append(subscriptions[sub.AccountID], sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
 	}
 
 	return subscriptions, nil
@@ -2,8 +2,13 @@ package account
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
@@ -11,6 +16,7 @@ import (
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/tests/fixtures"
 	"testing"
+	"time"
 )
 
 type bankAccountRepoFixture struct {
@@ -28,7 +34,7 @@ func NewBankAccountRepoFixture(t *testing.T) (*bankAccountRepoFixture, error) {
 	if err != nil {
 		t.Fatalf("Error creating mock database: %v", err)
 	}
-	repo := NewBankAccountRepository(sqlDatabase)
+	repo := NewBankAccountRepository(sqlDatabase, database.NewTxManager(sqlDatabase, sql.LevelDefault, 0))
 	return &bankAccountRepoFixture{
 		mockSqlDb: &mock,
 		repo:      repo,
@@ -56,8 +62,17 @@ func TestCreateBankAccountRepo(t *testing.T) {
 			bankAccount: *bankAccount,
 			mockSQL: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec(`INSERT INTO bank_account \(id, holder_name, balance, opening_date, bank_name\) VALUES \(\$1, \$2, \$3, \$4, \$5\) RETURNING id`).
-					WithArgs(bankAccount.ID, bankAccount.HolderName, bankAccount.Balance, sqlmock.AnyArg(), bankAccount.BankName).
+				mock.ExpectExec(`INSERT INTO bank_account \(id, holder_name, balance, opening_date, bank_name, currency, owner_id\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7\) RETURNING id`).
+					WithArgs(bankAccount.ID, bankAccount.HolderName, bankAccount.Balance, sqlmock.AnyArg(), bankAccount.BankName, bankAccount.Currency, bankAccount.OwnerID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO ledger_entry`).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), uuid.NullUUID{UUID: bankAccount.ID, Valid: true}, bankAccount.Balance, sqlmock.AnyArg(), bankAccount.Currency, "opening balance", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO ledger_entry`).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), uuid.NullUUID{}, -bankAccount.Balance, sqlmock.AnyArg(), bankAccount.Currency, "opening balance", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("account", bankAccount.ID, "account.created", sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 			},
@@ -69,8 +84,8 @@ func TestCreateBankAccountRepo(t *testing.T) {
 			bankAccount: *invalidBankAccount,
 			mockSQL: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec(`^INSERT INTO bank_account \(id, holder_name, balance, opening_date, bank_name\) VALUES \(\$1, \$2, \$3, \$4, \$5\) RETURNING id$`).
-					WithArgs(sqlmock.AnyArg(), "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+				mock.ExpectExec(`^INSERT INTO bank_account \(id, holder_name, balance, opening_date, bank_name, currency, owner_id\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7\) RETURNING id$`).
+					WithArgs(sqlmock.AnyArg(), "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnError(errors.New("null value in column \"holder_name\" violates not-null constraint"))
 				mock.ExpectRollback()
 			},
@@ -105,3 +120,763 @@ func TestCreateBankAccountRepo(t *testing.T) {
 		})
 	}
 }
+
+func TestTransferRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx         = context.Background()
+		fromAccount = fixtures.NewBankAccountBuilder().Valid().Balance(1000).Build()
+		toID, _     = uuid.Parse("f3c1a4f2-1111-4b7a-9c9d-7f8e6a5b4c3d")
+		toAccount   = fixtures.NewBankAccountBuilder().Valid().ID(toID).Balance(200).Build()
+		lockQuery   = `SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account WHERE id = \$1 FOR UPDATE`
+		columns     = []string{"id", "holder_name", "balance", "opening_date", "bank_name", "version", "currency", "owner_id", "status", "closed_at"}
+	)
+
+	accountRow := func(account *model.BankAccount) *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, account.Status, nil)
+	}
+	var (
+		transferQuery   = `SELECT from_account_id, to_account_id, amount, response FROM transfer WHERE idempotency_key = \$1`
+		transferColumns = []string{"from_account_id", "to_account_id", "amount", "response"}
+	)
+	// the accounts as a transfer of 300 left them
+	transferred := func(account *model.BankAccount, balance int64) *model.BankAccount {
+		changed := *account
+		changed.Balance = balance
+		return &changed
+	}
+	response, err := json.Marshal(transferResult{From: transferred(fromAccount, 700), To: transferred(toAccount, 500)})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		transfer        model.Transfer
+		mockSQL         func(mock sqlmock.Sqlmock)
+		expectedBalance [2]int64
+		expectedError   error
+	}{
+		{
+			name:     "Success",
+			transfer: model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: 300, ToAmount: 300},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(fromAccount.ID).WillReturnRows(accountRow(fromAccount))
+				mock.ExpectQuery(lockQuery).WithArgs(toID).WillReturnRows(accountRow(toAccount))
+				mock.ExpectQuery(`UPDATE bank_account SET balance = balance \+ \$1, version = version \+ 1 WHERE id = \$2 RETURNING balance, version`).
+					WithArgs(int64(-300), fromAccount.ID).
+					WillReturnRows(sqlmock.NewRows([]string{"balance", "version"}).AddRow(700, 2))
+				mock.ExpectQuery(`UPDATE bank_account SET balance = balance \+ \$1, version = version \+ 1 WHERE id = \$2 RETURNING balance, version`).
+					WithArgs(int64(300), toID).
+					WillReturnRows(sqlmock.NewRows([]string{"balance", "version"}).AddRow(500, 2))
+				mock.ExpectExec(`INSERT INTO transfer`).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO ledger_entry`).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), uuid.NullUUID{UUID: fromAccount.ID, Valid: true}, int64(-300), sqlmock.AnyArg(), "RUB", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO ledger_entry`).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), uuid.NullUUID{UUID: toID, Valid: true}, int64(300), sqlmock.AnyArg(), "RUB", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("account", fromAccount.ID, "account.updated", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("account", toID, "account.updated", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedBalance: [2]int64{700, 500},
+		},
+		{
+			name:     "Fail, insufficient funds",
+			transfer: model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: 5000, ToAmount: 5000},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(fromAccount.ID).WillReturnRows(accountRow(fromAccount))
+				mock.ExpectQuery(lockQuery).WithArgs(toID).WillReturnRows(accountRow(toAccount))
+				mock.ExpectRollback()
+			},
+			expectedError: apperr.NewBadRequestError(fmt.Sprintf("Insufficient funds on bank account with ID: %s", fromAccount.ID)),
+		},
+		{
+			name:     "Fail, source account frozen",
+			transfer: model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: 300, ToAmount: 300},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				frozen := *fromAccount
+				frozen.Status = model.StatusFrozen
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(fromAccount.ID).WillReturnRows(accountRow(&frozen))
+				mock.ExpectQuery(lockQuery).WithArgs(toID).WillReturnRows(accountRow(toAccount))
+				mock.ExpectRollback()
+			},
+			expectedError: apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s is frozen", fromAccount.ID)),
+		},
+		{
+			name:     "Replay of the same idempotency key",
+			transfer: model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: 300, ToAmount: 300, IdempotencyKey: "key"},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(fromAccount.ID).WillReturnRows(accountRow(fromAccount))
+				mock.ExpectQuery(lockQuery).WithArgs(toID).WillReturnRows(accountRow(toAccount))
+				mock.ExpectQuery(transferQuery).
+					WithArgs("key").
+					WillReturnRows(sqlmock.NewRows(transferColumns).AddRow(fromAccount.ID, toID, 300, response))
+				mock.ExpectCommit()
+			},
+			expectedBalance: [2]int64{700, 500},
+		},
+		{
+			name:     "Replay of a transfer recorded without its accounts",
+			transfer: model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: 300, ToAmount: 300, IdempotencyKey: "key"},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(fromAccount.ID).WillReturnRows(accountRow(fromAccount))
+				mock.ExpectQuery(lockQuery).WithArgs(toID).WillReturnRows(accountRow(toAccount))
+				mock.ExpectQuery(transferQuery).
+					WithArgs("key").
+					WillReturnRows(sqlmock.NewRows(transferColumns).AddRow(fromAccount.ID, toID, 300, nil))
+				mock.ExpectCommit()
+			},
+			expectedBalance: [2]int64{fromAccount.Balance, toAccount.Balance},
+		},
+		{
+			name:     "Fail, concurrent transfer with the same idempotency key",
+			transfer: model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: 300, ToAmount: 300, IdempotencyKey: "key"},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(fromAccount.ID).WillReturnRows(accountRow(fromAccount))
+				mock.ExpectQuery(lockQuery).WithArgs(toID).WillReturnRows(accountRow(toAccount))
+				mock.ExpectQuery(transferQuery).WithArgs("key").WillReturnRows(sqlmock.NewRows(transferColumns))
+				mock.ExpectQuery(`UPDATE bank_account SET balance = balance \+ \$1`).
+					WithArgs(int64(-300), fromAccount.ID).
+					WillReturnRows(sqlmock.NewRows([]string{"balance", "version"}).AddRow(700, 2))
+				mock.ExpectQuery(`UPDATE bank_account SET balance = balance \+ \$1`).
+					WithArgs(int64(300), toID).
+					WillReturnRows(sqlmock.NewRows([]string{"balance", "version"}).AddRow(500, 2))
+				mock.ExpectExec(`INSERT INTO transfer`).
+					WithArgs(sqlmock.AnyArg(), fromAccount.ID, toID, int64(300), int64(300),
+						sql.NullString{String: "key", Valid: true}, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnError(&pq.Error{Code: "23505", Constraint: "transfer_idempotency_key_key"})
+				mock.ExpectRollback()
+			},
+			expectedError: apperr.NewAbortedError("A transfer with the same idempotency key ran concurrently, retry to get its result"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fixture, err := NewBankAccountRepoFixture(t)
+			if err != nil {
+				t.Fatalf("Error setting up test fixture: %v", err)
+			}
+
+			tc.mockSQL(*fixture.mockSqlDb)
+
+			from, to, err := fixture.repo.Transfer(ctx, &tc.transfer)
+
+			if tc.expectedError != nil {
+				require.Error(t, err)
+				assert.Equal(t, tc.expectedError, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedBalance, [2]int64{from.Balance, to.Balance})
+			}
+
+			err = (*fixture.mockSqlDb).ExpectationsWereMet()
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestUpdateBankAccountRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		current   = fixtures.NewBankAccountBuilder().Valid().Version(3).Build()
+		update    = fixtures.NewBankAccountBuilder().Valid().HolderName("Dima Petrov").Version(3).Build()
+		stale     = fixtures.NewBankAccountBuilder().Valid().HolderName("Dima Petrov").Version(2).Build()
+		renamed   = &model.BankAccount{HolderName: "Dima Petrov", Version: 3}
+		rebalance = fixtures.NewBankAccountBuilder().Valid().HolderName("Dima Petrov").Balance(current.Balance + 500).Version(3).Build()
+		lockQuery = `SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account WHERE id = \$1 FOR UPDATE`
+		columns   = []string{"id", "holder_name", "balance", "opening_date", "bank_name", "version", "currency", "owner_id", "status", "closed_at"}
+	)
+
+	tests := []struct {
+		name            string
+		account         *model.BankAccount
+		mask            model.UpdateMask
+		mockSQL         func(mock sqlmock.Sqlmock)
+		expectedVersion int64
+		expectedError   error
+	}{
+		{
+			name:    "Success",
+			account: update,
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(current.ID).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(current.ID, current.HolderName, current.Balance, current.OpeningDate, current.BankName, current.Version, current.Currency, current.OwnerID, current.Status, nil))
+				mock.ExpectQuery(`UPDATE bank_account SET holder_name = \$1, balance = \$2, bank_name = \$3, version = version \+ 1 WHERE id = \$4`).
+					WithArgs(update.HolderName, update.Balance, update.BankName, current.ID).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(update.ID, update.HolderName, update.Balance, update.OpeningDate, update.BankName, 4, update.Currency, update.OwnerID, update.Status, nil))
+				mock.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("account", update.ID, "account.updated", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedVersion: 4,
+		},
+		{
+			name:    "Success, partial update keeps other fields",
+			account: renamed,
+			mask:    model.UpdateMask{"holder_name": {}},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(current.ID).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(current.ID, current.HolderName, current.Balance, current.OpeningDate, current.BankName, current.Version, current.Currency, current.OwnerID, current.Status, nil))
+				mock.ExpectQuery(`UPDATE bank_account SET holder_name = \$1, balance = \$2, bank_name = \$3, version = version \+ 1 WHERE id = \$4`).
+					WithArgs(renamed.HolderName, current.Balance, current.BankName, current.ID).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(current.ID, renamed.HolderName, current.Balance, current.OpeningDate, current.BankName, 4, current.Currency, current.OwnerID, current.Status, nil))
+				mock.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("account", current.ID, "account.updated", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedVersion: 4,
+		},
+		{
+			name:    "Success, no mask keeps the balance",
+			account: rebalance,
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(current.ID).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(current.ID, current.HolderName, current.Balance, current.OpeningDate, current.BankName, current.Version, current.Currency, current.OwnerID, current.Status, nil))
+				mock.ExpectQuery(`UPDATE bank_account SET holder_name = \$1, balance = \$2, bank_name = \$3, version = version \+ 1 WHERE id = \$4`).
+					WithArgs(rebalance.HolderName, current.Balance, rebalance.BankName, current.ID).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(current.ID, rebalance.HolderName, current.Balance, current.OpeningDate, rebalance.BankName, 4, current.Currency, current.OwnerID, current.Status, nil))
+				mock.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("account", current.ID, "account.updated", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedVersion: 4,
+		},
+		{
+			name:    "Fail, stale version",
+			account: stale,
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(current.ID).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(current.ID, current.HolderName, current.Balance, current.OpeningDate, current.BankName, current.Version, current.Currency, current.OwnerID, current.Status, nil))
+				mock.ExpectRollback()
+			},
+			expectedError: apperr.NewPreconditionFailedError(fmt.Sprintf("Bank account with ID: %s was modified, current version is 3", current.ID)),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fixture, err := NewBankAccountRepoFixture(t)
+			if err != nil {
+				t.Fatalf("Error setting up test fixture: %v", err)
+			}
+
+			tc.mockSQL(*fixture.mockSqlDb)
+
+			mask := tc.mask
+			if mask == nil {
+				mask = model.DefaultUpdateMask()
+			}
+			updated, err := fixture.repo.UpdateBankAccount(ctx, current.ID, tc.account, mask)
+
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedVersion, updated.Version)
+			}
+
+			err = (*fixture.mockSqlDb).ExpectationsWereMet()
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCloseBankAccountRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx        = context.Background()
+		closedAt   = time.Date(2023, 10, 6, 12, 0, 0, 0, time.UTC)
+		empty      = fixtures.NewBankAccountBuilder().Valid().Balance(0).Build()
+		subID      = uuid.New()
+		lockQuery  = `SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account WHERE id = \$1 FOR UPDATE`
+		columns    = []string{"id", "holder_name", "balance", "opening_date", "bank_name", "version", "currency", "owner_id", "status", "closed_at"}
+		subColumns = []string{"id", "subscription_name", "price", "start_date", "end_date", "account_id", "status", "currency"}
+	)
+
+	accountRow := func(account *model.BankAccount, status string, closedAt interface{}) *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, status, closedAt)
+	}
+
+	tests := []struct {
+		name          string
+		mockSQL       func(mock sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name: "Success",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(empty.ID).WillReturnRows(accountRow(empty, model.StatusActive, nil))
+				mock.ExpectQuery(`UPDATE subscription\s+SET end_date = \$1\s+WHERE account_id = \$2 AND \(end_date IS NULL OR end_date > \$1\)`).
+					WithArgs(closedAt, empty.ID).
+					WillReturnRows(sqlmock.NewRows(subColumns).AddRow(subID, "Music", 100, time.Time{}, closedAt, empty.ID, "active", "RUB"))
+				mock.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("subscription", subID, "subscription.cancelled", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(`UPDATE bank_account SET status = \$1, closed_at = \$2, version = version \+ 1 WHERE id = \$3`).
+					WithArgs(model.StatusClosed, closedAt, empty.ID).
+					WillReturnRows(accountRow(empty, model.StatusClosed, closedAt))
+				mock.ExpectQuery(`FROM subscription s`).
+					WithArgs(pq.StringArray{empty.ID.String()}).
+					WillReturnRows(sqlmock.NewRows(subColumns))
+				mock.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("account", empty.ID, "account.closed", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Fail, balance is not zero",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				withMoney := *empty
+				withMoney.Balance = 100
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(empty.ID).WillReturnRows(accountRow(&withMoney, model.StatusActive, nil))
+				mock.ExpectRollback()
+			},
+			expectedError: apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s has a balance of 100, only an empty account can be closed", empty.ID)),
+		},
+		{
+			name: "Fail, already closed",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).WithArgs(empty.ID).WillReturnRows(accountRow(empty, model.StatusClosed, closedAt))
+				mock.ExpectRollback()
+			},
+			expectedError: apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s is closed", empty.ID)),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fixture, err := NewBankAccountRepoFixture(t)
+			if err != nil {
+				t.Fatalf("Error setting up test fixture: %v", err)
+			}
+
+			tc.mockSQL(*fixture.mockSqlDb)
+
+			closed, err := fixture.repo.CloseBankAccount(ctx, empty.ID, closedAt)
+
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, closed.IsClosed())
+				assert.Equal(t, closedAt, closed.ClosedAt)
+			}
+
+			err = (*fixture.mockSqlDb).ExpectationsWereMet()
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestFreezeBankAccountRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		account   = fixtures.NewBankAccountBuilder().Valid().Build()
+		lockQuery = `SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account WHERE id = \$1 FOR UPDATE`
+		columns   = []string{"id", "holder_name", "balance", "opening_date", "bank_name", "version", "currency", "owner_id", "status", "closed_at"}
+	)
+
+	accountRow := func(status string) *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, status, nil)
+	}
+
+	fixture, err := NewBankAccountRepoFixture(t)
+	require.NoError(t, err)
+	mock := *fixture.mockSqlDb
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockQuery).WithArgs(account.ID).WillReturnRows(accountRow(model.StatusActive))
+	mock.ExpectQuery(`UPDATE bank_account SET status = \$1, version = version \+ 1 WHERE id = \$2`).
+		WithArgs(model.StatusFrozen, account.ID).
+		WillReturnRows(accountRow(model.StatusFrozen))
+	mock.ExpectQuery(`FROM subscription s`).
+		WithArgs(pq.StringArray{account.ID.String()}).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "subscription_name", "price", "start_date", "end_date", "account_id", "status", "currency"}))
+	mock.ExpectExec(`INSERT INTO outbox`).
+		WithArgs("account", account.ID, "account.frozen", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	frozen, err := fixture.repo.FreezeBankAccount(ctx, account.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusFrozen, frozen.Status)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockQuery).WithArgs(account.ID).WillReturnRows(accountRow(model.StatusFrozen))
+	mock.ExpectRollback()
+
+	_, err = fixture.repo.FreezeBankAccount(ctx, account.ID)
+	assert.Equal(t, apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s is frozen", account.ID)), err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListBankAccountsRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx        = context.Background()
+		account    = fixtures.NewBankAccountBuilder().Valid().Build()
+		minBalance = int64(100)
+		afterID, _ = uuid.Parse("331684ab-5af8-439a-8a4f-62a571013283")
+		columns    = []string{"id", "holder_name", "balance", "opening_date", "bank_name", "version", "currency", "owner_id", "status", "closed_at"}
+		subColumns = []string{"id", "subscription_name", "price", "start_date", "end_date", "account_id"}
+	)
+
+	tests := []struct {
+		name    string
+		filter  model.BankAccountFilter
+		mockSQL func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:   "Without filters",
+			filter: model.BankAccountFilter{Limit: 11},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`^SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account WHERE status <> \$1 ORDER BY opening_date ASC, id ASC LIMIT \$2$`).
+					WithArgs(model.StatusClosed, 11).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, account.Status, nil))
+				mock.ExpectQuery(`FROM subscription s`).
+					WithArgs(pq.StringArray{account.ID.String()}).
+					WillReturnRows(sqlmock.NewRows(subColumns))
+			},
+		},
+		{
+			name: "With filters and page token",
+			filter: model.BankAccountFilter{
+				BankName:         "Sberbank",
+				Currency:         "RUB",
+				OwnerID:          "holder-1",
+				HolderNamePrefix: "Di_",
+				MinBalance:       &minBalance,
+				SortBy:           model.SortByBalance,
+				Descending:       true,
+				After:            &model.BankAccountCursor{SortBy: model.SortByBalance, Descending: true, Value: "500", ID: afterID},
+				Limit:            3,
+			},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`^SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account `+
+					`WHERE bank_name = \$1 AND currency = \$2 AND owner_id = \$3 AND status <> \$4 AND holder_name LIKE \$5 ESCAPE '\\' AND balance >= \$6 AND \(balance, id\) < \(\$7, \$8\) `+
+					`ORDER BY balance DESC, id DESC LIMIT \$9$`).
+					WithArgs("Sberbank", "RUB", "holder-1", model.StatusClosed, `Di\_%`, int64(100), int64(500), afterID, 3).
+					WillReturnRows(sqlmock.NewRows(columns))
+			},
+		},
+		{
+			name:   "Subscriptions of the page are loaded at once",
+			filter: model.BankAccountFilter{Limit: 11},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`^SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account WHERE status <> \$1 ORDER BY opening_date ASC, id ASC LIMIT \$2$`).
+					WithArgs(model.StatusClosed, 11).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, account.Status, nil).
+						AddRow(afterID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, account.Status, nil))
+				mock.ExpectQuery(`FROM subscription s\s+WHERE s.account_id = ANY\(\$1::uuid\[\]\)`).
+					WithArgs(pq.StringArray{account.ID.String(), afterID.String()}).
+					WillReturnRows(sqlmock.NewRows(subColumns))
+			},
+		},
+		{
+			name:   "Mask without subscriptions",
+			filter: model.BankAccountFilter{Limit: 11, Mask: model.ReadMask{"id": {}, "balance": {}}},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`^SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account WHERE status <> \$1 ORDER BY opening_date ASC, id ASC LIMIT \$2$`).
+					WithArgs(model.StatusClosed, 11).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, account.Status, nil))
+			},
+		},
+		{
+			name:   "Including closed accounts",
+			filter: model.BankAccountFilter{IncludeClosed: true, Limit: 11},
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`^SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account ORDER BY opening_date ASC, id ASC LIMIT \$1$`).
+					WithArgs(11).
+					WillReturnRows(sqlmock.NewRows(columns))
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fixture, err := NewBankAccountRepoFixture(t)
+			if err != nil {
+				t.Fatalf("Error setting up test fixture: %v", err)
+			}
+
+			tc.mockSQL(*fixture.mockSqlDb)
+
+			_, err = fixture.repo.ListBankAccounts(ctx, &tc.filter)
+			require.NoError(t, err)
+
+			err = (*fixture.mockSqlDb).ExpectationsWereMet()
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGetBankAccountByIDRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx        = context.Background()
+		account    = fixtures.NewBankAccountBuilder().Valid().Build()
+		endDate    = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		subID      = uuid.New()
+		columns    = []string{"id", "holder_name", "balance", "opening_date", "bank_name", "version", "currency", "owner_id", "status", "closed_at"}
+		subColumns = []string{"id", "subscription_name", "price", "start_date", "end_date", "account_id", "status", "currency"}
+	)
+	accountRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, account.Status, nil)
+	}
+
+	fixture, err := NewBankAccountRepoFixture(t)
+	require.NoError(t, err)
+	mock := *fixture.mockSqlDb
+
+	mock.ExpectQuery(`^SELECT id, .* FROM bank_account WHERE id = \$1$`).WithArgs(account.ID).WillReturnRows(accountRow())
+	mock.ExpectQuery(`FROM subscription s\s+WHERE s.account_id = ANY\(\$1::uuid\[\]\)`).
+		WithArgs(pq.StringArray{account.ID.String()}).
+		WillReturnRows(sqlmock.NewRows(subColumns).AddRow(subID, "Music", 100, time.Time{}, endDate, account.ID, "active", "RUB"))
+
+	got, err := fixture.repo.GetBankAccountByID(ctx, account.ID, true)
+	require.NoError(t, err)
+	require.Len(t, got.Subscriptions, 1)
+	assert.Equal(t, endDate, got.Subscriptions[0].EndDate)
+
+	mock.ExpectQuery(`^SELECT id, .* FROM bank_account WHERE id = \$1$`).WithArgs(account.ID).WillReturnRows(accountRow())
+
+	got, err = fixture.repo.GetBankAccountByID(ctx, account.ID, false)
+	require.NoError(t, err)
+	assert.Nil(t, got.Subscriptions, "subscriptions are not queried")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetBankAccountByIDRepo_ContextCancelled(t *testing.T) {
+	t.Parallel()
+
+	fixture, err := NewBankAccountRepoFixture(t)
+	if err != nil {
+		t.Fatalf("Error setting up test fixture: %v", err)
+	}
+
+	id := uuid.New()
+	(*fixture.mockSqlDb).ExpectQuery(`SELECT id, .* FROM bank_account WHERE id = \$1`).
+		WithArgs(id).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = fixture.repo.GetBankAccountByID(ctx, id, true)
+	assert.Equal(t, apperr.NewDeadlineExceededError("Request deadline exceeded"), err)
+	assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+}
+
+func TestImportBankAccountsRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx          = context.Background()
+		existingID   = uuid.New()
+		newAccount   = fixtures.NewBankAccountBuilder().Valid().ID(uuid.New()).Balance(500).Build()
+		emptyAccount = fixtures.NewBankAccountBuilder().Valid().ID(uuid.New()).Balance(0).Build()
+		existing     = fixtures.NewBankAccountBuilder().Valid().ID(existingID).Build()
+	)
+
+	fixture, err := NewBankAccountRepoFixture(t)
+	require.NoError(t, err)
+	mock := *fixture.mockSqlDb
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM bank_account WHERE id = ANY\(\$1::uuid\[\]\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(existingID))
+	copyAccounts := mock.ExpectPrepare(`COPY "bank_account" \("id", "holder_name", "balance", "opening_date", "bank_name", "currency", "version", "owner_id"\) FROM STDIN`)
+	copyAccounts.ExpectExec().
+		WithArgs(newAccount.ID, newAccount.HolderName, newAccount.Balance, newAccount.OpeningDate, newAccount.BankName, newAccount.Currency, newAccount.Version, newAccount.OwnerID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	copyAccounts.ExpectExec().WithArgs(emptyAccount.ID, sqlmock.AnyArg(), int64(0), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	copyAccounts.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	copyLedger := mock.ExpectPrepare(`COPY "ledger_entry"`)
+	copyLedger.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	copyLedger.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	copyLedger.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	copyOutbox := mock.ExpectPrepare(`COPY "outbox"`)
+	copyOutbox.ExpectExec().WithArgs("account", newAccount.ID, "account.created", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	copyOutbox.ExpectExec().WithArgs("account", emptyAccount.ID, "account.created", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	copyOutbox.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	conflicts, err := fixture.repo.ImportBankAccounts(ctx, []model.BankAccount{*newAccount, *existing, *emptyAccount})
+
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{existingID}, conflicts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportBankAccountsRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx        = context.Background()
+		first      = fixtures.NewBankAccountBuilder().Valid().ID(uuid.MustParse("10000000-0000-0000-0000-000000000000")).Build()
+		second     = fixtures.NewBankAccountBuilder().Valid().ID(uuid.MustParse("20000000-0000-0000-0000-000000000000")).Build()
+		columns    = []string{"id", "holder_name", "balance", "opening_date", "bank_name", "version", "currency", "owner_id", "status", "closed_at"}
+		subColumns = []string{"id", "subscription_name", "price", "start_date", "end_date", "account_id", "status", "currency"}
+		pageQuery  = `SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account WHERE id > \$1 ORDER BY id LIMIT \$2`
+		subID      = uuid.New()
+	)
+
+	accountRow := func(rows *sqlmock.Rows, account *model.BankAccount) *sqlmock.Rows {
+		return rows.AddRow(account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, account.Status, nil)
+	}
+
+	fixture, err := NewBankAccountRepoFixture(t)
+	require.NoError(t, err)
+	mock := *fixture.mockSqlDb
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(pageQuery).WithArgs(uuid.Nil, 2).
+		WillReturnRows(accountRow(accountRow(sqlmock.NewRows(columns), first), second))
+	mock.ExpectQuery(`FROM subscription s\s+WHERE s.account_id = ANY\(\$1::uuid\[\]\)`).
+		WillReturnRows(sqlmock.NewRows(subColumns).AddRow(subID, "Music", 100, time.Time{}, nil, second.ID, "active", "RUB"))
+	mock.ExpectQuery(pageQuery).WithArgs(second.ID, 2).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectCommit()
+
+	var pages [][]model.BankAccount
+	err = fixture.repo.ExportBankAccounts(ctx, 2, func(accounts []model.BankAccount) error {
+		pages = append(pages, accounts)
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Len(t, pages, 1)
+	require.Len(t, pages[0], 2)
+	assert.Empty(t, pages[0][0].Subscriptions)
+	require.Len(t, pages[0][1].Subscriptions, 1)
+	assert.Equal(t, subID, pages[0][1].Subscriptions[0].ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportBankAccountsRepo_RowsError(t *testing.T) {
+	t.Parallel()
+
+	var (
+		account   = fixtures.NewBankAccountBuilder().Valid().Build()
+		columns   = []string{"id", "holder_name", "balance", "opening_date", "bank_name", "version", "currency", "owner_id", "status", "closed_at"}
+		pageQuery = `SELECT id, holder_name, balance, opening_date, bank_name, version, currency, owner_id, status, closed_at FROM bank_account WHERE id > \$1 ORDER BY id LIMIT \$2`
+	)
+
+	fixture, err := NewBankAccountRepoFixture(t)
+	require.NoError(t, err)
+	mock := *fixture.mockSqlDb
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(pageQuery).WithArgs(uuid.Nil, 2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, account.Status, nil).
+			AddRow(uuid.New(), account.HolderName, account.Balance, account.OpeningDate, account.BankName, account.Version, account.Currency, account.OwnerID, account.Status, nil).
+			RowError(1, errors.New("connection reset")))
+	mock.ExpectRollback()
+
+	called := false
+	err = fixture.repo.ExportBankAccounts(context.Background(), 2, func(accounts []model.BankAccount) error {
+		called = true
+		return nil
+	})
+
+	assert.Equal(t, apperr.NewInternalServerError("Internal server error"), err)
+	assert.False(t, called, "a page cut short by an error is not exported")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAccountOwnersRepo(t *testing.T) {
+	t.Parallel()
+
+	fixture, err := NewBankAccountRepoFixture(t)
+	if err != nil {
+		t.Fatalf("Error setting up test fixture: %v", err)
+	}
+	repo := fixture.repo.(*BankAccountRepository)
+	mock := *fixture.mockSqlDb
+
+	var (
+		ctx       = context.Background()
+		accountID = uuid.New()
+		subID     = uuid.New()
+		missingID = uuid.New()
+	)
+	mock.ExpectQuery(`^SELECT owner_id FROM bank_account WHERE id = \$1$`).
+		WithArgs(accountID).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow("dima"))
+	mock.ExpectQuery(`^SELECT a.owner_id FROM subscription s JOIN bank_account a ON a.id = s.account_id WHERE s.id = \$1$`).
+		WithArgs(subID).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow("dima"))
+	mock.ExpectQuery(`^SELECT owner_id FROM bank_account WHERE id = \$1$`).
+		WithArgs(missingID).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}))
+
+	owner, err := repo.GetBankAccountOwner(ctx, accountID)
+	require.NoError(t, err)
+	assert.Equal(t, "dima", owner)
+
+	owner, err = repo.GetSubscriptionOwner(ctx, subID)
+	require.NoError(t, err)
+	assert.Equal(t, "dima", owner)
+
+	_, err = repo.GetBankAccountOwner(ctx, missingID)
+	assert.Equal(t, apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %s not found", missingID)), err)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
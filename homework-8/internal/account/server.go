@@ -2,12 +2,16 @@ package account
 
 import (
 	"context"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
 	logg "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
 	"go.uber.org/zap"
+	"io"
 )
 
 type BankAccountGrpcImpl struct {
@@ -25,17 +29,12 @@ func (b BankAccountGrpcImpl) CreateBankAccount(ctx context.Context, request *ban
 	span, ctx := opentracing.StartSpanFromContext(ctx, "CreateBankAccount")
 	defer span.Finish()
 
-	logger := logg.FromContext(ctx)
-	logger.With(
-		zap.String("method", "create bank account"),
-		zap.Any("request", request),
-	)
-	ctx = logg.ToContext(ctx, logger)
+	ctx = logg.With(ctx, zap.Stringer("request", request))
 
 	accountRequest, err := model.MapFromDto(request.GetAccount())
 	if err != nil {
 		logg.Errorf(ctx, err.Error())
-		return nil, err
+		return nil, apperr.NewBadRequestError(err.Error())
 	}
 
 	bankAccount, err := b.service.CreateBankAccount(ctx, accountRequest)
@@ -54,27 +53,60 @@ func (b BankAccountGrpcImpl) GetBankAccountById(ctx context.Context, request *ba
 	span, ctx := opentracing.StartSpanFromContext(ctx, "GetBankAccountById")
 	defer span.Finish()
 
-	logger := logg.FromContext(ctx)
-	logger.With(
-		zap.String("method", "get bank account by id"),
-		zap.Any("request", request),
-	)
-	ctx = logg.ToContext(ctx, logger)
+	ctx = logg.With(ctx, zap.Stringer("request", request))
 
 	id, err := uuid.Parse(request.GetId().GetValue())
 	if err != nil {
 		logg.Errorf(ctx, err.Error())
-		return nil, err
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	mask, err := model.MapReadMask(request.GetReadMask())
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, apperr.NewBadRequestError(err.Error())
 	}
 
-	account, err := b.service.GetBankAccountById(ctx, id)
+	account, err := b.service.GetBankAccountById(ctx, id, model.ReadOptions{IncludeClosed: request.GetIncludeClosed(), Mask: mask})
 	if err != nil {
 		logg.Errorf(ctx, err.Error())
 		return nil, err
 	}
 
+	dto := account.MapToDto()
+	mask.Apply(dto)
 	return &bank_accounts.GetBankAccountResponse{
-		Account: account.MapToDto(),
+		Account: dto,
+	}, nil
+}
+
+func (b BankAccountGrpcImpl) ListBankAccounts(ctx context.Context, request *bank_accounts.ListBankAccountsRequest) (*bank_accounts.ListBankAccountsResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ListBankAccounts")
+	defer span.Finish()
+
+	ctx = logg.With(ctx, zap.Stringer("request", request))
+
+	filter, err := model.MapBankAccountFilterFromRequest(request)
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	accounts, nextPageToken, err := b.service.ListBankAccounts(ctx, filter)
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, err
+	}
+
+	dtos := make([]*bank_accounts.BankAccountDto, len(accounts))
+	for i, account := range accounts {
+		dtos[i] = account.MapToDto()
+		filter.Mask.Apply(dtos[i])
+	}
+
+	return &bank_accounts.ListBankAccountsResponse{
+		Accounts:      dtos,
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
@@ -82,25 +114,35 @@ func (b BankAccountGrpcImpl) UpdateBankAccount(ctx context.Context, request *ban
 	span, ctx := opentracing.StartSpanFromContext(ctx, "UpdateBankAccount")
 	defer span.Finish()
 
-	logger := logg.FromContext(ctx)
-	logger.With(
-		zap.String("method", "update bank account"),
-		zap.Any("request", request),
-	)
-	ctx = logg.ToContext(ctx, logger)
+	ctx = logg.With(ctx, zap.Stringer("request", request))
 
 	id, err := uuid.Parse(request.GetId().GetValue())
 	if err != nil {
 		logg.Errorf(ctx, err.Error())
-		return nil, err
+		return nil, apperr.NewBadRequestError(err.Error())
 	}
-	accountRequest, err := model.MapFromDto(request.GetAccount())
+	mask, err := model.MapUpdateMask(request.GetUpdateMask())
 	if err != nil {
 		logg.Errorf(ctx, err.Error())
-		return nil, err
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+	// a full replacement carries the id, it must be the one being updated
+	if value := request.GetAccount().GetId().GetValue(); value != "" {
+		if accountID, err := uuid.Parse(value); err != nil || accountID != id {
+			logg.Errorf(ctx, "id of the account %q does not match %s", value, id)
+			return nil, apperr.NewBadRequestError(`field "id" cannot be updated`)
+		}
+	}
+	accountRequest := model.MapUpdateFromDto(request.GetAccount())
+	if accountRequest.Version == 0 {
+		accountRequest.Version, err = app.VersionFromIfMatch(ctx)
+		if err != nil {
+			logg.Errorf(ctx, err.Error())
+			return nil, apperr.NewBadRequestError(err.Error())
+		}
 	}
 
-	updatedAccount, err := b.service.UpdateBankAccount(ctx, id, accountRequest)
+	updatedAccount, err := b.service.UpdateBankAccount(ctx, id, accountRequest, mask)
 	if err != nil {
 		logg.Errorf(ctx, err.Error())
 		return nil, err
@@ -115,17 +157,12 @@ func (b BankAccountGrpcImpl) DeleteBankAccount(ctx context.Context, request *ban
 	span, ctx := opentracing.StartSpanFromContext(ctx, "DeleteBankAccount")
 	defer span.Finish()
 
-	logger := logg.FromContext(ctx)
-	logger.With(
-		zap.String("method", "delete bank account"),
-		zap.Any("request", request),
-	)
-	ctx = logg.ToContext(ctx, logger)
+	ctx = logg.With(ctx, zap.Stringer("request", request))
 
 	id, err := uuid.Parse(request.GetId().GetValue())
 	if err != nil {
 		logg.Errorf(ctx, err.Error())
-		return nil, err
+		return nil, apperr.NewBadRequestError(err.Error())
 	}
 
 	deletedAccount, err := b.service.DeleteBankAccount(ctx, id)
@@ -138,3 +175,210 @@ func (b BankAccountGrpcImpl) DeleteBankAccount(ctx context.Context, request *ban
 		Account: deletedAccount.MapToDto(),
 	}, nil
 }
+
+func (b BankAccountGrpcImpl) CloseBankAccount(ctx context.Context, request *bank_accounts.CloseBankAccountRequest) (*bank_accounts.CloseBankAccountResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "CloseBankAccount")
+	defer span.Finish()
+
+	ctx = logg.With(ctx, zap.Stringer("request", request))
+
+	id, err := uuid.Parse(request.GetId().GetValue())
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	closedAccount, err := b.service.CloseBankAccount(ctx, id)
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, err
+	}
+
+	return &bank_accounts.CloseBankAccountResponse{
+		Account: closedAccount.MapToDto(),
+	}, nil
+}
+
+func (b BankAccountGrpcImpl) FreezeBankAccount(ctx context.Context, request *bank_accounts.FreezeBankAccountRequest) (*bank_accounts.FreezeBankAccountResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "FreezeBankAccount")
+	defer span.Finish()
+
+	ctx = logg.With(ctx, zap.Stringer("request", request))
+
+	id, err := uuid.Parse(request.GetId().GetValue())
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	frozenAccount, err := b.service.FreezeBankAccount(ctx, id)
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, err
+	}
+
+	return &bank_accounts.FreezeBankAccountResponse{
+		Account: frozenAccount.MapToDto(),
+	}, nil
+}
+
+func (b BankAccountGrpcImpl) UnfreezeBankAccount(ctx context.Context, request *bank_accounts.UnfreezeBankAccountRequest) (*bank_accounts.UnfreezeBankAccountResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UnfreezeBankAccount")
+	defer span.Finish()
+
+	ctx = logg.With(ctx, zap.Stringer("request", request))
+
+	id, err := uuid.Parse(request.GetId().GetValue())
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	unfrozenAccount, err := b.service.UnfreezeBankAccount(ctx, id)
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, err
+	}
+
+	return &bank_accounts.UnfreezeBankAccountResponse{
+		Account: unfrozenAccount.MapToDto(),
+	}, nil
+}
+
+func (b BankAccountGrpcImpl) Transfer(ctx context.Context, request *bank_accounts.TransferRequest) (*bank_accounts.TransferResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Transfer")
+	defer span.Finish()
+
+	ctx = logg.With(ctx, zap.Stringer("request", request))
+
+	transferRequest, err := model.MapTransferFromRequest(request)
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	from, to, err := b.service.Transfer(ctx, transferRequest)
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, err
+	}
+
+	return &bank_accounts.TransferResponse{
+		FromAccount: from.MapToDto(),
+		ToAccount:   to.MapToDto(),
+	}, nil
+}
+
+func (b BankAccountGrpcImpl) ListAccountTransactions(ctx context.Context, request *bank_accounts.ListAccountTransactionsRequest) (*bank_accounts.ListAccountTransactionsResponse, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ListAccountTransactions")
+	defer span.Finish()
+
+	ctx = logg.With(ctx, zap.Stringer("request", request))
+
+	filter, err := model.MapLedgerFilterFromRequest(request)
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	entries, nextPageToken, err := b.service.ListAccountTransactions(ctx, filter)
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return nil, err
+	}
+
+	return &bank_accounts.ListAccountTransactionsResponse{
+		Entries:       model.MapLedgerEntriesToDto(entries),
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+func (b BankAccountGrpcImpl) WatchBankAccount(request *bank_accounts.WatchBankAccountRequest, stream bank_accounts.BankAccountService_WatchBankAccountServer) error {
+	span, ctx := opentracing.StartSpanFromContext(stream.Context(), "WatchBankAccount")
+	defer span.Finish()
+
+	ctx = logg.With(ctx, zap.Stringer("request", request))
+
+	id, err := uuid.Parse(request.GetId().GetValue())
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return apperr.NewBadRequestError(err.Error())
+	}
+
+	err = b.service.WatchBankAccount(ctx, id, func(event model.WatchEvent) error {
+		return stream.Send(event.MapToDto())
+	})
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (b BankAccountGrpcImpl) ImportBankAccounts(stream bank_accounts.BankAccountService_ImportBankAccountsServer) error {
+	span, ctx := opentracing.StartSpanFromContext(stream.Context(), "ImportBankAccounts")
+	defer span.Finish()
+
+	var (
+		result model.ImportResult
+		batch  []model.ImportRow
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		batchResult, err := b.service.ImportBankAccounts(ctx, batch)
+		if err != nil {
+			return err
+		}
+		result.Add(batchResult)
+		batch = batch[:0]
+		return nil
+	}
+
+	for index := 0; ; index++ {
+		request, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		account, err := model.MapFromDto(request.GetAccount())
+		if err != nil {
+			result.Failures = append(result.Failures, model.NewImportFailure(index, apperr.NewBadRequestError(err.Error())))
+			continue
+		}
+		batch = append(batch, model.ImportRow{Index: index, Account: account})
+		if len(batch) == model.ImportBatchSize {
+			if err = flush(); err != nil {
+				logg.Errorf(ctx, err.Error())
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		logg.Errorf(ctx, err.Error())
+		return err
+	}
+
+	result.Sort()
+	return stream.SendAndClose(result.MapToDto())
+}
+
+func (b BankAccountGrpcImpl) ExportBankAccounts(request *bank_accounts.ExportBankAccountsRequest, stream bank_accounts.BankAccountService_ExportBankAccountsServer) error {
+	span, ctx := opentracing.StartSpanFromContext(stream.Context(), "ExportBankAccounts")
+	defer span.Finish()
+
+	err := b.service.ExportBankAccounts(ctx, func(account *model.BankAccount) error {
+		return stream.Send(&bank_accounts.ExportBankAccountsResponse{Account: account.MapToDto()})
+	})
+	if err != nil {
+		logg.Errorf(ctx, err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	mock_account "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/mocks"
-	app_mock "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/mocks"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/tests/fixtures"
@@ -21,19 +21,16 @@ type bankAccountControllerFixture struct {
 	ctrl        *gomock.Controller
 	controller  bank_accounts.BankAccountServiceServer
 	mockService *mock_account.MockService
-	mockLogger  *app_mock.MockLogger
 }
 
 func NewBankAccountControllerFixture(t *testing.T) *bankAccountControllerFixture {
 	ctrl := gomock.NewController(t)
 	mockService := mock_account.NewMockService(ctrl)
-	mockLogger := app_mock.NewMockLogger(ctrl)
 	controller := NewBankAccountGrpcImpl(mockService)
 	return &bankAccountControllerFixture{
 		ctrl:        ctrl,
 		controller:  controller,
 		mockService: mockService,
-		mockLogger:  mockLogger,
 	}
 }
 
@@ -43,7 +40,7 @@ func TestCreateBankAccount(t *testing.T) {
 	var (
 		ctx                   = context.Background()
 		bankAccountDto        = fixtures.NewBankAccountDtoBuilder().Valid().Build()
-		bankAccount           = fixtures.NewBankAccountBuilder().Valid().Build()
+		bankAccount, _        = model.MapFromDto(bankAccountDto)
 		createdBankAccount    = fixtures.NewBankAccountBuilder().Valid().Build()
 		createdBankAccountDto = fixtures.NewBankAccountDtoBuilder().Valid().Build()
 		invalidBankAccountDto = fixtures.NewBankAccountDtoBuilder().Valid().ID("invalid id").Build()
@@ -51,27 +48,23 @@ func TestCreateBankAccount(t *testing.T) {
 
 	tests := []struct {
 		name            string
-		requestPayload  bank_accounts.BankAccountDto
+		requestPayload  *bank_accounts.BankAccountDto
 		mockService     func(service *mock_account.MockService)
-		mockLogger      func(logger *app_mock.MockLogger)
 		expectedError   error
 		expectedAccount *bank_accounts.BankAccountDto
 	}{
 		{
 			name:           "Valid Request",
-			requestPayload: *bankAccountDto,
+			requestPayload: bankAccountDto,
 			mockService: func(service *mock_account.MockService) {
-				service.EXPECT().CreateBankAccount(ctx, bankAccount).Return(createdBankAccount, nil)
-			},
-			mockLogger: func(logger *app_mock.MockLogger) {
-				logger.EXPECT().Log(gomock.Any())
+				service.EXPECT().CreateBankAccount(gomock.Any(), bankAccount).Return(createdBankAccount, nil)
 			},
 			expectedError:   nil,
 			expectedAccount: createdBankAccountDto,
 		},
 		{
 			name:            "Fail, invalid ID",
-			requestPayload:  *invalidBankAccountDto,
+			requestPayload:  invalidBankAccountDto,
 			expectedError:   errors.New("invalid id"),
 			expectedAccount: nil,
 		},
@@ -88,7 +81,7 @@ func TestCreateBankAccount(t *testing.T) {
 			}
 
 			createBankAccountRequest := &bank_accounts.CreateBankAccountRequest{
-				Account: &tc.requestPayload,
+				Account: tc.requestPayload,
 			}
 			result, err := fixture.controller.CreateBankAccount(ctx, createBankAccountRequest)
 			if tc.expectedError != nil {
@@ -116,7 +109,6 @@ func TestGetBankAccount(t *testing.T) {
 		name             string
 		requestAccountId string
 		mockService      func(service *mock_account.MockService)
-		mockLogger       func(logger *app_mock.MockLogger)
 		expectedError    error
 		expectedResult   *bank_accounts.BankAccountDto
 	}{
@@ -124,10 +116,7 @@ func TestGetBankAccount(t *testing.T) {
 			name:             "Valid Request",
 			requestAccountId: bankAccount.ID.String(),
 			mockService: func(service *mock_account.MockService) {
-				service.EXPECT().GetBankAccountById(ctx, bankAccount.ID).Return(bankAccount, nil)
-			},
-			mockLogger: func(logger *app_mock.MockLogger) {
-				logger.EXPECT().Log(gomock.Any())
+				service.EXPECT().GetBankAccountById(gomock.Any(), bankAccount.ID, model.ReadOptions{}).Return(bankAccount, nil)
 			},
 			expectedError:  nil,
 			expectedResult: expectedBankAccountDto,
@@ -136,13 +125,9 @@ func TestGetBankAccount(t *testing.T) {
 			name:             "Not Found Request",
 			requestAccountId: notFoundId.String(),
 			mockService: func(service *mock_account.MockService) {
-				service.EXPECT().GetBankAccountById(ctx, notFoundId).Return(
+				service.EXPECT().GetBankAccountById(gomock.Any(), notFoundId, model.ReadOptions{}).Return(
 					nil, apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %d not found", notFoundId)))
 			},
-			mockLogger: func(logger *app_mock.MockLogger) {
-				logger.EXPECT().Log(gomock.Any())
-				logger.EXPECT().Error(gomock.Any())
-			},
 			expectedError:  apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %d not found", notFoundId)),
 			expectedResult: &bank_accounts.BankAccountDto{},
 		},
@@ -4,69 +4,250 @@ package account
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/watch"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money"
+	"sort"
 	"time"
 )
 
 type Service interface {
 	CreateBankAccount(ctx context.Context, account *model.BankAccount) (*model.BankAccount, error)
-	GetBankAccountById(ctx context.Context, id uuid.UUID) (*model.BankAccount, error)
-	UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount) (*model.BankAccount, error)
+	// GetBankAccountById does not find closed accounts unless the options
+	// include them. Subscriptions are only loaded if the mask has them.
+	GetBankAccountById(ctx context.Context, id uuid.UUID, options model.ReadOptions) (*model.BankAccount, error)
+	ListBankAccounts(ctx context.Context, filter *model.BankAccountFilter) ([]model.BankAccount, string, error)
+	// UpdateBankAccount changes the fields of the mask and validates only them.
+	UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount, mask model.UpdateMask) (*model.BankAccount, error)
 	DeleteBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error)
+	CloseBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error)
+	FreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error)
+	UnfreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error)
+	Transfer(ctx context.Context, transfer *model.Transfer) (*model.BankAccount, *model.BankAccount, error)
+	ListAccountTransactions(ctx context.Context, filter *model.LedgerFilter) ([]model.LedgerEntry, string, error)
+	WatchBankAccount(ctx context.Context, id uuid.UUID, send func(event model.WatchEvent) error) error
+	ImportBankAccounts(ctx context.Context, rows []model.ImportRow) (*model.ImportResult, error)
+	ExportBankAccounts(ctx context.Context, send func(account *model.BankAccount) error) error
 }
 
 type BankAccountService struct {
 	repository Repository
+	rates      money.RateProvider
+	bus        *watch.Bus
 }
 
-func NewBankAccountService(repository Repository) *BankAccountService {
-	return &BankAccountService{repository: repository}
+func NewBankAccountService(repository Repository, rates money.RateProvider, bus *watch.Bus) *BankAccountService {
+	return &BankAccountService{
+		repository: repository,
+		rates:      rates,
+		bus:        bus,
+	}
 }
 
 func (b *BankAccountService) CreateBankAccount(ctx context.Context, account *model.BankAccount) (*model.BankAccount, error) {
+	if err := prepareNewAccount(account); err != nil {
+		return nil, err
+	}
+	assignOwner(ctx, account)
+
+	bankAccount, err := b.repository.CreateBankAccount(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return bankAccount, nil
+}
+
+// assignOwner makes the caller the owner of a new account. Holders open
+// accounts for themselves, admins may open them for anyone.
+func assignOwner(ctx context.Context, account *model.BankAccount) {
+	if principal, ok := app.PrincipalFromContext(ctx); ok && (account.OwnerID == "" || !principal.IsAdmin()) {
+		account.OwnerID = principal.Subject
+	}
+}
+
+// prepareNewAccount validates an account to be created and fills the fields
+// set by the service.
+func prepareNewAccount(account *model.BankAccount) error {
+	if account.Currency == "" {
+		account.Currency = money.DefaultCurrency
+	}
 	if err := account.Validate(); err != nil {
-		return nil, apperr.NewBadRequestError(err.Error())
+		return apperr.NewValidationError(err)
 	}
 
 	if account.ID == uuid.Nil {
 		account.ID = uuid.New()
 	}
 
+	account.OpeningDate = time.Now()
+	account.Version = model.InitialVersion
+	account.Status = model.StatusActive
+	account.ClosedAt = time.Time{}
+
 	for i := range account.Subscriptions {
 		account.Subscriptions[i].AccountID = account.ID
+		account.Subscriptions[i].StartDate = account.OpeningDate
+		if account.Subscriptions[i].Currency == "" {
+			account.Subscriptions[i].Currency = account.Currency
+		}
 		if account.Subscriptions[i].ID == uuid.Nil {
 			account.Subscriptions[i].ID = uuid.New()
 		}
 	}
 
-	account.OpeningDate = time.Now()
+	return nil
+}
 
-	bankAccount, err := b.repository.CreateBankAccount(ctx, account)
+// ImportBankAccounts creates one batch of an import. Invalid accounts, repeated
+// IDs and IDs that are already taken are reported as failures; the rest of the
+// batch is created.
+func (b *BankAccountService) ImportBankAccounts(ctx context.Context, rows []model.ImportRow) (*model.ImportResult, error) {
+	var (
+		result   model.ImportResult
+		accounts []model.BankAccount
+		indexes  = make(map[uuid.UUID]int, len(rows))
+	)
+	for _, row := range rows {
+		if err := prepareNewAccount(row.Account); err != nil {
+			result.Failures = append(result.Failures, model.NewImportFailure(row.Index, err))
+			continue
+		}
+		assignOwner(ctx, row.Account)
+		if _, ok := indexes[row.Account.ID]; ok {
+			err := apperr.NewConflictError(fmt.Sprintf("Bank account with ID: %s is repeated in the import", row.Account.ID))
+			result.Failures = append(result.Failures, model.NewImportFailure(row.Index, err))
+			continue
+		}
+		indexes[row.Account.ID] = row.Index
+		accounts = append(accounts, *row.Account)
+	}
+
+	if len(accounts) > 0 {
+		conflicts, err := b.repository.ImportBankAccounts(ctx, accounts)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range conflicts {
+			err := apperr.NewConflictError(fmt.Sprintf("Bank account with ID: %s already exists", id))
+			result.Failures = append(result.Failures, model.NewImportFailure(indexes[id], err))
+		}
+		result.Imported = len(accounts) - len(conflicts)
+	}
+
+	sort.Slice(result.Failures, func(i, j int) bool {
+		return result.Failures[i].Index < result.Failures[j].Index
+	})
+	return &result, nil
+}
+
+func (b *BankAccountService) ExportBankAccounts(ctx context.Context, send func(account *model.BankAccount) error) error {
+	return b.repository.ExportBankAccounts(ctx, model.ExportPageSize, func(accounts []model.BankAccount) error {
+		for i := range accounts {
+			if err := send(&accounts[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BankAccountService) GetBankAccountById(ctx context.Context, id uuid.UUID, options model.ReadOptions) (*model.BankAccount, error) {
+	var (
+		bankAccount *model.BankAccount
+		err         error
+	)
+	if options.ForUpdate {
+		bankAccount, err = b.repository.LockBankAccount(ctx, id)
+	} else {
+		bankAccount, err = b.repository.GetBankAccountByID(ctx, id, options.Mask.Has(model.FieldSubscriptions))
+	}
 	if err != nil {
 		return nil, err
 	}
-
+	if bankAccount.IsClosed() && !options.IncludeClosed {
+		return nil, apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %s is closed", id))
+	}
 	return bankAccount, nil
 }
 
-func (b *BankAccountService) GetBankAccountById(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
-	bankAccount, err := b.repository.GetBankAccountByID(ctx, id)
+func (b *BankAccountService) ListBankAccounts(ctx context.Context, filter *model.BankAccountFilter) ([]model.BankAccount, string, error) {
+	if filter.Limit < 0 || filter.Limit > model.MaxPageSize {
+		return nil, "", apperr.NewBadRequestError(fmt.Sprintf("page size must be between 0 and %d", model.MaxPageSize))
+	}
+	if filter.Limit == 0 {
+		filter.Limit = model.DefaultPageSize
+	}
+	if principal, ok := app.PrincipalFromContext(ctx); ok && !principal.IsAdmin() {
+		filter.OwnerID = principal.Subject
+	}
+	if filter.MinBalance != nil && filter.MaxBalance != nil && *filter.MinBalance > *filter.MaxBalance {
+		return nil, "", apperr.NewBadRequestError("min balance must not exceed max balance")
+	}
+	if !filter.OpenedAfter.IsZero() && !filter.OpenedBefore.IsZero() && !filter.OpenedAfter.Before(filter.OpenedBefore) {
+		return nil, "", apperr.NewBadRequestError("opened after must be before opened before")
+	}
+	if filter.After != nil && (filter.After.SortBy != filter.SortBy || filter.After.Descending != filter.Descending) {
+		return nil, "", apperr.NewBadRequestError("page token does not match the requested sort order")
+	}
+
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+	accounts, err := b.repository.ListBankAccounts(ctx, filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextPageToken string
+	if len(accounts) > pageSize {
+		accounts = accounts[:pageSize]
+		nextPageToken = model.NewBankAccountCursor(filter, accounts[pageSize-1]).Encode()
+	}
+
+	return accounts, nextPageToken, nil
+}
+
+// UpdateBankAccount lets only admins set the balance. Holders move money with
+// Transfer, otherwise they could credit themselves any amount.
+func (b *BankAccountService) UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount, mask model.UpdateMask) (*model.BankAccount, error) {
+	if principal, ok := app.PrincipalFromContext(ctx); ok && !principal.IsAdmin() && mask.Has("balance") {
+		return nil, apperr.NewForbiddenError(fmt.Sprintf("Balance can only be changed with the %s role", app.RoleAdmin))
+	}
+	if err := account.ValidateUpdate(mask); err != nil {
+		return nil, apperr.NewValidationError(err)
+	}
+	if account.Version <= 0 {
+		return nil, apperr.NewBadRequestError("Version of the bank account is required")
+	}
+	bankAccount, err := b.repository.UpdateBankAccount(ctx, id, account, mask)
 	if err != nil {
 		return nil, err
 	}
+
 	return bankAccount, nil
 }
 
-func (b *BankAccountService) UpdateBankAccount(ctx context.Context, id uuid.UUID, account *model.BankAccount) (*model.BankAccount, error) {
-	if err := account.Validate(); err != nil {
-		return nil, apperr.NewBadRequestError(err.Error())
+// DeleteBankAccount closes the account, accounts are never removed.
+func (b *BankAccountService) DeleteBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	return b.CloseBankAccount(ctx, id)
+}
+
+func (b *BankAccountService) CloseBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	bankAccount, err := b.repository.CloseBankAccount(ctx, id, time.Now())
+	if err != nil {
+		return nil, err
 	}
-	str := id.String()
-	fmt.Println(str)
-	bankAccount, err := b.repository.UpdateBankAccount(ctx, id, account)
+
+	return bankAccount, nil
+}
+
+func (b *BankAccountService) FreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	bankAccount, err := b.repository.FreezeBankAccount(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -74,11 +255,121 @@ func (b *BankAccountService) UpdateBankAccount(ctx context.Context, id uuid.UUID
 	return bankAccount, nil
 }
 
-func (b *BankAccountService) DeleteBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
-	bankAccount, err := b.repository.DeleteBankAccount(ctx, id)
+func (b *BankAccountService) UnfreezeBankAccount(ctx context.Context, id uuid.UUID) (*model.BankAccount, error) {
+	bankAccount, err := b.repository.UnfreezeBankAccount(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
 	return bankAccount, nil
 }
+
+func (b *BankAccountService) Transfer(ctx context.Context, transfer *model.Transfer) (*model.BankAccount, *model.BankAccount, error) {
+	if err := transfer.Validate(); err != nil {
+		return nil, nil, apperr.NewValidationError(err)
+	}
+
+	from, err := b.repository.GetBankAccountByID(ctx, transfer.FromID, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err := b.repository.GetBankAccountByID(ctx, transfer.ToID, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	transfer.ToAmount, err = money.Convert(ctx, b.rates, transfer.Amount, from.Currency, to.Currency)
+	if err != nil {
+		if errors.Is(err, money.ErrRateNotFound) {
+			return nil, nil, apperr.NewBadRequestError(fmt.Sprintf("No exchange rate from %s to %s", from.Currency, to.Currency))
+		}
+		return nil, nil, apperr.NewInternalServerError("Internal server error")
+	}
+	if transfer.ToAmount <= 0 {
+		return nil, nil, apperr.NewBadRequestError(fmt.Sprintf("Amount is too small to be converted to %s", to.Currency))
+	}
+
+	transfer.ID = uuid.New()
+	transfer.CreatedAt = time.Now()
+
+	from, to, err = b.repository.Transfer(ctx, transfer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return from, to, nil
+}
+
+func (b *BankAccountService) ListAccountTransactions(ctx context.Context, filter *model.LedgerFilter) ([]model.LedgerEntry, string, error) {
+	if filter.Limit < 0 || filter.Limit > model.MaxPageSize {
+		return nil, "", apperr.NewBadRequestError(fmt.Sprintf("page size must be between 0 and %d", model.MaxPageSize))
+	}
+	if filter.Limit == 0 {
+		filter.Limit = model.DefaultPageSize
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, "", apperr.NewBadRequestError("from must be before to")
+	}
+
+	if _, err := b.repository.GetBankAccountByID(ctx, filter.AccountID, false); err != nil {
+		return nil, "", err
+	}
+
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+	entries, err := b.repository.ListLedgerEntries(ctx, filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextPageToken string
+	if len(entries) > pageSize {
+		entries = entries[:pageSize]
+		last := entries[pageSize-1]
+		nextPageToken = model.LedgerCursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	return entries, nextPageToken, nil
+}
+
+// WatchBankAccount calls send with the current state of the account and then
+// after every change, until ctx is done or the account is closed or deleted. While send
+// blocks on a slow client, changes are merged and the client gets the latest
+// state afterwards.
+func (b *BankAccountService) WatchBankAccount(ctx context.Context, id uuid.UUID, send func(event model.WatchEvent) error) error {
+	// watch before reading the snapshot, so that no change in between is lost
+	watcher := b.bus.Watch(id)
+	defer watcher.Close()
+
+	account, err := b.repository.GetBankAccountByID(ctx, id, true)
+	if err != nil {
+		return err
+	}
+	if err = send(model.WatchEvent{Type: model.WatchSnapshot, Account: account}); err != nil || account.IsClosed() {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-watcher.Changed():
+		}
+
+		if !watcher.Deleted() {
+			current, err := b.repository.GetBankAccountByID(ctx, id, true)
+			if err == nil {
+				account = current
+				if err = send(model.WatchEvent{Type: model.WatchUpdated, Account: account}); err != nil || account.IsClosed() {
+					return err
+				}
+				continue
+			}
+			var notFound *apperr.NotFoundError
+			if !errors.As(err, &notFound) {
+				return err
+			}
+		}
+
+		return send(model.WatchEvent{Type: model.WatchDeleted, Account: account})
+	}
+}
@@ -11,25 +11,37 @@ import (
 	"github.com/stretchr/testify/require"
 	mock_account "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/mocks"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/watch"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money"
+	mock_money "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money/mocks"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/tests/fixtures"
+	"math/big"
 	"testing"
+	"time"
 )
 
 type bankAccountServiceFixture struct {
-	ctrl     *gomock.Controller
-	service  Service
-	mockRepo *mock_account.MockRepository
+	ctrl      *gomock.Controller
+	service   Service
+	mockRepo  *mock_account.MockRepository
+	mockRates *mock_money.MockRateProvider
+	bus       *watch.Bus
 }
 
 func NewBankAccountServiceFixture(t *testing.T) bankAccountServiceFixture {
 	ctrl := gomock.NewController(t)
 	mockRepo := mock_account.NewMockRepository(ctrl)
-	service := NewBankAccountService(mockRepo)
+	mockRates := mock_money.NewMockRateProvider(ctrl)
+	bus := watch.NewBus()
+	service := NewBankAccountService(mockRepo, mockRates, bus)
 	return bankAccountServiceFixture{
-		ctrl:     ctrl,
-		service:  service,
-		mockRepo: mockRepo,
+		ctrl:      ctrl,
+		service:   service,
+		mockRepo:  mockRepo,
+		mockRates: mockRates,
+		bus:       bus,
 	}
 }
 
@@ -110,12 +122,14 @@ func TestBankAccountService_GetBankAccountById(t *testing.T) {
 		ctx             = context.Background()
 		emptyAccount    *model.BankAccount
 		expectedAccount = fixtures.NewBankAccountBuilder().Valid().Build()
+		closedAccount   = fixtures.NewBankAccountBuilder().Valid().Status(model.StatusClosed).Build()
 		notFoundId, _   = uuid.Parse("331684ab-5af8-439a-8a4f-62a571013283")
 	)
 
 	tests := []struct {
 		name           string
 		requestID      uuid.UUID
+		options        model.ReadOptions
 		mockRepo       func(repository *mock_account.MockRepository)
 		expectedResult *model.BankAccount
 		expectedError  error
@@ -124,7 +138,7 @@ func TestBankAccountService_GetBankAccountById(t *testing.T) {
 			name:      "Valid QueryRow Request",
 			requestID: expectedAccount.ID,
 			mockRepo: func(repository *mock_account.MockRepository) {
-				repository.EXPECT().GetBankAccountByID(ctx, expectedAccount.ID).Return(expectedAccount, nil)
+				repository.EXPECT().GetBankAccountByID(ctx, expectedAccount.ID, true).Return(expectedAccount, nil)
 			},
 			expectedResult: expectedAccount,
 			expectedError:  nil,
@@ -133,12 +147,48 @@ func TestBankAccountService_GetBankAccountById(t *testing.T) {
 			name:      "Not Found Request",
 			requestID: notFoundId,
 			mockRepo: func(repository *mock_account.MockRepository) {
-				repository.EXPECT().GetBankAccountByID(ctx, notFoundId).
+				repository.EXPECT().GetBankAccountByID(ctx, notFoundId, true).
 					Return(nil, apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %d not found", 10329032)))
 			},
 			expectedResult: emptyAccount,
 			expectedError:  apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %d not found", 10329032)),
 		},
+		{
+			name:      "Closed account is not found",
+			requestID: closedAccount.ID,
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(ctx, closedAccount.ID, true).Return(closedAccount, nil)
+			},
+			expectedResult: emptyAccount,
+			expectedError:  apperr.NewNotFoundError("Bank account with ID: a7115d4e-65af-487f-a3ca-bf7ca9747c4c is closed"),
+		},
+		{
+			name:      "Closed account is included on request",
+			requestID: closedAccount.ID,
+			options:   model.ReadOptions{IncludeClosed: true},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(ctx, closedAccount.ID, true).Return(closedAccount, nil)
+			},
+			expectedResult: closedAccount,
+		},
+		{
+			name:      "Read for update locks the account",
+			requestID: expectedAccount.ID,
+			options:   model.ReadOptions{ForUpdate: true},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().LockBankAccount(ctx, expectedAccount.ID).Return(expectedAccount, nil)
+			},
+			expectedResult: expectedAccount,
+		},
+		{
+			name:      "Mask without subscriptions does not load them",
+			requestID: expectedAccount.ID,
+			options:   model.ReadOptions{Mask: model.ReadMask{"id": {}, "balance": {}}},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(ctx, expectedAccount.ID, false).Return(expectedAccount, nil)
+			},
+			expectedResult: expectedAccount,
+		},
 	}
 
 	for _, tc := range tests {
@@ -151,7 +201,7 @@ func TestBankAccountService_GetBankAccountById(t *testing.T) {
 				tc.mockRepo(fixture.mockRepo)
 			}
 
-			result, err := fixture.service.GetBankAccountById(ctx, tc.requestID)
+			result, err := fixture.service.GetBankAccountById(ctx, tc.requestID, tc.options)
 
 			assert.Equal(t, err, tc.expectedError)
 			if tc.expectedError == nil {
@@ -171,13 +221,17 @@ func TestBankAccountService_UpdateBankAccount(t *testing.T) {
 		ctx                = context.Background()
 		bankAccount        = fixtures.NewBankAccountBuilder().Valid().Balance(2000).Build()
 		invalidBankAccount = fixtures.NewBankAccountBuilder().Invalid().Balance(-500).BankName("").Build()
+		renamedAccount     = fixtures.NewBankAccountBuilder().Valid().HolderName("Dima Petrov").Balance(-500).BankName("").Build()
+		holderName         = model.UpdateMask{"holder_name": {}}
 		notFoundId, _      = uuid.Parse("331684ab-5af8-439a-8a4f-62a571013283")
 	)
 
 	tests := []struct {
 		name           string
+		ctx            context.Context
 		requestID      uuid.UUID
 		requestPayload model.BankAccount
+		mask           model.UpdateMask
 		mockRepo       func(repository *mock_account.MockRepository)
 		expectedResult *model.BankAccount
 		expectedError  error
@@ -187,25 +241,84 @@ func TestBankAccountService_UpdateBankAccount(t *testing.T) {
 			requestID:      bankAccount.ID,
 			requestPayload: *bankAccount,
 			mockRepo: func(repository *mock_account.MockRepository) {
-				repository.EXPECT().UpdateBankAccount(ctx, bankAccount.ID, bankAccount).Return(bankAccount, nil)
+				repository.EXPECT().UpdateBankAccount(ctx, bankAccount.ID, bankAccount, model.DefaultUpdateMask()).Return(bankAccount, nil)
 			},
 			expectedResult: bankAccount,
 			expectedError:  nil,
 		},
+		{
+			name:           "Missing Version",
+			requestID:      bankAccount.ID,
+			requestPayload: *fixtures.NewBankAccountBuilder().Valid().Version(0).Build(),
+			mockRepo:       nil,
+			expectedResult: nil,
+			expectedError:  apperr.NewBadRequestError("Version of the bank account is required"),
+		},
 		{
 			name:           "Invalid Request",
 			requestID:      bankAccount.ID,
 			requestPayload: *invalidBankAccount,
 			mockRepo:       nil,
 			expectedResult: nil,
-			expectedError:  apperr.NewBadRequestError("Balance: must be no less than 0; BankName: cannot be blank; HolderName: must be in a valid format."),
+			expectedError:  apperr.NewBadRequestError("BankName: cannot be blank; HolderName: must be in a valid format."),
+		},
+		{
+			name:           "Invalid Balance Listed In Mask",
+			requestID:      bankAccount.ID,
+			requestPayload: *invalidBankAccount,
+			mask:           model.UpdateMask{"balance": {}},
+			mockRepo:       nil,
+			expectedResult: nil,
+			expectedError:  apperr.NewBadRequestError("Balance: must be no less than 0."),
+		},
+		{
+			name:           "Holder Cannot Change The Balance",
+			ctx:            app.WithPrincipal(ctx, &app.Principal{Subject: "dima"}),
+			requestID:      bankAccount.ID,
+			requestPayload: *bankAccount,
+			mask:           model.UpdateMask{"balance": {}},
+			mockRepo:       nil,
+			expectedResult: nil,
+			expectedError:  apperr.NewForbiddenError("Balance can only be changed with the admin role"),
+		},
+		{
+			name:           "Admin Changes The Balance",
+			ctx:            app.WithPrincipal(ctx, &app.Principal{Subject: "root", Roles: []string{app.RoleAdmin}}),
+			requestID:      bankAccount.ID,
+			requestPayload: *bankAccount,
+			mask:           model.UpdateMask{"balance": {}},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().UpdateBankAccount(gomock.Any(), bankAccount.ID, bankAccount, model.UpdateMask{"balance": {}}).Return(bankAccount, nil)
+			},
+			expectedResult: bankAccount,
+			expectedError:  nil,
+		},
+		{
+			name:           "Only Masked Fields Are Validated",
+			requestID:      renamedAccount.ID,
+			requestPayload: *renamedAccount,
+			mask:           holderName,
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().UpdateBankAccount(ctx, renamedAccount.ID, renamedAccount, holderName).Return(renamedAccount, nil)
+			},
+			expectedResult: renamedAccount,
+			expectedError:  nil,
+		},
+		{
+			name:           "Invalid Masked Field",
+			requestID:      bankAccount.ID,
+			requestPayload: *invalidBankAccount,
+			mask:           holderName,
+			mockRepo:       nil,
+			expectedResult: nil,
+			expectedError:  apperr.NewBadRequestError("HolderName: must be in a valid format."),
 		},
 		{
 			name:           "Not Found Request",
 			requestID:      notFoundId,
 			requestPayload: *bankAccount,
 			mockRepo: func(repository *mock_account.MockRepository) {
-				repository.EXPECT().UpdateBankAccount(ctx, notFoundId, bankAccount).Return(
+				repository.EXPECT().UpdateBankAccount(ctx, notFoundId, bankAccount, model.DefaultUpdateMask()).Return(
 					nil, apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %d not found", 10329032)))
 			},
 			expectedResult: nil,
@@ -223,7 +336,15 @@ func TestBankAccountService_UpdateBankAccount(t *testing.T) {
 				tc.mockRepo(fixture.mockRepo)
 			}
 
-			result, err := fixture.service.UpdateBankAccount(ctx, tc.requestID, &tc.requestPayload)
+			mask := tc.mask
+			if mask == nil {
+				mask = model.DefaultUpdateMask()
+			}
+			callCtx := ctx
+			if tc.ctx != nil {
+				callCtx = tc.ctx
+			}
+			result, err := fixture.service.UpdateBankAccount(callCtx, tc.requestID, &tc.requestPayload, mask)
 
 			if tc.expectedError == nil {
 				require.NoError(t, err)
@@ -252,10 +373,10 @@ func TestBankAccountService_DeleteBankAccount(t *testing.T) {
 		expectedError  error
 	}{
 		{
-			name:      "Valid Delete Request",
+			name:      "Delete closes the account",
 			requestID: bankAccount.ID,
 			mockRepo: func(repository *mock_account.MockRepository) {
-				repository.EXPECT().DeleteBankAccount(ctx, bankAccount.ID).Return(bankAccount, nil)
+				repository.EXPECT().CloseBankAccount(ctx, bankAccount.ID, gomock.Any()).Return(bankAccount, nil)
 			},
 			expectedResult: bankAccount,
 			expectedError:  nil,
@@ -264,7 +385,7 @@ func TestBankAccountService_DeleteBankAccount(t *testing.T) {
 			name:      "Not Found Request",
 			requestID: notFoundId,
 			mockRepo: func(repository *mock_account.MockRepository) {
-				repository.EXPECT().DeleteBankAccount(ctx, notFoundId).Return(
+				repository.EXPECT().CloseBankAccount(ctx, notFoundId, gomock.Any()).Return(
 					nil, apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %d not found", 10329032)))
 			},
 			expectedResult: nil,
@@ -294,3 +415,471 @@ func TestBankAccountService_DeleteBankAccount(t *testing.T) {
 		})
 	}
 }
+
+func TestBankAccountService_Transfer(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx         = context.Background()
+		fromAccount = fixtures.NewBankAccountBuilder().Valid().Balance(500).Build()
+		toID, _     = uuid.Parse("331684ab-5af8-439a-8a4f-62a571013283")
+		toAccount   = fixtures.NewBankAccountBuilder().Valid().ID(toID).Balance(1500).Build()
+		usdAccount  = fixtures.NewBankAccountBuilder().Valid().ID(toID).Balance(1500).Currency("USD").Build()
+	)
+
+	tests := []struct {
+		name             string
+		transfer         model.Transfer
+		mockRepo         func(repository *mock_account.MockRepository)
+		mockRates        func(rates *mock_money.MockRateProvider)
+		expectedFrom     *model.BankAccount
+		expectedTo       *model.BankAccount
+		expectedToAmount int64
+		expectedError    error
+	}{
+		{
+			name:     "Valid Transfer Request",
+			transfer: model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: 500},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(ctx, fromAccount.ID, false).Return(fromAccount, nil)
+				repository.EXPECT().GetBankAccountByID(ctx, toID, false).Return(toAccount, nil)
+				repository.EXPECT().Transfer(ctx, gomock.Any()).Return(fromAccount, toAccount, nil)
+			},
+			expectedFrom:     fromAccount,
+			expectedTo:       toAccount,
+			expectedToAmount: 500,
+			expectedError:    nil,
+		},
+		{
+			name:     "Cross Currency Transfer",
+			transfer: model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: 500},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(ctx, fromAccount.ID, false).Return(fromAccount, nil)
+				repository.EXPECT().GetBankAccountByID(ctx, toID, false).Return(usdAccount, nil)
+				repository.EXPECT().Transfer(ctx, gomock.Any()).Return(fromAccount, usdAccount, nil)
+			},
+			mockRates: func(rates *mock_money.MockRateProvider) {
+				rates.EXPECT().Rate(ctx, "RUB", "USD").Return(big.NewRat(1, 90), nil)
+			},
+			expectedFrom:     fromAccount,
+			expectedTo:       usdAccount,
+			expectedToAmount: 6,
+			expectedError:    nil,
+		},
+		{
+			name:     "Missing Exchange Rate",
+			transfer: model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: 500},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(ctx, fromAccount.ID, false).Return(fromAccount, nil)
+				repository.EXPECT().GetBankAccountByID(ctx, toID, false).Return(usdAccount, nil)
+			},
+			mockRates: func(rates *mock_money.MockRateProvider) {
+				rates.EXPECT().Rate(ctx, "RUB", "USD").Return(nil, money.ErrRateNotFound)
+			},
+			expectedError: apperr.NewBadRequestError("No exchange rate from RUB to USD"),
+		},
+		{
+			name:          "Same Account",
+			transfer:      model.Transfer{FromID: fromAccount.ID, ToID: fromAccount.ID, Amount: 500},
+			expectedError: apperr.NewBadRequestError("ToID: must differ from FromID."),
+		},
+		{
+			name:          "Non Positive Amount",
+			transfer:      model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: -10},
+			expectedError: apperr.NewBadRequestError("Amount: must be no less than 1."),
+		},
+		{
+			name:     "Insufficient Funds",
+			transfer: model.Transfer{FromID: fromAccount.ID, ToID: toID, Amount: 5000},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(ctx, fromAccount.ID, false).Return(fromAccount, nil)
+				repository.EXPECT().GetBankAccountByID(ctx, toID, false).Return(toAccount, nil)
+				repository.EXPECT().Transfer(ctx, gomock.Any()).
+					Return(nil, nil, apperr.NewBadRequestError("Insufficient funds"))
+			},
+			expectedError: apperr.NewBadRequestError("Insufficient funds"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fixture := NewBankAccountServiceFixture(t)
+			if tc.mockRepo != nil {
+				tc.mockRepo(fixture.mockRepo)
+			}
+			if tc.mockRates != nil {
+				tc.mockRates(fixture.mockRates)
+			}
+
+			from, to, err := fixture.service.Transfer(ctx, &tc.transfer)
+
+			if tc.expectedError == nil {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedFrom, from)
+				assert.Equal(t, tc.expectedTo, to)
+				assert.NotEqual(t, uuid.Nil, tc.transfer.ID)
+				assert.Equal(t, tc.expectedToAmount, tc.transfer.ToAmount)
+			} else {
+				assert.EqualError(t, err, tc.expectedError.Error())
+				assert.Nil(t, from)
+				assert.Nil(t, to)
+			}
+		})
+	}
+}
+
+func TestBankAccountService_ListAccountTransactions(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx     = context.Background()
+		account = fixtures.NewBankAccountBuilder().Valid().Build()
+		now     = time.Now()
+		entries = []model.LedgerEntry{
+			{ID: uuid.New(), AccountID: account.ID, Amount: 300, CreatedAt: now},
+			{ID: uuid.New(), AccountID: account.ID, Amount: -100, CreatedAt: now.Add(-time.Minute)},
+			{ID: uuid.New(), AccountID: account.ID, Amount: 800, CreatedAt: now.Add(-time.Hour)},
+		}
+	)
+
+	tests := []struct {
+		name              string
+		filter            model.LedgerFilter
+		mockRepo          func(repository *mock_account.MockRepository)
+		expectedEntries   []model.LedgerEntry
+		expectedNextToken string
+		expectedError     error
+	}{
+		{
+			name:   "Last Page",
+			filter: model.LedgerFilter{AccountID: account.ID},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(ctx, account.ID, false).Return(account, nil)
+				repository.EXPECT().ListLedgerEntries(ctx, gomock.Any()).Return(entries, nil)
+			},
+			expectedEntries:   entries,
+			expectedNextToken: "",
+		},
+		{
+			name:   "Page With Next Token",
+			filter: model.LedgerFilter{AccountID: account.ID, Limit: 2},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(ctx, account.ID, false).Return(account, nil)
+				repository.EXPECT().ListLedgerEntries(ctx, gomock.Any()).Return(entries, nil)
+			},
+			expectedEntries:   entries[:2],
+			expectedNextToken: model.LedgerCursor{CreatedAt: entries[1].CreatedAt, ID: entries[1].ID}.Encode(),
+		},
+		{
+			name:          "Invalid Time Range",
+			filter:        model.LedgerFilter{AccountID: account.ID, From: now, To: now.Add(-time.Hour)},
+			expectedError: apperr.NewBadRequestError("from must be before to"),
+		},
+		{
+			name:   "Account Not Found",
+			filter: model.LedgerFilter{AccountID: account.ID},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(ctx, account.ID, false).
+					Return(nil, apperr.NewNotFoundError("Bank account not found"))
+			},
+			expectedError: apperr.NewNotFoundError("Bank account not found"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fixture := NewBankAccountServiceFixture(t)
+			if tc.mockRepo != nil {
+				tc.mockRepo(fixture.mockRepo)
+			}
+
+			result, nextToken, err := fixture.service.ListAccountTransactions(ctx, &tc.filter)
+
+			if tc.expectedError == nil {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedEntries, result)
+				assert.Equal(t, tc.expectedNextToken, nextToken)
+			} else {
+				assert.EqualError(t, err, tc.expectedError.Error())
+				assert.Nil(t, result)
+			}
+		})
+	}
+}
+
+func TestBankAccountService_ListBankAccounts(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx        = context.Background()
+		firstID, _ = uuid.Parse("331684ab-5af8-439a-8a4f-62a571013283")
+		first      = fixtures.NewBankAccountBuilder().Valid().ID(firstID).Balance(100).Build()
+		second     = fixtures.NewBankAccountBuilder().Valid().Balance(200).Build()
+		minBalance = int64(500)
+		maxBalance = int64(100)
+	)
+
+	tests := []struct {
+		name              string
+		filter            model.BankAccountFilter
+		mockRepo          func(repository *mock_account.MockRepository)
+		expectedAccounts  []model.BankAccount
+		expectedNextToken string
+		expectedError     error
+	}{
+		{
+			name:   "Page With Next Token",
+			filter: model.BankAccountFilter{SortBy: model.SortByBalance, Limit: 1},
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().ListBankAccounts(ctx, gomock.Any()).Return([]model.BankAccount{*first, *second}, nil)
+			},
+			expectedAccounts: []model.BankAccount{*first},
+			expectedNextToken: model.BankAccountCursor{
+				SortBy: model.SortByBalance,
+				Value:  "100",
+				ID:     firstID,
+			}.Encode(),
+		},
+		{
+			name:          "Invalid Balance Range",
+			filter:        model.BankAccountFilter{MinBalance: &minBalance, MaxBalance: &maxBalance},
+			expectedError: apperr.NewBadRequestError("min balance must not exceed max balance"),
+		},
+		{
+			name: "Page Token Of Another Sort Order",
+			filter: model.BankAccountFilter{
+				SortBy: model.SortByHolderName,
+				After:  &model.BankAccountCursor{SortBy: model.SortByBalance, Value: "100", ID: firstID},
+			},
+			expectedError: apperr.NewBadRequestError("page token does not match the requested sort order"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fixture := NewBankAccountServiceFixture(t)
+			if tc.mockRepo != nil {
+				tc.mockRepo(fixture.mockRepo)
+			}
+
+			result, nextToken, err := fixture.service.ListBankAccounts(ctx, &tc.filter)
+
+			if tc.expectedError == nil {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedAccounts, result)
+				assert.Equal(t, tc.expectedNextToken, nextToken)
+			} else {
+				assert.EqualError(t, err, tc.expectedError.Error())
+				assert.Nil(t, result)
+			}
+		})
+	}
+}
+
+func TestBankAccountService_WatchBankAccount(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx      = context.Background()
+		snapshot = fixtures.NewBankAccountBuilder().Valid().Build()
+		updated  = fixtures.NewBankAccountBuilder().Valid().Balance(1500).Version(2).Build()
+		id       = snapshot.ID
+	)
+
+	tests := []struct {
+		name           string
+		mockRepo       func(repository *mock_account.MockRepository)
+		changes        []watch.Change
+		expectedEvents []model.WatchEvent
+		expectedError  error
+	}{
+		{
+			name: "Snapshot, update and deletion",
+			mockRepo: func(repository *mock_account.MockRepository) {
+				gomock.InOrder(
+					repository.EXPECT().GetBankAccountByID(gomock.Any(), id, true).Return(snapshot, nil),
+					repository.EXPECT().GetBankAccountByID(gomock.Any(), id, true).Return(updated, nil),
+				)
+			},
+			changes: []watch.Change{{AccountID: id}, {AccountID: id, Deleted: true}},
+			expectedEvents: []model.WatchEvent{
+				{Type: model.WatchSnapshot, Account: snapshot},
+				{Type: model.WatchUpdated, Account: updated},
+				{Type: model.WatchDeleted, Account: updated},
+			},
+		},
+		{
+			name: "Account is gone when the change is read",
+			mockRepo: func(repository *mock_account.MockRepository) {
+				gomock.InOrder(
+					repository.EXPECT().GetBankAccountByID(gomock.Any(), id, true).Return(snapshot, nil),
+					repository.EXPECT().GetBankAccountByID(gomock.Any(), id, true).
+						Return(nil, apperr.NewNotFoundError("Bank account not found")),
+				)
+			},
+			changes: []watch.Change{{AccountID: id}},
+			expectedEvents: []model.WatchEvent{
+				{Type: model.WatchSnapshot, Account: snapshot},
+				{Type: model.WatchDeleted, Account: snapshot},
+			},
+		},
+		{
+			name: "Account not found",
+			mockRepo: func(repository *mock_account.MockRepository) {
+				repository.EXPECT().GetBankAccountByID(gomock.Any(), id, true).
+					Return(nil, apperr.NewNotFoundError("Bank account not found"))
+			},
+			expectedError: apperr.NewNotFoundError("Bank account not found"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fixture := NewBankAccountServiceFixture(t)
+			tc.mockRepo(fixture.mockRepo)
+
+			var events []model.WatchEvent
+			err := fixture.service.WatchBankAccount(ctx, id, func(event model.WatchEvent) error {
+				events = append(events, event)
+				// publish the next change only after the previous one was sent,
+				// otherwise the bus merges them
+				if len(tc.changes) >= len(events) {
+					fixture.bus.Publish(tc.changes[len(events)-1])
+				}
+				return nil
+			})
+
+			if tc.expectedError == nil {
+				require.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedError.Error())
+			}
+			assert.Equal(t, tc.expectedEvents, events)
+		})
+	}
+}
+
+func TestBankAccountService_WatchBankAccount_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewBankAccountServiceFixture(t)
+	account := fixtures.NewBankAccountBuilder().Valid().Build()
+	fixture.mockRepo.EXPECT().GetBankAccountByID(gomock.Any(), account.ID, true).Return(account, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := fixture.service.WatchBankAccount(ctx, account.ID, func(event model.WatchEvent) error {
+		cancel()
+		return nil
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestBankAccountService_ImportBankAccounts(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx        = app.WithPrincipal(context.Background(), &app.Principal{Subject: "root", Roles: []string{app.RoleAdmin}})
+		firstID    = uuid.MustParse("331684ab-5af8-439a-8a4f-62a571013283")
+		secondID   = uuid.MustParse("a7115d4e-65af-487f-a3ca-bf7ca9747c4c")
+		existingID = uuid.MustParse("f3c1a4f2-1111-4b7a-9c9d-7f8e6a5b4c3d")
+	)
+
+	rows := []model.ImportRow{
+		{Index: 0, Account: fixtures.NewBankAccountBuilder().Valid().ID(firstID).OwnerID("ivan").Build()},
+		{Index: 1, Account: fixtures.NewBankAccountBuilder().Invalid().Build()},
+		{Index: 2, Account: fixtures.NewBankAccountBuilder().Valid().ID(existingID).Build()},
+		{Index: 4, Account: fixtures.NewBankAccountBuilder().Valid().ID(firstID).Build()},
+		{Index: 5, Account: fixtures.NewBankAccountBuilder().Valid().ID(secondID).Currency("").OwnerID("").Build()},
+	}
+
+	fixture := NewBankAccountServiceFixture(t)
+	fixture.mockRepo.EXPECT().ImportBankAccounts(ctx, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, accounts []model.BankAccount) ([]uuid.UUID, error) {
+			require.Len(t, accounts, 3)
+			assert.Equal(t, model.InitialVersion, int(accounts[0].Version))
+			assert.Equal(t, money.DefaultCurrency, accounts[2].Currency)
+			assert.Equal(t, "ivan", accounts[0].OwnerID)
+			assert.Equal(t, "root", accounts[2].OwnerID, "accounts without an owner belong to the caller")
+			return []uuid.UUID{existingID}, nil
+		})
+
+	result, err := fixture.service.ImportBankAccounts(ctx, rows)
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Imported)
+	assert.Equal(t, []model.ImportFailure{
+		{
+			Index:      1,
+			Message:    "HolderName: must be in a valid format.",
+			Violations: []apperr.FieldViolation{{Field: "holder_name", Description: "must be in a valid format"}},
+		},
+		{Index: 2, Message: fmt.Sprintf("Bank account with ID: %s already exists", existingID)},
+		{Index: 4, Message: fmt.Sprintf("Bank account with ID: %s is repeated in the import", firstID)},
+	}, result.Failures)
+}
+
+func TestBankAccountService_Ownership(t *testing.T) {
+	t.Parallel()
+	var (
+		holder = app.WithPrincipal(context.Background(), &app.Principal{Subject: "dima"})
+		admin  = app.WithPrincipal(context.Background(), &app.Principal{Subject: "root", Roles: []string{app.RoleAdmin}})
+	)
+
+	t.Run("Holder opens an account for themself", func(t *testing.T) {
+		t.Parallel()
+		fixture := NewBankAccountServiceFixture(t)
+		fixture.mockRepo.EXPECT().CreateBankAccount(holder, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, account *model.BankAccount) (*model.BankAccount, error) {
+				return account, nil
+			})
+
+		created, err := fixture.service.CreateBankAccount(holder, fixtures.NewBankAccountBuilder().Valid().OwnerID("someone").Build())
+
+		require.NoError(t, err)
+		assert.Equal(t, "dima", created.OwnerID)
+	})
+
+	t.Run("Admin opens an account for a holder", func(t *testing.T) {
+		t.Parallel()
+		fixture := NewBankAccountServiceFixture(t)
+		fixture.mockRepo.EXPECT().CreateBankAccount(admin, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, account *model.BankAccount) (*model.BankAccount, error) {
+				return account, nil
+			})
+
+		created, err := fixture.service.CreateBankAccount(admin, fixtures.NewBankAccountBuilder().Valid().OwnerID("someone").Build())
+
+		require.NoError(t, err)
+		assert.Equal(t, "someone", created.OwnerID)
+	})
+
+	t.Run("Holder lists only their accounts", func(t *testing.T) {
+		t.Parallel()
+		fixture := NewBankAccountServiceFixture(t)
+		fixture.mockRepo.EXPECT().ListBankAccounts(holder, &model.BankAccountFilter{OwnerID: "dima", Limit: model.DefaultPageSize + 1}).
+			Return(nil, nil)
+
+		_, _, err := fixture.service.ListBankAccounts(holder, &model.BankAccountFilter{})
+
+		require.NoError(t, err)
+	})
+
+	t.Run("Admin lists every account", func(t *testing.T) {
+		t.Parallel()
+		fixture := NewBankAccountServiceFixture(t)
+		fixture.mockRepo.EXPECT().ListBankAccounts(admin, &model.BankAccountFilter{Limit: model.DefaultPageSize + 1}).
+			Return(nil, nil)
+
+		_, _, err := fixture.service.ListBankAccounts(admin, &model.BankAccountFilter{})
+
+		require.NoError(t, err)
+	})
+}
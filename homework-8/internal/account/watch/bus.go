@@ -0,0 +1,112 @@
+package watch
+
+import (
+	"github.com/google/uuid"
+	"sync"
+	"sync/atomic"
+)
+
+// Change tells that an account was changed or deleted. It carries no state;
+// watchers read the account again, so coalesced changes lose nothing.
+type Change struct {
+	AccountID uuid.UUID
+	Deleted   bool
+}
+
+// Bus fans changes out to the watchers of each account.
+type Bus struct {
+	mu       sync.Mutex
+	watchers map[uuid.UUID]map[*Watcher]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		watchers: make(map[uuid.UUID]map[*Watcher]struct{}),
+	}
+}
+
+// Watch registers a watcher of the account. It must be closed by the caller.
+func (b *Bus) Watch(accountID uuid.UUID) *Watcher {
+	w := &Watcher{
+		bus:       b,
+		accountID: accountID,
+		changed:   make(chan struct{}, 1),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.watchers[accountID] == nil {
+		b.watchers[accountID] = make(map[*Watcher]struct{})
+	}
+	b.watchers[accountID][w] = struct{}{}
+
+	return w
+}
+
+// Publish notifies the watchers of the changed account. It never blocks.
+func (b *Bus) Publish(change Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for w := range b.watchers[change.AccountID] {
+		w.notify(change.Deleted)
+	}
+}
+
+// PublishAll notifies every watcher. It is used when changes may have been
+// missed, e.g. after the connection to the database was lost.
+func (b *Bus) PublishAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, watchers := range b.watchers {
+		for w := range watchers {
+			w.notify(false)
+		}
+	}
+}
+
+func (b *Bus) remove(w *Watcher) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.watchers[w.accountID], w)
+	if len(b.watchers[w.accountID]) == 0 {
+		delete(b.watchers, w.accountID)
+	}
+}
+
+// Watcher receives the changes of one account.
+type Watcher struct {
+	bus       *Bus
+	accountID uuid.UUID
+	changed   chan struct{}
+	deleted   atomic.Bool
+	closeOnce sync.Once
+}
+
+// Changed receives a value after one or more changes. Pending changes are
+// merged into one, so a slow reader catches up with the latest state instead
+// of working off a growing backlog.
+func (w *Watcher) Changed() <-chan struct{} {
+	return w.changed
+}
+
+// Deleted reports whether the account was deleted.
+func (w *Watcher) Deleted() bool {
+	return w.deleted.Load()
+}
+
+func (w *Watcher) Close() {
+	w.closeOnce.Do(func() {
+		w.bus.remove(w)
+	})
+}
+
+func (w *Watcher) notify(deleted bool) {
+	if deleted {
+		w.deleted.Store(true)
+	}
+	select {
+	case w.changed <- struct{}{}:
+	default:
+		// a change is already pending
+	}
+}
@@ -0,0 +1,124 @@
+package watch
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func pending(w *Watcher) bool {
+	select {
+	case <-w.Changed():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestBus_Publish(t *testing.T) {
+	t.Parallel()
+
+	var (
+		bus    = NewBus()
+		id     = uuid.New()
+		first  = bus.Watch(id)
+		second = bus.Watch(id)
+		other  = bus.Watch(uuid.New())
+		closed = bus.Watch(id)
+	)
+	closed.Close()
+
+	bus.Publish(Change{AccountID: id})
+	bus.Publish(Change{AccountID: id})
+
+	assert.True(t, pending(first))
+	assert.False(t, pending(first), "changes are merged")
+	assert.True(t, pending(second))
+	assert.False(t, pending(other))
+	assert.False(t, pending(closed))
+
+	bus.Publish(Change{AccountID: id, Deleted: true})
+	bus.Publish(Change{AccountID: id})
+
+	assert.True(t, pending(first))
+	assert.True(t, first.Deleted(), "deletion is not lost by merging")
+}
+
+func TestBus_PublishAll(t *testing.T) {
+	t.Parallel()
+
+	bus := NewBus()
+	first, second := bus.Watch(uuid.New()), bus.Watch(uuid.New())
+
+	bus.PublishAll()
+
+	assert.True(t, pending(first))
+	assert.True(t, pending(second))
+	assert.False(t, first.Deleted())
+}
+
+func TestBus_Close(t *testing.T) {
+	t.Parallel()
+
+	bus := NewBus()
+	w := bus.Watch(uuid.New())
+	w.Close()
+	w.Close()
+
+	assert.Empty(t, bus.watchers)
+}
+
+func TestForward(t *testing.T) {
+	t.Parallel()
+
+	var (
+		bus           = NewBus()
+		id            = uuid.New()
+		watcher       = bus.Watch(id)
+		otherWatcher  = bus.Watch(uuid.New())
+		notifications = make(chan *pq.Notification, 3)
+	)
+
+	notifications <- &pq.Notification{Channel: Channel, Extra: `{"account_id": "` + id.String() + `", "op": "DELETE"}`}
+	notifications <- &pq.Notification{Channel: Channel, Extra: `not json`}
+	notifications <- nil
+	close(notifications)
+
+	Forward(context.Background(), notifications, bus, func() {})
+
+	assert.True(t, pending(watcher))
+	assert.True(t, watcher.Deleted())
+	assert.True(t, pending(otherWatcher), "reconnect notifies every watcher")
+	assert.False(t, otherWatcher.Deleted())
+}
+
+// recorder remembers what was published to it in a log shared with others.
+type recorder struct {
+	name string
+	log  *[]string
+}
+
+func (r recorder) Publish(change Change) {
+	*r.log = append(*r.log, r.name+" "+change.AccountID.String())
+}
+
+func (r recorder) PublishAll() {
+	*r.log = append(*r.log, r.name+" all")
+}
+
+func TestPublishers(t *testing.T) {
+	t.Parallel()
+
+	var (
+		id  = uuid.New()
+		log []string
+	)
+	publishers := Publishers{recorder{name: "cache", log: &log}, recorder{name: "bus", log: &log}}
+
+	publishers.Publish(Change{AccountID: id})
+	publishers.PublishAll()
+
+	assert.Equal(t, []string{"cache " + id.String(), "bus " + id.String(), "cache all", "bus all"}, log)
+}
@@ -0,0 +1,113 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
+	"time"
+)
+
+// Channel is the Postgres channel the bank_account and subscription triggers
+// notify on. Notifications are sent on commit, so rolled back changes are
+// never seen, and they reach every replica of the service.
+const Channel = "bank_account_changes"
+
+const (
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+	pingInterval         = 90 * time.Second
+)
+
+type notification struct {
+	AccountID uuid.UUID `json:"account_id"`
+	Op        string    `json:"op"`
+}
+
+// Publisher receives the account changes read by a Listener. Bus is one.
+type Publisher interface {
+	Publish(change Change)
+	// PublishAll tells that any account may have changed.
+	PublishAll()
+}
+
+// Publishers passes every change to each publisher in order.
+type Publishers []Publisher
+
+func (p Publishers) Publish(change Change) {
+	for _, publisher := range p {
+		publisher.Publish(change)
+	}
+}
+
+func (p Publishers) PublishAll() {
+	for _, publisher := range p {
+		publisher.PublishAll()
+	}
+}
+
+// Listener forwards account changes from Postgres LISTEN/NOTIFY to a publisher.
+type Listener struct {
+	listener  *pq.Listener
+	publisher Publisher
+}
+
+func NewListener(connectionString string, publisher Publisher) *Listener {
+	return &Listener{
+		listener:  pq.NewListener(connectionString, minReconnectInterval, maxReconnectInterval, logListenerEvent),
+		publisher: publisher,
+	}
+}
+
+// Run listens for changes until ctx is cancelled.
+func (l *Listener) Run(ctx context.Context) error {
+	defer l.listener.Close()
+
+	if err := l.listener.Listen(Channel); err != nil {
+		return err
+	}
+
+	Forward(ctx, l.listener.Notify, l.publisher, func() {
+		go l.listener.Ping()
+	})
+	return nil
+}
+
+// Forward publishes notifications until ctx is cancelled or the channel is
+// closed. pq sends nil after a reconnect; notifications may have been lost
+// meanwhile, so every account is published as changed.
+func Forward(ctx context.Context, notifications <-chan *pq.Notification, publisher Publisher, ping func()) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ping()
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if n == nil {
+				publisher.PublishAll()
+				continue
+			}
+
+			var payload notification
+			if err := json.Unmarshal([]byte(n.Extra), &payload); err != nil {
+				logging.Errorf(ctx, "watch: invalid notification %q: %s", n.Extra, err)
+				continue
+			}
+			publisher.Publish(Change{AccountID: payload.AccountID, Deleted: payload.Op == "DELETE"})
+		}
+	}
+}
+
+func logListenerEvent(event pq.ListenerEventType, err error) {
+	if err != nil {
+		logging.Errorf(context.Background(), "watch: listener event %d: %s", event, err)
+	}
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"os"
+	"strings"
+)
+
+// RoleAdmin may access the bank accounts of every holder.
+const RoleAdmin = "admin"
+
+// Principal is the caller of a request as asserted by its access token.
+// Subject is the owner_id of the bank accounts the caller holds.
+type Principal struct {
+	Subject string
+	Roles   []string
+}
+
+func (p Principal) IsAdmin() bool {
+	for _, role := range p.Roles {
+		if role == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess reports whether the principal may access rows owned by owner.
+// Rows without an owner are only accessible to admins.
+func (p Principal) CanAccess(owner string) bool {
+	return p.IsAdmin() || owner != "" && owner == p.Subject
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles"`
+}
+
+// TokenVerifier checks access tokens signed with HS256 by a shared secret or
+// with RS256 by the private half of a local RSA key. Tokens must expire and
+// carry a subject.
+type TokenVerifier struct {
+	secret    []byte
+	publicKey *rsa.PublicKey
+	methods   []string
+}
+
+func NewTokenVerifier(secret []byte, publicKey *rsa.PublicKey) (*TokenVerifier, error) {
+	verifier := &TokenVerifier{secret: secret, publicKey: publicKey}
+	if len(secret) > 0 {
+		verifier.methods = append(verifier.methods, jwt.SigningMethodHS256.Alg())
+	}
+	if publicKey != nil {
+		verifier.methods = append(verifier.methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(verifier.methods) == 0 {
+		return nil, errors.New("auth: neither an HMAC secret nor an RSA public key is configured")
+	}
+	return verifier, nil
+}
+
+// LoadTokenVerifier reads the HMAC secret, unless it is given, and the PEM
+// encoded RSA public key from files. Either path may be empty to disable the
+// algorithm.
+func LoadTokenVerifier(secretValue string, secretFile string, publicKeyFile string) (*TokenVerifier, error) {
+	secret := []byte(secretValue)
+	if len(secret) == 0 && secretFile != "" {
+		data, err := os.ReadFile(secretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = []byte(strings.TrimSpace(string(data)))
+	}
+
+	var publicKey *rsa.PublicKey
+	if publicKeyFile != "" {
+		data, err := os.ReadFile(publicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, err = jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %s: %w", publicKeyFile, err)
+		}
+	}
+
+	return NewTokenVerifier(secret, publicKey)
+}
+
+func (v *TokenVerifier) Verify(token string) (*Principal, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, v.key, jwt.WithValidMethods(v.methods), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return &Principal{Subject: claims.Subject, Roles: claims.Roles}, nil
+}
+
+func (v *TokenVerifier) key(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() == jwt.SigningMethodRS256.Alg() {
+		return v.publicKey, nil
+	}
+	return v.secret, nil
+}
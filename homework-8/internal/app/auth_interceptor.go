@@ -0,0 +1,217 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/subscriptions"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strings"
+)
+
+// AuthorizationHeader is read from the incoming metadata. The gateway forwards
+// the HTTP Authorization header under the same name.
+const AuthorizationHeader = "authorization"
+
+const bearerPrefix = "bearer "
+
+// AccountOwners finds the holder that owns a bank account, directly or through
+// one of its subscriptions. A missing row is reported by its not found error.
+type AccountOwners interface {
+	GetBankAccountOwner(ctx context.Context, id uuid.UUID) (string, error)
+	GetSubscriptionOwner(ctx context.Context, id uuid.UUID) (string, error)
+}
+
+// AuthUnaryServerInterceptor authenticates the bearer token of every call and
+// stores the principal in the context. Holders may only touch the bank accounts
+// they own and their subscriptions; adminMethods are reserved to admins. The
+// grpc.health.v1 service is open to probes without a token.
+func AuthUnaryServerInterceptor(verifier *TokenVerifier, owners AccountOwners, adminMethods []string) grpc.UnaryServerInterceptor {
+	authorizer := newAuthorizer(verifier, owners, adminMethods)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isHealthMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		principal, err := authorizer.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		ctx = WithPrincipal(ctx, principal)
+
+		if err := authorizer.authorize(ctx, principal, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// AuthStreamServerInterceptor is AuthUnaryServerInterceptor for streams. Every
+// received message is authorized before the handler sees it.
+func AuthStreamServerInterceptor(verifier *TokenVerifier, owners AccountOwners, adminMethods []string) grpc.StreamServerInterceptor {
+	authorizer := newAuthorizer(verifier, owners, adminMethods)
+
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthMethod(info.FullMethod) {
+			return handler(srv, stream)
+		}
+		principal, err := authorizer.authenticate(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &authorizedStream{
+			ServerStream: stream,
+			ctx:          WithPrincipal(stream.Context(), principal),
+			principal:    principal,
+			authorizer:   authorizer,
+		})
+	}
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx        context.Context
+	principal  *Principal
+	authorizer *authorizer
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.authorizer.authorize(s.ctx, s.principal, m)
+}
+
+type authorizer struct {
+	verifier     *TokenVerifier
+	owners       AccountOwners
+	adminMethods map[string]struct{}
+}
+
+func newAuthorizer(verifier *TokenVerifier, owners AccountOwners, adminMethods []string) *authorizer {
+	a := &authorizer{
+		verifier:     verifier,
+		owners:       owners,
+		adminMethods: make(map[string]struct{}, len(adminMethods)),
+	}
+	for _, method := range adminMethods {
+		a.adminMethods[method] = struct{}{}
+	}
+	return a
+}
+
+func (a *authorizer) authenticate(ctx context.Context, method string) (*Principal, error) {
+	token := bearerTokenFromContext(ctx)
+	if token == "" {
+		return nil, apperr.NewUnauthenticatedError("Missing bearer token")
+	}
+	principal, err := a.verifier.Verify(token)
+	if err != nil {
+		return nil, apperr.NewUnauthenticatedError(fmt.Sprintf("Invalid bearer token: %s", err))
+	}
+
+	if _, ok := a.adminMethods[method]; ok && !principal.IsAdmin() {
+		return nil, apperr.NewForbiddenError(fmt.Sprintf("Method %s requires the %s role", method, RoleAdmin))
+	}
+	return principal, nil
+}
+
+// authorize checks that the principal owns every bank account and subscription
+// the request refers to. Malformed ids are left to the handler to reject.
+func (a *authorizer) authorize(ctx context.Context, principal *Principal, req interface{}) error {
+	if principal.IsAdmin() {
+		return nil
+	}
+
+	accountIDs, subscriptionIDs := requestResources(req)
+	for _, value := range accountIDs {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			continue
+		}
+		owner, err := a.owners.GetBankAccountOwner(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !principal.CanAccess(owner) {
+			return apperr.NewForbiddenError(fmt.Sprintf("Access to bank account with ID: %s is denied", id))
+		}
+	}
+	for _, value := range subscriptionIDs {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			continue
+		}
+		owner, err := a.owners.GetSubscriptionOwner(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !principal.CanAccess(owner) {
+			return apperr.NewForbiddenError(fmt.Sprintf("Access to subscription with ID: %s is denied", id))
+		}
+	}
+	return nil
+}
+
+// requestResources returns the ids of the bank accounts and subscriptions a
+// request reads or changes. A transfer only needs the source account to be
+// owned: anyone may be paid.
+func requestResources(req interface{}) (accountIDs []string, subscriptionIDs []string) {
+	switch r := req.(type) {
+	case *bank_accounts.GetBankAccountByIdRequest:
+		accountIDs = append(accountIDs, r.GetId().GetValue())
+	case *bank_accounts.UpdateBankAccountRequest:
+		accountIDs = append(accountIDs, r.GetId().GetValue())
+	case *bank_accounts.DeleteBankAccountRequest:
+		accountIDs = append(accountIDs, r.GetId().GetValue())
+	case *bank_accounts.CloseBankAccountRequest:
+		accountIDs = append(accountIDs, r.GetId().GetValue())
+	case *bank_accounts.FreezeBankAccountRequest:
+		accountIDs = append(accountIDs, r.GetId().GetValue())
+	case *bank_accounts.UnfreezeBankAccountRequest:
+		accountIDs = append(accountIDs, r.GetId().GetValue())
+	case *bank_accounts.WatchBankAccountRequest:
+		accountIDs = append(accountIDs, r.GetId().GetValue())
+	case *bank_accounts.TransferRequest:
+		accountIDs = append(accountIDs, r.GetFromId().GetValue())
+	case *bank_accounts.ListAccountTransactionsRequest:
+		accountIDs = append(accountIDs, r.GetAccountId().GetValue())
+	case *subscriptions.CreateSubscriptionRequest:
+		accountIDs = append(accountIDs, r.GetSubscription().GetAccountId().GetValue())
+	case *subscriptions.GetSubscriptionByIdRequest:
+		subscriptionIDs = append(subscriptionIDs, r.GetId().GetValue())
+	case *subscriptions.UpdateSubscriptionRequest:
+		subscriptionIDs = append(subscriptionIDs, r.GetId().GetValue())
+		if r.GetSubscription().GetAccountId() != nil {
+			accountIDs = append(accountIDs, r.GetSubscription().GetAccountId().GetValue())
+		}
+	case *subscriptions.CancelSubscriptionRequest:
+		subscriptionIDs = append(subscriptionIDs, r.GetId().GetValue())
+	case *subscriptions.ListSubscriptionsByAccountRequest:
+		accountIDs = append(accountIDs, r.GetAccountId().GetValue())
+	}
+	return accountIDs, subscriptionIDs
+}
+
+func bearerTokenFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(AuthorizationHeader)
+	if len(values) == 0 {
+		return ""
+	}
+	if len(values[0]) < len(bearerPrefix) || !strings.EqualFold(values[0][:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(values[0][len(bearerPrefix):])
+}
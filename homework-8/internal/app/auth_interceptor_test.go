@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/bank_accounts"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/subscriptions"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+const adminMethod = "/test.Service/Export"
+
+type memoryAccountOwners struct {
+	accounts      map[uuid.UUID]string
+	subscriptions map[uuid.UUID]uuid.UUID
+}
+
+func (o memoryAccountOwners) GetBankAccountOwner(ctx context.Context, id uuid.UUID) (string, error) {
+	owner, ok := o.accounts[id]
+	if !ok {
+		return "", apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %s not found", id))
+	}
+	return owner, nil
+}
+
+func (o memoryAccountOwners) GetSubscriptionOwner(ctx context.Context, id uuid.UUID) (string, error) {
+	accountID, ok := o.subscriptions[id]
+	if !ok {
+		return "", apperr.NewNotFoundError(fmt.Sprintf("Subscription with ID: %s not found", id))
+	}
+	return o.GetBankAccountOwner(ctx, accountID)
+}
+
+type recvStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages []interface{}
+}
+
+func (s *recvStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *recvStream) RecvMsg(m interface{}) error {
+	proto.Merge(m.(proto.Message), s.messages[0].(proto.Message))
+	s.messages = s.messages[1:]
+	return nil
+}
+
+func TestAuthUnaryServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	var (
+		dimasAccount   = uuid.New()
+		ivansAccount   = uuid.New()
+		orphanAccount  = uuid.New()
+		ivansSub       = uuid.New()
+		missingAccount = uuid.New()
+		owners         = memoryAccountOwners{
+			accounts:      map[uuid.UUID]string{dimasAccount: "dima", ivansAccount: "ivan", orphanAccount: ""},
+			subscriptions: map[uuid.UUID]uuid.UUID{ivansSub: ivansAccount},
+		}
+		verifier, _ = NewTokenVerifier(testSecret, nil)
+		interceptor = AuthUnaryServerInterceptor(verifier, owners, []string{adminMethod})
+	)
+
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationHeader, token))
+	}
+	bearer := func(subject string, roles ...string) context.Context {
+		return withToken("Bearer " + signToken(t, jwt.SigningMethodHS256, testSecret, holderClaims(subject, roles...)))
+	}
+	accountRequest := func(id uuid.UUID) interface{} {
+		return &bank_accounts.GetBankAccountByIdRequest{Id: &bank_accounts.UUID{Value: id.String()}}
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		req      interface{}
+		code     codes.Code
+		calledBy string
+	}{
+		{
+			name: "Missing token",
+			ctx:  context.Background(),
+			req:  accountRequest(dimasAccount),
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "Not a bearer token",
+			ctx:  withToken("Basic ZGltYTpkaW1h"),
+			req:  accountRequest(dimasAccount),
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "Invalid token",
+			ctx:  withToken("Bearer nonsense"),
+			req:  accountRequest(dimasAccount),
+			code: codes.Unauthenticated,
+		},
+		{
+			name:     "Holder reads their account",
+			ctx:      bearer("dima"),
+			req:      accountRequest(dimasAccount),
+			calledBy: "dima",
+		},
+		{
+			name: "Holder reads another account",
+			ctx:  bearer("dima"),
+			req:  accountRequest(ivansAccount),
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "Holder reads an account without owner",
+			ctx:  bearer("dima"),
+			req:  accountRequest(orphanAccount),
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "Account not found",
+			ctx:  bearer("dima"),
+			req:  accountRequest(missingAccount),
+			code: codes.NotFound,
+		},
+		{
+			name: "Holder transfers from another account",
+			ctx:  bearer("dima"),
+			req: &bank_accounts.TransferRequest{
+				FromId: &bank_accounts.UUID{Value: ivansAccount.String()},
+				ToId:   &bank_accounts.UUID{Value: dimasAccount.String()},
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "Holder transfers to another account",
+			ctx:  bearer("dima"),
+			req: &bank_accounts.TransferRequest{
+				FromId: &bank_accounts.UUID{Value: dimasAccount.String()},
+				ToId:   &bank_accounts.UUID{Value: ivansAccount.String()},
+			},
+			calledBy: "dima",
+		},
+		{
+			name: "Holder cancels a subscription of another account",
+			ctx:  bearer("dima"),
+			req:  &subscriptions.CancelSubscriptionRequest{Id: &subscriptions.UUID{Value: ivansSub.String()}},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "Holder moves a subscription to another account",
+			ctx:  bearer("ivan"),
+			req: &subscriptions.UpdateSubscriptionRequest{
+				Id:           &subscriptions.UUID{Value: ivansSub.String()},
+				Subscription: &subscriptions.SubscriptionDto{AccountId: &subscriptions.UUID{Value: dimasAccount.String()}},
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name:     "Admin reads any account",
+			ctx:      bearer("root", RoleAdmin),
+			req:      accountRequest(ivansAccount),
+			calledBy: "root",
+		},
+		{
+			name:   "Holder calls an admin method",
+			ctx:    bearer("dima"),
+			method: adminMethod,
+			req:    &bank_accounts.ExportBankAccountsRequest{},
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:     "Admin calls an admin method",
+			ctx:      bearer("root", RoleAdmin),
+			method:   adminMethod,
+			req:      &bank_accounts.ExportBankAccountsRequest{},
+			calledBy: "root",
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calledBy string
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				principal, ok := PrincipalFromContext(ctx)
+				require.True(t, ok)
+				calledBy = principal.Subject
+				return req, nil
+			}
+			method := tc.method
+			if method == "" {
+				method = "/test.Service/Get"
+			}
+
+			_, err := interceptor(tc.ctx, tc.req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+
+			assert.Equal(t, tc.code, status.Code(toStatusError(err)))
+			assert.Equal(t, tc.calledBy, calledBy)
+		})
+	}
+}
+
+func TestAuthStreamServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	var (
+		dimasAccount = uuid.New()
+		ivansAccount = uuid.New()
+		owners       = memoryAccountOwners{accounts: map[uuid.UUID]string{dimasAccount: "dima", ivansAccount: "ivan"}}
+		verifier, _  = NewTokenVerifier(testSecret, nil)
+		interceptor  = AuthStreamServerInterceptor(verifier, owners, nil)
+		token        = signToken(t, jwt.SigningMethodHS256, testSecret, holderClaims("dima"))
+		ctx          = metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationHeader, "Bearer "+token))
+	)
+
+	stream := &recvStream{ctx: ctx, messages: []interface{}{
+		&bank_accounts.WatchBankAccountRequest{Id: &bank_accounts.UUID{Value: dimasAccount.String()}},
+		&bank_accounts.WatchBankAccountRequest{Id: &bank_accounts.UUID{Value: ivansAccount.String()}},
+	}}
+
+	err := interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/test.Service/Watch"}, func(srv interface{}, stream grpc.ServerStream) error {
+		principal, ok := PrincipalFromContext(stream.Context())
+		require.True(t, ok)
+		assert.Equal(t, "dima", principal.Subject)
+
+		var req bank_accounts.WatchBankAccountRequest
+		require.NoError(t, stream.RecvMsg(&req))
+		return stream.RecvMsg(&req)
+	})
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(toStatusError(err)))
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("secret")
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	require.NoError(t, err)
+	return token
+}
+
+func holderClaims(subject string, roles ...string) tokenClaims {
+	return tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Roles: roles,
+	}
+}
+
+func TestTokenVerifier_Verify(t *testing.T) {
+	t.Parallel()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	verifier, err := NewTokenVerifier(testSecret, &privateKey.PublicKey)
+	require.NoError(t, err)
+	rsaOnly, err := NewTokenVerifier(nil, &privateKey.PublicKey)
+	require.NoError(t, err)
+
+	expired := holderClaims("dima")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	noExpiry := holderClaims("dima")
+	noExpiry.ExpiresAt = nil
+
+	tests := []struct {
+		name      string
+		verifier  *TokenVerifier
+		token     string
+		principal *Principal
+	}{
+		{
+			name:      "HS256",
+			verifier:  verifier,
+			token:     signToken(t, jwt.SigningMethodHS256, testSecret, holderClaims("dima")),
+			principal: &Principal{Subject: "dima"},
+		},
+		{
+			name:      "RS256 with roles",
+			verifier:  verifier,
+			token:     signToken(t, jwt.SigningMethodRS256, privateKey, holderClaims("root", RoleAdmin)),
+			principal: &Principal{Subject: "root", Roles: []string{RoleAdmin}},
+		},
+		{
+			name:     "Wrong secret",
+			verifier: verifier,
+			token:    signToken(t, jwt.SigningMethodHS256, []byte("guess"), holderClaims("dima")),
+		},
+		{
+			name:     "Wrong RSA key",
+			verifier: verifier,
+			token:    signToken(t, jwt.SigningMethodRS256, otherKey, holderClaims("dima")),
+		},
+		{
+			name:     "HS256 is not accepted without a secret",
+			verifier: rsaOnly,
+			token:    signToken(t, jwt.SigningMethodHS256, testSecret, holderClaims("dima")),
+		},
+		{
+			name:     "Expired",
+			verifier: verifier,
+			token:    signToken(t, jwt.SigningMethodHS256, testSecret, expired),
+		},
+		{
+			name:     "Without expiration",
+			verifier: verifier,
+			token:    signToken(t, jwt.SigningMethodHS256, testSecret, noExpiry),
+		},
+		{
+			name:     "Without subject",
+			verifier: verifier,
+			token:    signToken(t, jwt.SigningMethodHS256, testSecret, holderClaims("")),
+		},
+		{
+			name:     "Unsigned",
+			verifier: verifier,
+			token:    signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, holderClaims("dima", RoleAdmin)),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			principal, err := tc.verifier.Verify(tc.token)
+
+			if tc.principal == nil {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.principal, principal)
+		})
+	}
+}
+
+func TestPrincipal_CanAccess(t *testing.T) {
+	t.Parallel()
+
+	holder := Principal{Subject: "dima"}
+	admin := Principal{Subject: "root", Roles: []string{RoleAdmin}}
+
+	assert.True(t, holder.CanAccess("dima"))
+	assert.False(t, holder.CanAccess("ivan"))
+	assert.False(t, Principal{}.CanAccess(""), "rows without an owner belong to nobody")
+	assert.True(t, admin.CanAccess("ivan"))
+	assert.True(t, admin.CanAccess(""))
+}
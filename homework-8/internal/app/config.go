@@ -1,45 +1,411 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	Server struct {
 		GatewayPort string `mapstructure:"gateway-port"`
 		GrpcPort    string `mapstructure:"grpc-port"`
+		// MetricsPort serves Prometheus metrics on /metrics.
+		MetricsPort string `mapstructure:"metrics-port"`
+		// ShutdownTimeout bounds the drain on SIGTERM. Calls still running
+		// after it are cancelled.
+		ShutdownTimeout time.Duration `mapstructure:"shutdown-timeout"`
+		// HealthCheckInterval is how often the gRPC health status is updated,
+		// each check of a dependency gives up after HealthCheckTimeout.
+		HealthCheckInterval time.Duration `mapstructure:"health-check-interval"`
+		HealthCheckTimeout  time.Duration `mapstructure:"health-check-timeout"`
 	} `mapstructure:"server"`
 	Database struct {
+		// DSN is a lib/pq connection string or postgres:// URL. When set it
+		// takes precedence over the settings below up to SSLMode. Like the
+		// password it is best set with BANK_DATABASE_DSN.
+		DSN      string `mapstructure:"dsn"`
+		Host     string `mapstructure:"host"`
+		Port     string `mapstructure:"port"`
 		Name     string `mapstructure:"name"`
 		Username string `mapstructure:"username"`
+		// Password is best set with BANK_DATABASE_PASSWORD rather than in the file.
 		Password string `mapstructure:"password"`
-		Port     string `mapstructure:"port"`
+		// SSLMode is a lib/pq sslmode such as disable or verify-full.
+		SSLMode string `mapstructure:"sslmode"`
+		// IsolationLevel is one of read-committed, repeatable-read and serializable.
+		IsolationLevel string `mapstructure:"isolation-level"`
+		TxMaxRetries   int    `mapstructure:"tx-max-retries"`
+		// MigrationsDir holds the goose migrations applied on start.
+		MigrationsDir string `mapstructure:"migrations-dir"`
 	} `mapstructure:"database"`
 	Kafka struct {
-		LogsTopicName string   `mapstructure:"logs-topic-name"`
-		Brokers       []string `mapstructure:"brokers"`
+		LogsTopicName   string   `mapstructure:"logs-topic-name"`
+		EventsTopicName string   `mapstructure:"events-topic-name"`
+		Brokers         []string `mapstructure:"brokers"`
 	} `mapstructure:"kafka"`
+	Outbox struct {
+		Interval  time.Duration `mapstructure:"interval"`
+		BatchSize int           `mapstructure:"batch-size"`
+	} `mapstructure:"outbox"`
+	Idempotency struct {
+		TTL time.Duration `mapstructure:"ttl"`
+		// LockTimeout is how long a request holds its key. It has to exceed
+		// the time the slowest request takes.
+		LockTimeout    time.Duration `mapstructure:"lock-timeout"`
+		PurgeInterval  time.Duration `mapstructure:"purge-interval"`
+		PurgeBatchSize int           `mapstructure:"purge-batch-size"`
+		Methods        []string      `mapstructure:"methods"`
+	} `mapstructure:"idempotency"`
+	Billing struct {
+		Interval       time.Duration `mapstructure:"interval"`
+		BatchSize      int           `mapstructure:"batch-size"`
+		RetryBaseDelay time.Duration `mapstructure:"retry-base-delay"`
+		RetryMaxDelay  time.Duration `mapstructure:"retry-max-delay"`
+	} `mapstructure:"billing"`
+	ExchangeRates struct {
+		// File is a JSON file with rates such as {"USD/RUB": "92.50"}.
+		File string `mapstructure:"file"`
+	} `mapstructure:"exchange-rates"`
+	Auth struct {
+		// HMACSecret is the shared secret of HS256 tokens, usually set with
+		// BANK_AUTH_HMAC_SECRET. HMACSecretFile holds it instead, e.g. when it
+		// is mounted as a file.
+		HMACSecret     string `mapstructure:"hmac-secret"`
+		HMACSecretFile string `mapstructure:"hmac-secret-file"`
+		// RSAPublicKeyFile is the PEM encoded key RS256 tokens are checked with.
+		RSAPublicKeyFile string   `mapstructure:"rsa-public-key-file"`
+		AdminMethods     []string `mapstructure:"admin-methods"`
+	} `mapstructure:"auth"`
+	// RateLimit limits the calls from every client address before they are
+	// authenticated. Subjects limits authenticated callers wherever they call
+	// from; it is off unless it has a rule with a rate.
+	RateLimit struct {
+		// Default applies to every method without a rule of its own.
+		Default  RateLimitRule   `mapstructure:"default"`
+		Methods  []RateLimitRule `mapstructure:"methods"`
+		Subjects struct {
+			Default RateLimitRule   `mapstructure:"default"`
+			Methods []RateLimitRule `mapstructure:"methods"`
+		} `mapstructure:"subjects"`
+	} `mapstructure:"rate-limit"`
+	Cache struct {
+		// Backend keeps accounts read by ID in memory, in redis or nowhere: none.
+		Backend string `mapstructure:"backend"`
+		// TTL bounds how long an account is served from the cache if its
+		// change could not be dropped from it.
+		TTL   time.Duration `mapstructure:"ttl"`
+		Redis struct {
+			Address string `mapstructure:"address"`
+			// Password is best set with BANK_CACHE_REDIS_PASSWORD.
+			Password string `mapstructure:"password"`
+			DB       int    `mapstructure:"db"`
+			// KeyPrefix sets the keys of the service apart in a shared server.
+			KeyPrefix string `mapstructure:"key-prefix"`
+		} `mapstructure:"redis"`
+	} `mapstructure:"cache"`
+	Logging struct {
+		// Level is a zap level such as debug, info or error.
+		Level string `mapstructure:"level"`
+		// Encoding is json or console.
+		Encoding string `mapstructure:"encoding"`
+	} `mapstructure:"logging"`
 }
 
-func InitConfig() (*Config, error) {
-	currentDir, err := os.Getwd()
-	if err != nil {
+// RateLimitRule is a token bucket of every client of a method. A client may
+// make Burst calls at once and Rate calls per second after that. A zero rate
+// leaves the method unlimited.
+type RateLimitRule struct {
+	Method string  `mapstructure:"method"`
+	Rate   float64 `mapstructure:"rate"`
+	Burst  int     `mapstructure:"burst"`
+}
+
+// EnvPrefix starts the environment variables overriding the config. The rest
+// of the name is the key in upper case with dots and dashes replaced by
+// underscores, e.g. BANK_DATABASE_PASSWORD for database.password.
+const EnvPrefix = "BANK"
+
+// DefaultConfigFile is read, if it exists, when neither --config nor
+// BANK_CONFIG name a config file. Like the paths given by the defaults, the
+// environment and the command line it is relative to the working directory;
+// relative paths in a config file are relative to the file.
+const DefaultConfigFile = "configs/config.yaml"
+
+var envKeyReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// flagKeys are the settings that can be given on the command line. Secrets
+// are left out on purpose, the arguments of a process are visible to anyone
+// on the host.
+var flagKeys = map[string]string{
+	"server.grpc-port":    "address of the gRPC server",
+	"server.gateway-port": "address of the HTTP gateway",
+	"server.metrics-port": "address of the metrics server",
+	"database.host":       "Postgres host",
+	"database.port":       "Postgres port",
+	"database.name":       "Postgres database",
+	"database.username":   "Postgres user",
+	"logging.level":       "log level: debug, info, warn or error",
+}
+
+// loaded is the config InitConfig read last, WatchConfig reloads it.
+var loaded *viper.Viper
+
+// InitConfig reads the config from, in increasing precedence, the defaults,
+// the config file, environment variables and the command line args.
+func InitConfig(args []string) (*Config, error) {
+	v := viper.New()
+	setDefaults(v)
+
+	flags := pflag.NewFlagSet("app", pflag.ContinueOnError)
+	configFile := flags.String("config", "", "path to the config file, "+DefaultConfigFile+" by default")
+	for key, usage := range flagKeys {
+		flags.String(key, "", usage)
+	}
+	if err := flags.Parse(args); err != nil {
 		return nil, err
 	}
-	configPath := filepath.Join(currentDir, "configs", "config.yaml")
-	viper.SetConfigFile(configPath)
-	viper.SetConfigType("yaml")
+	for key := range flagKeys {
+		if err := v.BindPFlag(key, flags.Lookup(key)); err != nil {
+			return nil, err
+		}
+	}
+
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(envKeyReplacer)
+	v.AutomaticEnv()
+
+	path, required := *configFile, true
+	if path == "" {
+		path = os.Getenv(EnvPrefix + "_CONFIG")
+	}
+	if path == "" {
+		path, required = DefaultConfigFile, false
+	}
+	if _, err := os.Stat(path); required || err == nil {
+		v.SetConfigFile(path)
+		v.SetConfigType("yaml")
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("cannot read config %s: %w", path, err)
+		}
+	}
 
-	if err := viper.ReadInConfig(); err != nil {
+	config, err := unmarshalConfig(v)
+	if err != nil {
 		return nil, err
 	}
+	loaded = v
+	return config, nil
+}
 
+func unmarshalConfig(v *viper.Viper) (*Config, error) {
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	if err := v.Unmarshal(&config); err != nil {
 		return nil, err
 	}
-
+	resolvePaths(v, &config)
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
 	return &config, nil
 }
+
+// resolvePaths makes the relative paths taken from the config file relative to
+// its directory, so that the service can be started from any directory.
+func resolvePaths(v *viper.Viper, config *Config) {
+	if v.ConfigFileUsed() == "" {
+		return
+	}
+	dir := filepath.Dir(v.ConfigFileUsed())
+	paths := map[string]*string{
+		"database.migrations-dir":  &config.Database.MigrationsDir,
+		"exchange-rates.file":      &config.ExchangeRates.File,
+		"auth.hmac-secret-file":    &config.Auth.HMACSecretFile,
+		"auth.rsa-public-key-file": &config.Auth.RSAPublicKeyFile,
+	}
+	for key, path := range paths {
+		if !v.InConfig(key) || isSetInEnv(key) || *path == "" || filepath.IsAbs(*path) {
+			continue
+		}
+		*path = filepath.Join(dir, *path)
+	}
+}
+
+func isSetInEnv(key string) bool {
+	_, ok := os.LookupEnv(EnvPrefix + "_" + strings.ToUpper(envKeyReplacer.Replace(key)))
+	return ok
+}
+
+// setDefaults registers every key, which also lets environment variables set
+// keys missing from the config file.
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("server.gateway-port", ":9090")
+	v.SetDefault("server.grpc-port", ":50051")
+	v.SetDefault("server.metrics-port", ":9100")
+	v.SetDefault("server.shutdown-timeout", 30*time.Second)
+	v.SetDefault("server.health-check-interval", 10*time.Second)
+	v.SetDefault("server.health-check-timeout", 2*time.Second)
+
+	v.SetDefault("database.dsn", "")
+	v.SetDefault("database.host", "localhost")
+	v.SetDefault("database.port", "5432")
+	v.SetDefault("database.name", "")
+	v.SetDefault("database.username", "")
+	v.SetDefault("database.password", "")
+	v.SetDefault("database.sslmode", "disable")
+	v.SetDefault("database.isolation-level", "read-committed")
+	v.SetDefault("database.tx-max-retries", 3)
+	v.SetDefault("database.migrations-dir", "db/migrations")
+
+	v.SetDefault("kafka.logs-topic-name", "logs")
+	v.SetDefault("kafka.events-topic-name", "bank-events")
+	v.SetDefault("kafka.brokers", []string{})
+
+	v.SetDefault("outbox.interval", time.Second)
+	v.SetDefault("outbox.batch-size", 100)
+
+	v.SetDefault("idempotency.ttl", 24*time.Hour)
+	v.SetDefault("idempotency.lock-timeout", time.Minute)
+	v.SetDefault("idempotency.purge-interval", time.Hour)
+	v.SetDefault("idempotency.purge-batch-size", 1000)
+	v.SetDefault("idempotency.methods", []string{})
+
+	v.SetDefault("billing.interval", time.Minute)
+	v.SetDefault("billing.batch-size", 100)
+	v.SetDefault("billing.retry-base-delay", time.Hour)
+	v.SetDefault("billing.retry-max-delay", 24*time.Hour)
+
+	v.SetDefault("exchange-rates.file", "configs/rates.json")
+
+	v.SetDefault("auth.hmac-secret", "")
+	v.SetDefault("auth.hmac-secret-file", "")
+	v.SetDefault("auth.rsa-public-key-file", "")
+	v.SetDefault("auth.admin-methods", []string{})
+
+	v.SetDefault("rate-limit.default.rate", 0)
+	v.SetDefault("rate-limit.default.burst", 0)
+	v.SetDefault("rate-limit.methods", []RateLimitRule{})
+	v.SetDefault("rate-limit.subjects.default.rate", 0)
+	v.SetDefault("rate-limit.subjects.default.burst", 0)
+	v.SetDefault("rate-limit.subjects.methods", []RateLimitRule{})
+
+	v.SetDefault("cache.backend", "memory")
+	v.SetDefault("cache.ttl", 30*time.Second)
+	v.SetDefault("cache.redis.address", "localhost:6379")
+	v.SetDefault("cache.redis.password", "")
+	v.SetDefault("cache.redis.db", 0)
+	v.SetDefault("cache.redis.key-prefix", "bank:")
+
+	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.encoding", "json")
+}
+
+// Validate reports every missing or malformed setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	required := func(key string, value string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	}
+	positive := func(key string, value int64) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", key))
+		}
+	}
+
+	required("server.grpc-port", c.Server.GrpcPort)
+	required("server.gateway-port", c.Server.GatewayPort)
+	required("server.metrics-port", c.Server.MetricsPort)
+	positive("server.shutdown-timeout", int64(c.Server.ShutdownTimeout))
+	positive("server.health-check-interval", int64(c.Server.HealthCheckInterval))
+	positive("server.health-check-timeout", int64(c.Server.HealthCheckTimeout))
+
+	if c.Database.DSN == "" {
+		required("database.host", c.Database.Host)
+		required("database.port", c.Database.Port)
+		required("database.name", c.Database.Name)
+		required("database.username", c.Database.Username)
+	}
+	if c.Database.TxMaxRetries < 0 {
+		errs = append(errs, errors.New("database.tx-max-retries must not be negative"))
+	}
+	required("database.migrations-dir", c.Database.MigrationsDir)
+
+	if len(c.Kafka.Brokers) == 0 {
+		errs = append(errs, errors.New("kafka.brokers needs at least one broker"))
+	}
+	required("kafka.events-topic-name", c.Kafka.EventsTopicName)
+
+	positive("idempotency.ttl", int64(c.Idempotency.TTL))
+	positive("idempotency.lock-timeout", int64(c.Idempotency.LockTimeout))
+	positive("idempotency.purge-interval", int64(c.Idempotency.PurgeInterval))
+	positive("idempotency.purge-batch-size", int64(c.Idempotency.PurgeBatchSize))
+
+	positive("outbox.interval", int64(c.Outbox.Interval))
+	positive("outbox.batch-size", int64(c.Outbox.BatchSize))
+	positive("billing.interval", int64(c.Billing.Interval))
+	positive("billing.batch-size", int64(c.Billing.BatchSize))
+	if c.Billing.RetryMaxDelay < c.Billing.RetryBaseDelay {
+		errs = append(errs, errors.New("billing.retry-max-delay must not be less than billing.retry-base-delay"))
+	}
+
+	required("exchange-rates.file", c.ExchangeRates.File)
+	if c.Auth.HMACSecret == "" && c.Auth.HMACSecretFile == "" && c.Auth.RSAPublicKeyFile == "" {
+		errs = append(errs, errors.New("auth.hmac-secret, auth.hmac-secret-file or auth.rsa-public-key-file is required"))
+	}
+	if c.Auth.HMACSecret != "" && c.Auth.HMACSecretFile != "" {
+		errs = append(errs, errors.New("auth.hmac-secret and auth.hmac-secret-file are exclusive"))
+	}
+
+	rules := append([]RateLimitRule{c.RateLimit.Default, c.RateLimit.Subjects.Default}, c.RateLimit.Methods...)
+	for _, rule := range append(rules, c.RateLimit.Subjects.Methods...) {
+		if rule.Rate < 0 || rule.Burst < 0 {
+			errs = append(errs, fmt.Errorf("rate-limit of %q must not be negative", rule.Method))
+		}
+	}
+
+	switch c.Cache.Backend {
+	case "none":
+	case "memory":
+		positive("cache.ttl", int64(c.Cache.TTL))
+	case "redis":
+		positive("cache.ttl", int64(c.Cache.TTL))
+		required("cache.redis.address", c.Cache.Redis.Address)
+	default:
+		errs = append(errs, fmt.Errorf("cache.backend %q is none, memory or redis", c.Cache.Backend))
+	}
+
+	if c.Logging.Encoding != "json" && c.Logging.Encoding != "console" {
+		errs = append(errs, fmt.Errorf("logging.encoding %q is neither json nor console", c.Logging.Encoding))
+	}
+
+	return errors.Join(errs...)
+}
+
+// WatchConfig calls onChange with the new config every time the config file
+// changes. It is up to onChange which settings are applied without a restart.
+// An invalid config is logged and skipped.
+func WatchConfig(onChange func(config *Config)) {
+	v := loaded
+	if v == nil || v.ConfigFileUsed() == "" {
+		return
+	}
+	v.OnConfigChange(func(event fsnotify.Event) {
+		config, err := unmarshalConfig(v)
+		if err != nil {
+			logging.Errorf(context.Background(), "cannot reload config %s: %s", event.Name, err)
+			return
+		}
+		onChange(config)
+	})
+	v.WatchConfig()
+}
@@ -2,24 +2,112 @@ package app
 
 import (
 	"context"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
 	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"strings"
 )
 
-func ContextPropagationUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+// RequestIDHeader identifies a request in the logs. It is taken from the
+// incoming metadata when the caller sets it and returned in the response
+// header either way.
+const RequestIDHeader = "x-request-id"
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request ID stored by the context
+// propagation interceptors.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// ContextPropagationUnaryServerInterceptor starts the span of the call and
+// stores the request ID and a child of logger in the context. The logger is
+// tagged with the request ID, the method, the peer and the trace ID.
+func ContextPropagationUnaryServerInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		logger, _ := zap.NewProduction()
-		defer logger.Sync()
+		ctx, finish := propagateContext(ctx, logger, info.FullMethod)
+		defer finish()
 
-		logging.SetGlobal(logger)
+		return handler(ctx, req)
+	}
+}
 
-		ctx = logging.ToContext(ctx, logger)
+// ContextPropagationStreamServerInterceptor is
+// ContextPropagationUnaryServerInterceptor for streams.
+func ContextPropagationStreamServerInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, finish := propagateContext(stream.Context(), logger, info.FullMethod)
+		defer finish()
 
-		resp, err := handler(ctx, req)
-		if err != nil {
+		return handler(srv, &contextStream{
+			ServerStream: stream,
+			ctx:          ctx,
+		})
+	}
+}
+
+func propagateContext(ctx context.Context, logger *zap.Logger, method string) (context.Context, func()) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var parent opentracing.SpanContext
+	if md != nil {
+		parent, _ = opentracing.GlobalTracer().Extract(opentracing.TextMap, metadataCarrier(md))
+	}
+	span := opentracing.GlobalTracer().StartSpan(method, opentracing.ChildOf(parent))
+	ctx = opentracing.ContextWithSpan(ctx, span)
 
+	requestID := ""
+	if values := md.Get(RequestIDHeader); len(values) > 0 {
+		requestID = values[0]
+	}
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	// the header is best effort, the request ID is in the logs regardless
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, requestID))
+	ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+
+	fields := []zap.Field{
+		zap.String("request_id", requestID),
+		zap.String("method", method),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	if spanContext, ok := span.Context().(jaeger.SpanContext); ok {
+		fields = append(fields, zap.String("trace_id", spanContext.TraceID().String()))
+	}
+
+	return logging.ToContext(ctx, logger.With(fields...)), span.Finish
+}
+
+// metadataCarrier reads the trace context of the caller from gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for key, values := range c {
+		for _, value := range values {
+			if err := handler(strings.ToLower(key), value); err != nil {
+				return err
+			}
 		}
-		return resp, err
 	}
+	return nil
+}
+
+// contextStream replaces the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
 }
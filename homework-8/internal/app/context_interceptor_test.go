@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/jaeger-client-go"
+	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"testing"
+)
+
+// The test replaces the global tracer and does not run in parallel.
+func TestContextPropagationUnaryServerInterceptor(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	previous := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(previous)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	interceptor := ContextPropagationUnaryServerInterceptor(zap.New(core))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+	var requestID string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		logging.Infof(ctx, "handled")
+		requestID = RequestIDFromContext(ctx)
+		return req, nil
+	}
+	remote := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 40000}})
+
+	t.Run("Request ID and trace of the caller", func(t *testing.T) {
+		parent := tracer.StartSpan("client")
+		md := metadata.Pairs(RequestIDHeader, "req-1")
+		carrier := opentracing.TextMapCarrier{}
+		require.NoError(t, tracer.Inject(parent.Context(), opentracing.TextMap, carrier))
+		for key, value := range carrier {
+			md.Append(key, value)
+		}
+
+		_, err := interceptor(metadata.NewIncomingContext(remote, md), "req", info, handler)
+		require.NoError(t, err)
+
+		entries := logs.TakeAll()
+		require.Len(t, entries, 1)
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "req-1", fields["request_id"])
+		assert.Equal(t, "req-1", requestID)
+		assert.Equal(t, "/test.Service/Get", fields["method"])
+		assert.Equal(t, "10.0.0.7:40000", fields["peer"])
+		assert.Equal(t, parent.Context().(jaeger.SpanContext).TraceID().String(), fields["trace_id"])
+	})
+
+	t.Run("Generated request ID", func(t *testing.T) {
+		_, err := interceptor(remote, "req", info, handler)
+		require.NoError(t, err)
+		_, err = interceptor(remote, "req", info, handler)
+		require.NoError(t, err)
+
+		entries := logs.TakeAll()
+		require.Len(t, entries, 2)
+		first, second := entries[0].ContextMap(), entries[1].ContextMap()
+		assert.NotEmpty(t, first["request_id"])
+		assert.NotEqual(t, first["request_id"], second["request_id"])
+		assert.NotEqual(t, first["trace_id"], second["trace_id"], "calls without a caller trace start their own")
+	})
+}
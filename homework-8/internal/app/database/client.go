@@ -4,16 +4,33 @@ import (
 	"database/sql"
 	"fmt"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
+	"strings"
 )
 
+// ConnectionString builds the lib/pq connection string from the config, unless
+// the config has one of its own.
+func ConnectionString(config *app.Config) string {
+	if config.Database.DSN != "" {
+		return config.Database.DSN
+	}
+	return strings.Join([]string{
+		dsnParam("host", config.Database.Host),
+		dsnParam("port", config.Database.Port),
+		dsnParam("dbname", config.Database.Name),
+		dsnParam("user", config.Database.Username),
+		dsnParam("password", config.Database.Password),
+		dsnParam("sslmode", config.Database.SSLMode),
+	}, " ")
+}
+
+// dsnParam quotes value, so that a password may contain spaces and quotes.
+func dsnParam(key string, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return fmt.Sprintf("%s='%s'", key, value)
+}
+
 func InitDB(config *app.Config) (Database, error) {
-	connectionString := fmt.Sprintf("user=%s password=%s dbname=%s port=%s sslmode=disable",
-		config.Database.Username,
-		config.Database.Password,
-		config.Database.Name,
-		config.Database.Port,
-	)
-	dbInstance, err := sql.Open("postgres", connectionString)
+	dbInstance, err := sql.Open("postgres", ConnectionString(config))
 	if err != nil {
 		panic(err)
 	}
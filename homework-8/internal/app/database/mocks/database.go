@@ -5,6 +5,7 @@
 package mock_database
 
 import (
+	context "context"
 	sql "database/sql"
 	reflect "reflect"
 
@@ -35,18 +36,18 @@ func (m *MockDatabase) EXPECT() *MockDatabaseMockRecorder {
 }
 
 // BeginTx mocks base method.
-func (m *MockDatabase) BeginTx() (*sql.Tx, error) {
+func (m *MockDatabase) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "BeginTx")
+	ret := m.ctrl.Call(m, "BeginTx", ctx, opts)
 	ret0, _ := ret[0].(*sql.Tx)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // BeginTx indicates an expected call of BeginTx.
-func (mr *MockDatabaseMockRecorder) BeginTx() *gomock.Call {
+func (mr *MockDatabaseMockRecorder) BeginTx(ctx, opts interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginTx", reflect.TypeOf((*MockDatabase)(nil).BeginTx))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BeginTx", reflect.TypeOf((*MockDatabase)(nil).BeginTx), ctx, opts)
 }
 
 // Close mocks base method.
@@ -63,75 +64,117 @@ func (mr *MockDatabaseMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockDatabase)(nil).Close))
 }
 
-// Execute mocks base method.
-func (m *MockDatabase) Execute(query string, args ...interface{}) (sql.Result, error) {
+// CopyInContext mocks base method.
+func (m *MockDatabase) CopyInContext(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{query}
+	ret := m.ctrl.Call(m, "CopyInContext", ctx, table, columns, rows)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CopyInContext indicates an expected call of CopyInContext.
+func (mr *MockDatabaseMockRecorder) CopyInContext(ctx, table, columns, rows interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyInContext", reflect.TypeOf((*MockDatabase)(nil).CopyInContext), ctx, table, columns, rows)
+}
+
+// ExecuteContext mocks base method.
+func (m *MockDatabase) ExecuteContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "Execute", varargs...)
+	ret := m.ctrl.Call(m, "ExecuteContext", varargs...)
 	ret0, _ := ret[0].(sql.Result)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Execute indicates an expected call of Execute.
-func (mr *MockDatabaseMockRecorder) Execute(query interface{}, args ...interface{}) *gomock.Call {
+// ExecuteContext indicates an expected call of ExecuteContext.
+func (mr *MockDatabaseMockRecorder) ExecuteContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Execute", reflect.TypeOf((*MockDatabase)(nil).Execute), varargs...)
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecuteContext", reflect.TypeOf((*MockDatabase)(nil).ExecuteContext), varargs...)
 }
 
-// QueryRow mocks base method.
-func (m *MockDatabase) QueryRow(query string, args ...interface{}) *sql.Row {
+// PingContext mocks base method.
+func (m *MockDatabase) PingContext(ctx context.Context) error {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{query}
+	ret := m.ctrl.Call(m, "PingContext", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PingContext indicates an expected call of PingContext.
+func (mr *MockDatabaseMockRecorder) PingContext(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PingContext", reflect.TypeOf((*MockDatabase)(nil).PingContext), ctx)
+}
+
+// QueryRowContext mocks base method.
+func (m *MockDatabase) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "QueryRow", varargs...)
+	ret := m.ctrl.Call(m, "QueryRowContext", varargs...)
 	ret0, _ := ret[0].(*sql.Row)
 	return ret0
 }
 
-// QueryRow indicates an expected call of QueryRow.
-func (mr *MockDatabaseMockRecorder) QueryRow(query interface{}, args ...interface{}) *gomock.Call {
+// QueryRowContext indicates an expected call of QueryRowContext.
+func (mr *MockDatabaseMockRecorder) QueryRowContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRow", reflect.TypeOf((*MockDatabase)(nil).QueryRow), varargs...)
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRowContext", reflect.TypeOf((*MockDatabase)(nil).QueryRowContext), varargs...)
 }
 
-// QueryRows mocks base method.
-func (m *MockDatabase) QueryRows(query string, args ...interface{}) (*sql.Rows, error) {
+// QueryRowsContext mocks base method.
+func (m *MockDatabase) QueryRowsContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{query}
+	varargs := []interface{}{ctx, query}
 	for _, a := range args {
 		varargs = append(varargs, a)
 	}
-	ret := m.ctrl.Call(m, "QueryRows", varargs...)
+	ret := m.ctrl.Call(m, "QueryRowsContext", varargs...)
 	ret0, _ := ret[0].(*sql.Rows)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// QueryRows indicates an expected call of QueryRows.
-func (mr *MockDatabaseMockRecorder) QueryRows(query interface{}, args ...interface{}) *gomock.Call {
+// QueryRowsContext indicates an expected call of QueryRowsContext.
+func (mr *MockDatabaseMockRecorder) QueryRowsContext(ctx, query interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRowsContext", reflect.TypeOf((*MockDatabase)(nil).QueryRowsContext), varargs...)
+}
+
+// Stats mocks base method.
+func (m *MockDatabase) Stats() sql.DBStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(sql.DBStats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockDatabaseMockRecorder) Stats() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{query}, args...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryRows", reflect.TypeOf((*MockDatabase)(nil).QueryRows), varargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockDatabase)(nil).Stats))
 }
 
 // UpMigrations mocks base method.
-func (m *MockDatabase) UpMigrations() error {
+func (m *MockDatabase) UpMigrations(dir string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpMigrations")
+	ret := m.ctrl.Call(m, "UpMigrations", dir)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpMigrations indicates an expected call of UpMigrations.
-func (mr *MockDatabaseMockRecorder) UpMigrations() *gomock.Call {
+func (mr *MockDatabaseMockRecorder) UpMigrations(dir interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpMigrations", reflect.TypeOf((*MockDatabase)(nil).UpMigrations))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpMigrations", reflect.TypeOf((*MockDatabase)(nil).UpMigrations), dir)
 }
@@ -3,65 +3,139 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/lib/pq"
 	"github.com/pressly/goose/v3"
 )
 
 type Database interface {
-	QueryRow(query string, args ...interface{}) *sql.Row
-	QueryRows(query string, args ...interface{}) (*sql.Rows, error)
-	Execute(query string, args ...interface{}) (sql.Result, error)
-	BeginTx() (*sql.Tx, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryRowsContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecuteContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	CopyInContext(ctx context.Context, table string, columns []string, rows [][]interface{}) error
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	PingContext(ctx context.Context) error
 	Close() error
-	UpMigrations() error
+	Stats() sql.DBStats
+	UpMigrations(dir string) error
 }
 
 type SQLDatabase struct {
 	db *sql.DB
 }
 
-func (s *SQLDatabase) QueryRow(query string, args ...interface{}) *sql.Row {
-	return s.db.QueryRow(query, args...)
+// QueryRowContext, QueryRowsContext and ExecuteContext run inside the
+// transaction of a TxManager when ctx carries one.
+func (s *SQLDatabase) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if state, ok := txFromContext(ctx); ok {
+		row := state.tx.QueryRowContext(ctx, query, args...)
+		state.track(row.Err())
+		return row
+	}
+	return s.db.QueryRowContext(ctx, query, args...)
 }
 
-func (s *SQLDatabase) QueryRows(query string, args ...interface{}) (*sql.Rows, error) {
-	rows, err := s.db.Query(query, args...)
+func (s *SQLDatabase) QueryRowsContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	if state, ok := txFromContext(ctx); ok {
+		rows, err = state.tx.QueryContext(ctx, query, args...)
+		state.track(err)
+	} else {
+		rows, err = s.db.QueryContext(ctx, query, args...)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return rows, nil
 }
 
-func (s *SQLDatabase) Execute(query string, args ...interface{}) (sql.Result, error) {
-	result, err := s.db.Exec(query, args...)
+func (s *SQLDatabase) ExecuteContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var (
+		result sql.Result
+		err    error
+	)
+	if state, ok := txFromContext(ctx); ok {
+		result, err = state.tx.ExecContext(ctx, query, args...)
+		state.track(err)
+	} else {
+		result, err = s.db.ExecContext(ctx, query, args...)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func (s *SQLDatabase) BeginTx() (*sql.Tx, error) {
-	tx, err := s.db.Begin()
+// CopyInContext loads rows into table with COPY, which is much faster than
+// inserting them one by one. lib/pq supports COPY only inside a transaction,
+// so ctx must carry one of a TxManager.
+func (s *SQLDatabase) CopyInContext(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	state, ok := txFromContext(ctx)
+	if !ok {
+		return errors.New("copy requires a transaction")
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	stmt, err := state.tx.PrepareContext(ctx, pq.CopyIn(table, columns...))
+	if err != nil {
+		state.track(err)
+		return err
+	}
+	for _, row := range rows {
+		if _, err = stmt.ExecContext(ctx, row...); err != nil {
+			stmt.Close()
+			state.track(err)
+			return err
+		}
+	}
+	// the final call without arguments flushes the buffered rows
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		state.track(err)
+		return err
+	}
+	return stmt.Close()
+}
+
+func (s *SQLDatabase) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
 	return tx, nil
 }
 
-func (s *SQLDatabase) UpMigrations() error {
+// UpMigrations applies the goose migrations in dir.
+func (s *SQLDatabase) UpMigrations(dir string) error {
 	if err := goose.SetDialect("postgres"); err != nil {
 		return err
 	}
 
-	if err := goose.Up(s.db, "db/migrations"); err != nil {
-		fmt.Println(err)
-		return err
+	if err := goose.Up(s.db, dir); err != nil {
+		return fmt.Errorf("cannot apply migrations from %s: %w", dir, err)
 	}
 
 	return nil
 }
 
+// PingContext checks that the database is reachable.
+func (s *SQLDatabase) PingContext(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 func (s *SQLDatabase) Close() error {
 	return s.db.Close()
 }
+
+// Stats returns the connection pool statistics for metrics.
+func (s *SQLDatabase) Stats() sql.DBStats {
+	return s.db.Stats()
+}
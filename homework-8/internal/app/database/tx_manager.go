@@ -0,0 +1,159 @@
+//go:generate mockgen -source ./tx_manager.go -destination=./mocks/tx_manager.go -package=mock_database
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// serializationFailure is the SQLSTATE Postgres returns when a transaction
+// cannot be serialized with the concurrent ones and has to be retried.
+const serializationFailure = "40001"
+
+// retryDelay is multiplied by the attempt number between retries.
+const retryDelay = 10 * time.Millisecond
+
+// TxManager runs functions in a transaction that is kept in the context.
+// Database methods called with that context run inside the transaction, so
+// every repository used by fn takes part in it without knowing about it.
+type TxManager interface {
+	// Do runs fn with the default isolation level of the manager.
+	Do(ctx context.Context, fn func(ctx context.Context) error) error
+	// DoWithIsolation runs fn with the given isolation level. When ctx already
+	// carries a transaction, fn joins it and the level is ignored.
+	DoWithIsolation(ctx context.Context, isolation sql.IsolationLevel, fn func(ctx context.Context) error) error
+}
+
+type txKey struct{}
+
+type txState struct {
+	tx *sql.Tx
+	// serializationFailed is set when a statement of the transaction failed
+	// with 40001. Repositories map such errors to application errors, so the
+	// manager cannot see the code in the error returned by fn.
+	serializationFailed bool
+	// afterCommit runs once the transaction is committed.
+	afterCommit []func()
+}
+
+func (s *txState) track(err error) {
+	if IsSerializationFailure(err) {
+		s.serializationFailed = true
+	}
+}
+
+func txFromContext(ctx context.Context) (*txState, bool) {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	return state, ok
+}
+
+// TxFromContext returns the transaction started by a TxManager, if any.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	state, ok := txFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return state.tx, true
+}
+
+// AfterCommit runs fn once the transaction of ctx is committed, or at once
+// when ctx carries no transaction. fn is dropped when the transaction is rolled
+// back, so it only sees changes that other connections can read too.
+func AfterCommit(ctx context.Context, fn func()) {
+	state, ok := txFromContext(ctx)
+	if !ok {
+		fn()
+		return
+	}
+	state.afterCommit = append(state.afterCommit, fn)
+}
+
+// IsSerializationFailure reports whether err is a Postgres serialization failure.
+func IsSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == serializationFailure
+}
+
+type SQLTxManager struct {
+	db         Database
+	isolation  sql.IsolationLevel
+	maxRetries int
+}
+
+// NewTxManager creates a manager that starts transactions with the given
+// isolation level and retries serialization failures up to maxRetries times.
+func NewTxManager(db Database, isolation sql.IsolationLevel, maxRetries int) *SQLTxManager {
+	return &SQLTxManager{
+		db:         db,
+		isolation:  isolation,
+		maxRetries: maxRetries,
+	}
+}
+
+func (m *SQLTxManager) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return m.DoWithIsolation(ctx, m.isolation, fn)
+}
+
+func (m *SQLTxManager) DoWithIsolation(ctx context.Context, isolation sql.IsolationLevel, fn func(ctx context.Context) error) error {
+	if _, ok := txFromContext(ctx); ok {
+		return fn(ctx)
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := m.run(ctx, isolation, fn)
+		if err == nil || !retry || attempt > m.maxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * retryDelay):
+		}
+	}
+}
+
+func (m *SQLTxManager) run(ctx context.Context, isolation sql.IsolationLevel, fn func(ctx context.Context) error) (bool, error) {
+	tx, err := m.db.BeginTx(ctx, &sql.TxOptions{Isolation: isolation})
+	if err != nil {
+		return false, err
+	}
+
+	state := &txState{tx: tx}
+	if err := fn(context.WithValue(ctx, txKey{}, state)); err != nil {
+		tx.Rollback()
+		return state.serializationFailed || IsSerializationFailure(err), err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return IsSerializationFailure(err), err
+	}
+
+	for _, fn := range state.afterCommit {
+		fn()
+	}
+	return false, nil
+}
+
+// ParseIsolationLevel maps the isolation level names used in the config.
+// An empty name selects the database default.
+func ParseIsolationLevel(name string) (sql.IsolationLevel, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "-", " ")) {
+	case "", "default":
+		return sql.LevelDefault, nil
+	case "read committed":
+		return sql.LevelReadCommitted, nil
+	case "repeatable read":
+		return sql.LevelRepeatableRead, nil
+	case "serializable":
+		return sql.LevelSerializable, nil
+	default:
+		return sql.LevelDefault, fmt.Errorf("unknown isolation level %q", name)
+	}
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSQLTxManager_Do(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx                  = context.Background()
+		errDomain            = errors.New("domain error")
+		serializationFailure = &pq.Error{Code: "40001"}
+	)
+
+	tests := []struct {
+		name          string
+		maxRetries    int
+		mockSQL       func(mock sqlmock.Sqlmock)
+		fn            func(db Database) func(ctx context.Context) error
+		expectedCalls int
+		expectedError error
+	}{
+		{
+			name:       "Commit",
+			maxRetries: 3,
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE bank_account`).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			fn: func(db Database) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					_, err := db.ExecuteContext(ctx, "UPDATE bank_account SET balance = 0")
+					return err
+				}
+			},
+			expectedCalls: 1,
+		},
+		{
+			name:       "Rollback on error",
+			maxRetries: 3,
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			},
+			fn: func(db Database) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return errDomain
+				}
+			},
+			expectedCalls: 1,
+			expectedError: errDomain,
+		},
+		{
+			name:       "Retry serialization failure of a statement",
+			maxRetries: 3,
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE bank_account`).WillReturnError(serializationFailure)
+				mock.ExpectRollback()
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE bank_account`).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			fn: func(db Database) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					if _, err := db.ExecuteContext(ctx, "UPDATE bank_account SET balance = 0"); err != nil {
+						// repositories hide the driver error behind an application error
+						return errDomain
+					}
+					return nil
+				}
+			},
+			expectedCalls: 2,
+		},
+		{
+			name:       "Retry serialization failure on commit",
+			maxRetries: 3,
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectCommit().WillReturnError(serializationFailure)
+				mock.ExpectBegin()
+				mock.ExpectCommit()
+			},
+			fn: func(db Database) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return nil
+				}
+			},
+			expectedCalls: 2,
+		},
+		{
+			name:       "Give up after max retries",
+			maxRetries: 1,
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectCommit().WillReturnError(serializationFailure)
+				mock.ExpectBegin()
+				mock.ExpectCommit().WillReturnError(serializationFailure)
+			},
+			fn: func(db Database) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return nil
+				}
+			},
+			expectedCalls: 2,
+			expectedError: serializationFailure,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			mockSqlDb, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			db, err := InitDBWithPool(mockSqlDb)
+			require.NoError(t, err)
+			tc.mockSQL(mock)
+
+			calls := 0
+			fn := tc.fn(db)
+			err = NewTxManager(db, sql.LevelSerializable, tc.maxRetries).Do(ctx, func(ctx context.Context) error {
+				calls++
+				return fn(ctx)
+			})
+
+			assert.ErrorIs(t, err, tc.expectedError)
+			assert.Equal(t, tc.expectedCalls, calls)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSQLTxManager_DoJoinsOuterTransaction(t *testing.T) {
+	t.Parallel()
+
+	mockSqlDb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := InitDBWithPool(mockSqlDb)
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO bank_account`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO subscription`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	txManager := NewTxManager(db, sql.LevelDefault, 0)
+	err = txManager.Do(context.Background(), func(ctx context.Context) error {
+		outer, ok := TxFromContext(ctx)
+		require.True(t, ok)
+
+		if _, err := db.ExecuteContext(ctx, "INSERT INTO bank_account DEFAULT VALUES"); err != nil {
+			return err
+		}
+		return txManager.Do(ctx, func(ctx context.Context) error {
+			inner, _ := TxFromContext(ctx)
+			assert.Same(t, outer, inner)
+
+			_, err := db.ExecuteContext(ctx, "INSERT INTO subscription DEFAULT VALUES")
+			return err
+		})
+	})
+
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAfterCommit(t *testing.T) {
+	t.Parallel()
+
+	mockSqlDb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := InitDBWithPool(mockSqlDb)
+	require.NoError(t, err)
+	txManager := NewTxManager(db, sql.LevelDefault, 0)
+
+	var committed []string
+	AfterCommit(context.Background(), func() { committed = append(committed, "no transaction") })
+	assert.Equal(t, []string{"no transaction"}, committed)
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	err = txManager.Do(context.Background(), func(ctx context.Context) error {
+		return txManager.Do(ctx, func(ctx context.Context) error {
+			AfterCommit(ctx, func() { committed = append(committed, "inner") })
+			assert.Len(t, committed, 1, "nothing runs before the outer commit")
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"no transaction", "inner"}, committed)
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	err = txManager.Do(context.Background(), func(ctx context.Context) error {
+		AfterCommit(ctx, func() { committed = append(committed, "rolled back") })
+		return errors.New("domain error")
+	})
+	require.Error(t, err)
+	assert.Len(t, committed, 2, "rolled back transactions run nothing")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseIsolationLevel(t *testing.T) {
+	t.Parallel()
+
+	level, err := ParseIsolationLevel("repeatable-read")
+	require.NoError(t, err)
+	assert.Equal(t, sql.LevelRepeatableRead, level)
+
+	level, err = ParseIsolationLevel("")
+	require.NoError(t, err)
+	assert.Equal(t, sql.LevelDefault, level)
+
+	_, err = ParseIsolationLevel("snapshot")
+	assert.Error(t, err)
+}
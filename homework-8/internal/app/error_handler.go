@@ -5,25 +5,58 @@ import (
 	"errors"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type AppError interface {
 	error
 	StatusCode() int
+	Code() codes.Code
+	GRPCStatus() *status.Status
 }
 
+// UnaryErrorHandlerInterceptor converts application errors into gRPC statuses.
+// Errors that are neither application errors nor statuses are hidden behind
+// codes.Internal.
 func UnaryErrorHandlerInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		resp, err := handler(ctx, req)
 		if err == nil {
 			return resp, err
 		}
+		return nil, toStatusError(contextError(ctx, err))
+	}
+}
 
-		var appErr AppError
-		if errors.As(err, &appErr) {
-			return nil, appErr
-		} else {
-			return nil, &apperr.InternalServerError{Message: "Internal server error"}
+// StreamErrorHandlerInterceptor is UnaryErrorHandlerInterceptor for streams.
+func StreamErrorHandlerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := handler(srv, stream); err != nil {
+			return toStatusError(contextError(stream.Context(), err))
 		}
+		return nil
+	}
+}
+
+// contextError reports the errors of a cancelled call that were not mapped
+// yet, such as the one of beginning a transaction, as such. Application errors
+// are kept.
+func contextError(ctx context.Context, err error) error {
+	var appErr AppError
+	if errors.As(err, &appErr) {
+		return err
+	}
+	return apperr.FromContextOr(ctx, err, err)
+}
+
+func toStatusError(err error) error {
+	var appErr AppError
+	if errors.As(err, &appErr) {
+		return appErr.GRPCStatus().Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
 	}
+	return status.Error(codes.Internal, "Internal server error")
 }
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestUnaryErrorHandlerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	validationErr := validation.Errors{
+		"HolderName": errors.New("cannot be blank"),
+		"Subscriptions": validation.Errors{
+			"0": validation.Errors{"Price": errors.New("must be no less than 0")},
+		},
+	}
+
+	tests := []struct {
+		name               string
+		handlerErr         error
+		expectedCode       codes.Code
+		expectedMessage    string
+		expectedViolations []*errdetails.BadRequest_FieldViolation
+	}{
+		{
+			name:            "Not found",
+			handlerErr:      apperr.NewNotFoundError("Bank account with ID: 1 not found"),
+			expectedCode:    codes.NotFound,
+			expectedMessage: "Bank account with ID: 1 not found",
+		},
+		{
+			name:            "Conflict",
+			handlerErr:      apperr.NewConflictError("duplicate"),
+			expectedCode:    codes.AlreadyExists,
+			expectedMessage: "duplicate",
+		},
+		{
+			name:            "Stale version",
+			handlerErr:      apperr.NewPreconditionFailedError("modified"),
+			expectedCode:    codes.FailedPrecondition,
+			expectedMessage: "modified",
+		},
+		{
+			name:            "Validation error",
+			handlerErr:      apperr.NewValidationError(validationErr),
+			expectedCode:    codes.InvalidArgument,
+			expectedMessage: validationErr.Error(),
+			expectedViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "holder_name", Description: "cannot be blank"},
+				{Field: "subscriptions.0.price", Description: "must be no less than 0"},
+			},
+		},
+		{
+			name:            "Status passes through",
+			handlerErr:      status.Error(codes.Unauthenticated, "no token"),
+			expectedCode:    codes.Unauthenticated,
+			expectedMessage: "no token",
+		},
+		{
+			name:            "Cancelled by the caller",
+			handlerErr:      apperr.NewCanceledError("Request canceled"),
+			expectedCode:    codes.Canceled,
+			expectedMessage: "Request canceled",
+		},
+		{
+			name:            "Context error",
+			handlerErr:      fmt.Errorf("cannot begin transaction: %w", context.DeadlineExceeded),
+			expectedCode:    codes.DeadlineExceeded,
+			expectedMessage: "Request deadline exceeded",
+		},
+		{
+			name:            "Unknown error is hidden",
+			handlerErr:      errors.New("pq: connection refused"),
+			expectedCode:    codes.Internal,
+			expectedMessage: "Internal server error",
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, tc.handlerErr
+			}
+			_, err := UnaryErrorHandlerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+
+			st, ok := status.FromError(err)
+			require.True(t, ok)
+			assert.Equal(t, tc.expectedCode, st.Code())
+			assert.Equal(t, tc.expectedMessage, st.Message())
+
+			var violations []*errdetails.BadRequest_FieldViolation
+			for _, detail := range st.Details() {
+				if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+					violations = append(violations, badRequest.GetFieldViolations()...)
+				}
+			}
+			require.Len(t, violations, len(tc.expectedViolations))
+			for i, violation := range violations {
+				assert.Equal(t, tc.expectedViolations[i].Field, violation.Field)
+				assert.Equal(t, tc.expectedViolations[i].Description, violation.Description)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"strconv"
+	"strings"
+)
+
+// IfMatchHeader is read from the incoming metadata. The gateway forwards the
+// HTTP If-Match header under the same name.
+const IfMatchHeader = "if-match"
+
+// FormatETag returns the entity tag of a resource with the given version.
+func FormatETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ParseETag returns the version of an entity tag created by FormatETag.
+func ParseETag(etag string) (int64, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	version, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("invalid entity tag %q", etag)
+	}
+	return version, nil
+}
+
+// VersionFromIfMatch returns the version sent in If-Match, or 0 when the
+// header is absent.
+func VersionFromIfMatch(ctx context.Context) (int64, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+	values := md.Get(IfMatchHeader)
+	if len(values) == 0 {
+		return 0, nil
+	}
+	return ParseETag(values[0])
+}
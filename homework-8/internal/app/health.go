@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheck reports whether a dependency of the service, e.g. Postgres, can
+// be used.
+type HealthCheck func(ctx context.Context) error
+
+var errDraining = errors.New("shutting down")
+
+// healthMethodPrefix starts the methods of the grpc.health.v1 service.
+var healthMethodPrefix = "/" + healthpb.Health_ServiceDesc.ServiceName + "/"
+
+// isHealthMethod reports whether method belongs to the grpc.health.v1
+// service. Probes call it without a token and must never be throttled.
+func isHealthMethod(method string) bool {
+	return strings.HasPrefix(method, healthMethodPrefix)
+}
+
+// HealthChecker runs the checks of the dependencies for the readiness endpoint
+// and keeps the status of the grpc.health.v1 service in line with them.
+type HealthChecker struct {
+	checks  map[string]HealthCheck
+	timeout time.Duration
+	server  *health.Server
+
+	draining atomic.Bool
+}
+
+func NewHealthChecker(server *health.Server, timeout time.Duration, checks map[string]HealthCheck) *HealthChecker {
+	return &HealthChecker{
+		checks:  checks,
+		timeout: timeout,
+		server:  server,
+	}
+}
+
+// Check runs every check and returns the failed ones by name. A check still
+// running after the timeout fails with context.DeadlineExceeded. While the
+// service drains, it is not ready regardless of the dependencies.
+func (h *HealthChecker) Check(ctx context.Context) map[string]error {
+	failed := make(map[string]error)
+	if h.draining.Load() {
+		failed["server"] = errDraining
+		return failed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(h.checks))
+	pending := make(map[string]struct{}, len(h.checks))
+	for name, check := range h.checks {
+		name, check := name, check
+		pending[name] = struct{}{}
+		go func() {
+			results <- result{name: name, err: check(ctx)}
+		}()
+	}
+
+	for len(pending) > 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.name)
+			if r.err != nil {
+				failed[r.name] = r.err
+			}
+		case <-ctx.Done():
+			for name := range pending {
+				failed[name] = ctx.Err()
+			}
+			return failed
+		}
+	}
+	return failed
+}
+
+// Run updates the gRPC health status every interval until ctx is cancelled.
+func (h *HealthChecker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.update(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *HealthChecker) update(ctx context.Context) {
+	// the health server ignores the status once it is shut down
+	if failed := h.Check(ctx); len(failed) == 0 {
+		h.server.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	} else {
+		h.server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// Drain reports the service as not serving for good, so that load balancers
+// stop sending it new calls before the servers stop.
+func (h *HealthChecker) Drain() {
+	h.draining.Store(true)
+	h.server.Shutdown()
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// LivenessHandler answers /healthz. The process is alive as long as it
+// answers, a failing dependency is reported by ReadinessHandler.
+func (h *HealthChecker) LivenessHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// ReadinessHandler answers /readyz with 503 and the failed checks unless every
+// dependency is reachable.
+func (h *HealthChecker) ReadinessHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	failed := h.Check(r.Context())
+	if len(failed) == 0 {
+		writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+		return
+	}
+
+	checks := make(map[string]string, len(failed))
+	for name, err := range failed {
+		checks[name] = err.Error()
+	}
+	writeHealth(w, http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Checks: checks})
+}
+
+func writeHealth(w http.ResponseWriter, status int, response healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func passingCheck(ctx context.Context) error {
+	return nil
+}
+
+func TestHealthChecker_Check(t *testing.T) {
+	t.Parallel()
+
+	unreachable := errors.New("connection refused")
+	hanging := make(chan struct{})
+	t.Cleanup(func() { close(hanging) })
+
+	tests := []struct {
+		name   string
+		checks map[string]HealthCheck
+		failed map[string]error
+	}{
+		{
+			name:   "All dependencies reachable",
+			checks: map[string]HealthCheck{"postgres": passingCheck, "kafka": passingCheck},
+			failed: map[string]error{},
+		},
+		{
+			name: "Dependency unreachable",
+			checks: map[string]HealthCheck{
+				"postgres": passingCheck,
+				"kafka":    func(ctx context.Context) error { return unreachable },
+			},
+			failed: map[string]error{"kafka": unreachable},
+		},
+		{
+			name: "Check over the timeout",
+			checks: map[string]HealthCheck{
+				"postgres": passingCheck,
+				"kafka": func(ctx context.Context) error {
+					<-hanging
+					return nil
+				},
+			},
+			failed: map[string]error{"kafka": context.DeadlineExceeded},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			checker := NewHealthChecker(health.NewServer(), 50*time.Millisecond, tc.checks)
+
+			assert.Equal(t, tc.failed, checker.Check(context.Background()))
+		})
+	}
+}
+
+func TestHealthChecker_Run(t *testing.T) {
+	t.Parallel()
+
+	server := health.NewServer()
+	var failing error
+	checker := NewHealthChecker(server, time.Second, map[string]HealthCheck{
+		"postgres": func(ctx context.Context) error { return failing },
+	})
+	status := func() healthpb.HealthCheckResponse_ServingStatus {
+		response, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		return response.GetStatus()
+	}
+
+	failing = errors.New("connection refused")
+	checker.update(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status())
+
+	failing = nil
+	checker.update(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status())
+
+	checker.Drain()
+	checker.update(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(), "a draining service stays not serving")
+}
+
+func TestHealthChecker_Handlers(t *testing.T) {
+	t.Parallel()
+
+	checker := NewHealthChecker(health.NewServer(), time.Second, map[string]HealthCheck{
+		"postgres": passingCheck,
+		"kafka":    func(ctx context.Context) error { return errors.New("not connected") },
+	})
+	serve := func(handler func(http.ResponseWriter, *http.Request, map[string]string)) (int, healthResponse) {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil), nil)
+
+		var response healthResponse
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+		return recorder.Code, response
+	}
+
+	code, response := serve(checker.LivenessHandler)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthResponse{Status: "ok"}, response)
+
+	code, response = serve(checker.ReadinessHandler)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthResponse{Status: "unavailable", Checks: map[string]string{"kafka": "not connected"}}, response)
+
+	checker.Drain()
+	code, response = serve(checker.ReadinessHandler)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]string{"server": "shutting down"}, response.Checks)
+}
+
+func TestHealthService_SkipsAuthAndRateLimit(t *testing.T) {
+	t.Parallel()
+
+	var (
+		verifier, _ = NewTokenVerifier(testSecret, nil)
+		// a zero burst rejects every limited call
+		limiter  = NewRateLimiter(RateLimitRule{Rate: 1, Burst: 0}, nil)
+		listener = bufconn.Listen(1024 * 1024)
+	)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			RateLimitUnaryServerInterceptor(limiter),
+			AuthUnaryServerInterceptor(verifier, memoryAccountOwners{}, nil),
+			SubjectRateLimitUnaryServerInterceptor(limiter),
+		),
+		grpc.ChainStreamInterceptor(
+			RateLimitStreamServerInterceptor(limiter),
+			AuthStreamServerInterceptor(verifier, memoryAccountOwners{}, nil),
+			SubjectRateLimitStreamServerInterceptor(limiter),
+		),
+	)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		response, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err, "a probe sends no bearer token")
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, response.GetStatus())
+	}
+
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	response, err := watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, response.GetStatus())
+}
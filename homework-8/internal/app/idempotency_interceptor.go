@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"time"
+)
+
+// IdempotencyKeyHeader is read from the incoming metadata. The gateway forwards
+// the HTTP Idempotency-Key header under the same name.
+const IdempotencyKeyHeader = "idempotency-key"
+
+// IdempotencyRecord is identified by the principal, the key and the method,
+// so that callers cannot collide with the keys of others.
+type IdempotencyRecord struct {
+	Principal   string
+	Key         string
+	Method      string
+	RequestHash string
+	Response    []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	// LockedUntil is when a request still without a response gives up its
+	// key to a retry.
+	LockedUntil time.Time
+}
+
+type IdempotencyStore interface {
+	// Reserve saves the record without a response. If a record with the same
+	// principal, key and method has not expired yet, nothing is saved and that
+	// record is returned, unless it has no response and its lock ran out.
+	Reserve(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error)
+	Complete(ctx context.Context, record *IdempotencyRecord, response []byte) error
+	Release(ctx context.Context, record *IdempotencyRecord) error
+}
+
+// IdempotencyUnaryServerInterceptor replays the stored response of the given
+// methods when a request is repeated with the same Idempotency-Key and payload.
+// A request holds its key for lockTimeout: a retry of a request that neither
+// completed nor released the key in time, e.g. because the server crashed,
+// runs again. Keys are scoped by the principal, so it has to follow the
+// authentication interceptor.
+func IdempotencyUnaryServerInterceptor(store IdempotencyStore, ttl time.Duration, lockTimeout time.Duration, methods []string) grpc.UnaryServerInterceptor {
+	enabled := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		enabled[method] = struct{}{}
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := enabled[info.FullMethod]; !ok {
+			return handler(ctx, req)
+		}
+		key := idempotencyKeyFromContext(ctx)
+		message, ok := req.(proto.Message)
+		if key == "" || !ok {
+			return handler(ctx, req)
+		}
+
+		payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(payload)
+
+		now := time.Now()
+		record := &IdempotencyRecord{
+			Key:         key,
+			Method:      info.FullMethod,
+			RequestHash: hex.EncodeToString(hash[:]),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ttl),
+			LockedUntil: now.Add(lockTimeout),
+		}
+		if principal, ok := PrincipalFromContext(ctx); ok {
+			record.Principal = principal.Subject
+		}
+
+		existing, err := store.Reserve(ctx, record)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return replayIdempotentResponse(existing, record)
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			if err := store.Release(ctx, record); err != nil {
+				logging.Errorf(ctx, "cannot release idempotency key %q: %s", key, err)
+			}
+			return nil, err
+		}
+
+		if err := completeIdempotentRequest(ctx, store, record, resp); err != nil {
+			logging.Errorf(ctx, "cannot save response for idempotency key %q: %s", key, err)
+		}
+
+		return resp, nil
+	}
+}
+
+func replayIdempotentResponse(existing *IdempotencyRecord, record *IdempotencyRecord) (interface{}, error) {
+	if existing.RequestHash != record.RequestHash {
+		return nil, apperr.NewConflictError("Idempotency key was already used with a different request")
+	}
+	if existing.Response == nil {
+		return nil, apperr.NewConflictError("Request with the same idempotency key is still in progress")
+	}
+
+	var response anypb.Any
+	if err := proto.Unmarshal(existing.Response, &response); err != nil {
+		return nil, err
+	}
+	return response.UnmarshalNew()
+}
+
+func completeIdempotentRequest(ctx context.Context, store IdempotencyStore, record *IdempotencyRecord, resp interface{}) error {
+	message, ok := resp.(proto.Message)
+	if !ok {
+		return store.Release(ctx, record)
+	}
+
+	response, err := anypb.New(message)
+	if err != nil {
+		return err
+	}
+	body, err := proto.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	return store.Complete(ctx, record, body)
+}
+
+func idempotencyKeyFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(IdempotencyKeyHeader)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"sync"
+	"testing"
+	"time"
+)
+
+const idempotentMethod = "/test.Service/Create"
+
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*IdempotencyRecord
+	// completeErr is returned by Complete instead of saving the response.
+	completeErr error
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: make(map[string]*IdempotencyRecord)}
+}
+
+func idempotencyStoreKey(record *IdempotencyRecord) string {
+	return record.Principal + " " + record.Key + " " + record.Method
+}
+
+func (s *memoryIdempotencyStore) Reserve(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.records[idempotencyStoreKey(record)]; ok && existing.ExpiresAt.After(record.CreatedAt) &&
+		(existing.Response != nil || existing.LockedUntil.After(record.CreatedAt)) {
+		return existing, nil
+	}
+	s.records[idempotencyStoreKey(record)] = record
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, record *IdempotencyRecord, response []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.completeErr != nil {
+		return s.completeErr
+	}
+	s.records[idempotencyStoreKey(record)].Response = response
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, record *IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, idempotencyStoreKey(record))
+	return nil
+}
+
+func TestIdempotencyUnaryServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	var (
+		info    = &grpc.UnaryServerInfo{FullMethod: idempotentMethod}
+		withKey = func(key string) context.Context {
+			return metadata.NewIncomingContext(context.Background(), metadata.Pairs(IdempotencyKeyHeader, key))
+		}
+	)
+
+	newHandler := func(calls *int) grpc.UnaryHandler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			*calls++
+			return wrapperspb.String("created " + req.(*wrapperspb.StringValue).GetValue()), nil
+		}
+	}
+
+	t.Run("Replays response for the same key and payload", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		interceptor := IdempotencyUnaryServerInterceptor(newMemoryIdempotencyStore(), time.Hour, time.Minute, []string{idempotentMethod})
+
+		first, err := interceptor(withKey("key"), wrapperspb.String("account"), info, newHandler(&calls))
+		require.NoError(t, err)
+		second, err := interceptor(withKey("key"), wrapperspb.String("account"), info, newHandler(&calls))
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, calls)
+		assert.True(t, proto.Equal(first.(proto.Message), second.(proto.Message)))
+	})
+
+	t.Run("Rejects the same key with a different payload", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		interceptor := IdempotencyUnaryServerInterceptor(newMemoryIdempotencyStore(), time.Hour, time.Minute, []string{idempotentMethod})
+
+		_, err := interceptor(withKey("key"), wrapperspb.String("account"), info, newHandler(&calls))
+		require.NoError(t, err)
+		_, err = interceptor(withKey("key"), wrapperspb.String("another account"), info, newHandler(&calls))
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, apperr.NewConflictError("Idempotency key was already used with a different request"), err)
+	})
+
+	t.Run("Allows retry after a failed request", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		interceptor := IdempotencyUnaryServerInterceptor(newMemoryIdempotencyStore(), time.Hour, time.Minute, []string{idempotentMethod})
+		failing := func(ctx context.Context, req interface{}) (interface{}, error) {
+			calls++
+			return nil, apperr.NewInternalServerError("Internal server error")
+		}
+
+		_, err := interceptor(withKey("key"), wrapperspb.String("account"), info, failing)
+		require.Error(t, err)
+		_, err = interceptor(withKey("key"), wrapperspb.String("account"), info, newHandler(&calls))
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Rejects a retry while the request holds its key", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		store := newMemoryIdempotencyStore()
+		store.completeErr = apperr.NewInternalServerError("Internal server error")
+		interceptor := IdempotencyUnaryServerInterceptor(store, time.Hour, time.Hour, []string{idempotentMethod})
+
+		_, err := interceptor(withKey("key"), wrapperspb.String("account"), info, newHandler(&calls))
+		require.NoError(t, err)
+		_, err = interceptor(withKey("key"), wrapperspb.String("account"), info, newHandler(&calls))
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, apperr.NewConflictError("Request with the same idempotency key is still in progress"), err)
+	})
+
+	t.Run("Allows retry once the lock of an unfinished request ran out", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		store := newMemoryIdempotencyStore()
+		store.completeErr = apperr.NewInternalServerError("Internal server error")
+		interceptor := IdempotencyUnaryServerInterceptor(store, time.Hour, 0, []string{idempotentMethod})
+
+		_, err := interceptor(withKey("key"), wrapperspb.String("account"), info, newHandler(&calls))
+		require.NoError(t, err)
+		_, err = interceptor(withKey("key"), wrapperspb.String("account"), info, newHandler(&calls))
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Scopes keys by the principal", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		interceptor := IdempotencyUnaryServerInterceptor(newMemoryIdempotencyStore(), time.Hour, time.Minute, []string{idempotentMethod})
+		withPrincipal := func(subject string) context.Context {
+			return WithPrincipal(withKey("key"), &Principal{Subject: subject})
+		}
+
+		_, err := interceptor(withPrincipal("alice"), wrapperspb.String("account"), info, newHandler(&calls))
+		require.NoError(t, err)
+		_, err = interceptor(withPrincipal("bob"), wrapperspb.String("another account"), info, newHandler(&calls))
+		require.NoError(t, err)
+		_, err = interceptor(withPrincipal("alice"), wrapperspb.String("account"), info, newHandler(&calls))
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Ignores requests without a key", func(t *testing.T) {
+		t.Parallel()
+		calls := 0
+		interceptor := IdempotencyUnaryServerInterceptor(newMemoryIdempotencyStore(), time.Hour, time.Minute, []string{idempotentMethod})
+
+		for i := 0; i < 2; i++ {
+			_, err := interceptor(context.Background(), wrapperspb.String("account"), info, newHandler(&calls))
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, 2, calls)
+	})
+}
@@ -4,12 +4,27 @@ import (
 	"context"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-var defaultLogger *zap.Logger
+var defaultLogger = zap.NewNop()
 
 type ctxKey struct{}
 
+// New builds the logger of the service. level is a zap level such as debug or
+// info, encoding is json or console.
+func New(level string, encoding string) (*zap.Logger, error) {
+	config := zap.NewProductionConfig()
+	if err := config.Level.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	config.Encoding = encoding
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	return config.Build()
+}
+
+// SetGlobal sets the logger returned for contexts without a logger of their
+// own, e.g. those of background workers.
 func SetGlobal(logger *zap.Logger) {
 	defaultLogger = logger
 }
@@ -25,6 +40,11 @@ func ToContext(ctx context.Context, logger *zap.Logger) context.Context {
 	return context.WithValue(ctx, ctxKey{}, logger)
 }
 
+// With returns a context whose logger adds fields to every entry.
+func With(ctx context.Context, fields ...zap.Field) context.Context {
+	return ToContext(ctx, FromContext(ctx).With(fields...))
+}
+
 func Infof(ctx context.Context, format string, args ...any) {
 	FromContext(ctx).Sugar().Infof(format, args...)
 }
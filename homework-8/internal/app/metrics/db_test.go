@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDBStatsCollector(t *testing.T) {
+	t.Parallel()
+
+	collector := NewDBStatsCollector("bank", func() sql.DBStats {
+		return sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 2, Idle: 1, WaitCount: 5, WaitDuration: 1500 * time.Millisecond}
+	})
+
+	expected := `
+# HELP go_sql_in_use_connections The number of connections currently in use.
+# TYPE go_sql_in_use_connections gauge
+go_sql_in_use_connections{db_name="bank"} 2
+# HELP go_sql_wait_duration_seconds_total The total time blocked waiting for a new connection.
+# TYPE go_sql_wait_duration_seconds_total counter
+go_sql_wait_duration_seconds_total{db_name="bank"} 1.5
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "go_sql_in_use_connections", "go_sql_wait_duration_seconds_total")
+	assert.NoError(t, err)
+	assert.Equal(t, 9, testutil.CollectAndCount(collector))
+}
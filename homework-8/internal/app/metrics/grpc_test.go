@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	const method = "/test.Service/Unary"
+	interceptor := UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+
+	_, _ = interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	_, _ = interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(requests.WithLabelValues(method, "OK")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(requests.WithLabelValues(method, "NotFound")))
+
+	var latency dto.Metric
+	require.NoError(t, requestDuration.WithLabelValues(method).(prometheus.Metric).Write(&latency))
+	assert.Equal(t, uint64(2), latency.GetHistogram().GetSampleCount())
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	const method = "/test.Service/Stream"
+	interceptor := StreamServerInterceptor()
+
+	_ = interceptor(nil, nil, &grpc.StreamServerInfo{FullMethod: method}, func(srv interface{}, stream grpc.ServerStream) error {
+		return status.Error(codes.PermissionDenied, "denied")
+	})
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(requests.WithLabelValues(method, "PermissionDenied")))
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryAfterHeader is set in the response header of a rejected call to the
+// number of seconds to wait. The gateway answers with it as Retry-After.
+const RetryAfterHeader = "retry-after"
+
+// forwardedForHeader is set by the gateway to the address of the HTTP client.
+// The gateway appends it to the addresses the client sent, which anyone can
+// forge, so only the last one is trusted.
+const forwardedForHeader = "x-forwarded-for"
+
+const (
+	// bucketIdleTTL is how long the bucket of a silent client is kept.
+	bucketIdleTTL = 10 * time.Minute
+	sweepInterval = time.Minute
+)
+
+type bucket struct {
+	limiter *rate.Limiter
+	// rule the limiter was built from. A bucket whose rule has changed is
+	// replaced on the next call.
+	rule     RateLimitRule
+	lastSeen time.Time
+}
+
+// RateLimiter keeps a token bucket per client and method. Rules can be
+// replaced while the server is running.
+type RateLimiter struct {
+	mu          sync.Mutex
+	defaultRule RateLimitRule
+	rules       map[string]RateLimitRule
+	buckets     map[string]*bucket
+	lastSweep   time.Time
+	now         func() time.Time
+}
+
+func NewRateLimiter(defaultRule RateLimitRule, rules []RateLimitRule) *RateLimiter {
+	l := &RateLimiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+	l.Update(defaultRule, rules)
+	return l
+}
+
+// Update replaces the rules. Buckets of methods whose rule has not changed are
+// kept; the others are refilled, so a stricter limit takes effect after the
+// current burst at the latest.
+func (l *RateLimiter) Update(defaultRule RateLimitRule, rules []RateLimitRule) {
+	byMethod := make(map[string]RateLimitRule, len(rules))
+	for _, rule := range rules {
+		byMethod[rule.Method] = rule
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.defaultRule = defaultRule
+	l.rules = byMethod
+}
+
+// Allow takes a token from the bucket of the client for the method. When the
+// bucket is empty it returns how long the client has to wait for one.
+func (l *RateLimiter) Allow(method string, client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rule, ok := l.rules[method]
+	if !ok {
+		rule = l.defaultRule
+	}
+	if rule.Rate <= 0 {
+		return true, 0
+	}
+
+	now := l.now()
+	l.sweep(now)
+
+	key := method + " " + client
+	b, ok := l.buckets[key]
+	if !ok || b.rule != rule {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(rule.Rate), rule.Burst), rule: rule}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		// a zero burst never lets a call through
+		return false, time.Duration(float64(time.Second) / rule.Rate)
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > bucketIdleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimitUnaryServerInterceptor rejects calls from an address over the limit
+// of the method with codes.ResourceExhausted. It has to precede the
+// authentication interceptor, so that calls without a valid token are limited
+// too and cannot flood the token checks and the owner lookups. Health checks
+// are never limited.
+func RateLimitUnaryServerInterceptor(limiter *RateLimiter) grpc.UnaryServerInterceptor {
+	return unaryLimit(limiter, clientAddress)
+}
+
+// RateLimitStreamServerInterceptor is RateLimitUnaryServerInterceptor for
+// streams. A stream counts as one call when it is opened.
+func RateLimitStreamServerInterceptor(limiter *RateLimiter) grpc.StreamServerInterceptor {
+	return streamLimit(limiter, clientAddress)
+}
+
+// SubjectRateLimitUnaryServerInterceptor limits authenticated callers by their
+// subject, wherever they call from. It has to follow the authentication
+// interceptor; calls without a principal are left to the address limit.
+func SubjectRateLimitUnaryServerInterceptor(limiter *RateLimiter) grpc.UnaryServerInterceptor {
+	return unaryLimit(limiter, callerSubject)
+}
+
+// SubjectRateLimitStreamServerInterceptor is
+// SubjectRateLimitUnaryServerInterceptor for streams.
+func SubjectRateLimitStreamServerInterceptor(limiter *RateLimiter) grpc.StreamServerInterceptor {
+	return streamLimit(limiter, callerSubject)
+}
+
+func unaryLimit(limiter *RateLimiter, client func(ctx context.Context) string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := limit(ctx, limiter, info.FullMethod, client(ctx)); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func streamLimit(limiter *RateLimiter, client func(ctx context.Context) string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := limit(stream.Context(), limiter, info.FullMethod, client(stream.Context())); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// limit passes calls of an empty client through.
+func limit(ctx context.Context, limiter *RateLimiter, method string, client string) error {
+	if isHealthMethod(method) || client == "" {
+		return nil
+	}
+	allowed, retryAfter := limiter.Allow(method, client)
+	if allowed {
+		return nil
+	}
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	// the header is best effort, RetryInfo in the status carries the same delay
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterHeader, strconv.Itoa(seconds)))
+	return apperr.NewTooManyRequestsError(fmt.Sprintf("Too many requests to %s, retry in %d s", method, seconds), retryAfter)
+}
+
+// callerSubject is the subject of an authenticated caller.
+func callerSubject(ctx context.Context) string {
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		return "subject:" + principal.Subject
+	}
+	return ""
+}
+
+// clientAddress is the address a call came from. Calls relayed by the gateway
+// over loopback are attributed to the HTTP client the gateway forwarded them
+// for, the last forwarded address.
+func clientAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if forwarded := md.Get(forwardedForHeader); len(forwarded) > 0 {
+				hops := strings.Split(forwarded[len(forwarded)-1], ",")
+				host = strings.TrimSpace(hops[len(hops)-1])
+			}
+		}
+	}
+	return "ip:" + host
+}
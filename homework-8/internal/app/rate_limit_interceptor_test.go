@@ -0,0 +1,230 @@
+package app
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+const limitedMethod = "/test.Service/Create"
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestRateLimiter(defaultRule RateLimitRule, rules ...RateLimitRule) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := NewRateLimiter(defaultRule, rules)
+	limiter.now = clock.Now
+	return limiter, clock
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Burst then rate", func(t *testing.T) {
+		t.Parallel()
+		limiter, clock := newTestRateLimiter(RateLimitRule{}, RateLimitRule{Method: limitedMethod, Rate: 1, Burst: 2})
+
+		for i := 0; i < 2; i++ {
+			allowed, _ := limiter.Allow(limitedMethod, "dima")
+			assert.True(t, allowed)
+		}
+		allowed, retryAfter := limiter.Allow(limitedMethod, "dima")
+		assert.False(t, allowed)
+		assert.Equal(t, time.Second, retryAfter)
+
+		clock.now = clock.now.Add(500 * time.Millisecond)
+		allowed, retryAfter = limiter.Allow(limitedMethod, "dima")
+		assert.False(t, allowed, "a rejected call does not take a token")
+		assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+		clock.now = clock.now.Add(500 * time.Millisecond)
+		allowed, _ = limiter.Allow(limitedMethod, "dima")
+		assert.True(t, allowed)
+	})
+
+	t.Run("Clients and methods have their own buckets", func(t *testing.T) {
+		t.Parallel()
+		limiter, _ := newTestRateLimiter(RateLimitRule{Rate: 1, Burst: 1})
+
+		allowed, _ := limiter.Allow(limitedMethod, "dima")
+		assert.True(t, allowed)
+		allowed, _ = limiter.Allow(limitedMethod, "ivan")
+		assert.True(t, allowed)
+		allowed, _ = limiter.Allow("/test.Service/Get", "dima")
+		assert.True(t, allowed)
+		allowed, _ = limiter.Allow(limitedMethod, "dima")
+		assert.False(t, allowed)
+	})
+
+	t.Run("Zero rate is unlimited", func(t *testing.T) {
+		t.Parallel()
+		limiter, _ := newTestRateLimiter(RateLimitRule{Rate: 1, Burst: 1}, RateLimitRule{Method: limitedMethod})
+
+		for i := 0; i < 10; i++ {
+			allowed, _ := limiter.Allow(limitedMethod, "dima")
+			assert.True(t, allowed)
+		}
+	})
+
+	t.Run("Zero burst rejects every call", func(t *testing.T) {
+		t.Parallel()
+		limiter, _ := newTestRateLimiter(RateLimitRule{Rate: 2})
+
+		allowed, retryAfter := limiter.Allow(limitedMethod, "dima")
+		assert.False(t, allowed)
+		assert.Equal(t, 500*time.Millisecond, retryAfter)
+	})
+
+	t.Run("Update replaces the rules", func(t *testing.T) {
+		t.Parallel()
+		limiter, _ := newTestRateLimiter(RateLimitRule{Rate: 1, Burst: 1})
+
+		allowed, _ := limiter.Allow(limitedMethod, "dima")
+		assert.True(t, allowed)
+		allowed, _ = limiter.Allow(limitedMethod, "dima")
+		assert.False(t, allowed)
+
+		limiter.Update(RateLimitRule{Rate: 1, Burst: 3}, nil)
+
+		for i := 0; i < 3; i++ {
+			allowed, _ = limiter.Allow(limitedMethod, "dima")
+			assert.True(t, allowed)
+		}
+		allowed, _ = limiter.Allow(limitedMethod, "dima")
+		assert.False(t, allowed)
+	})
+
+	t.Run("Update keeps the buckets of unchanged rules", func(t *testing.T) {
+		t.Parallel()
+		strict := RateLimitRule{Method: "/test.Service/Delete", Rate: 1, Burst: 1}
+		limiter, _ := newTestRateLimiter(RateLimitRule{Rate: 1, Burst: 1}, strict)
+
+		limiter.Allow(limitedMethod, "dima")
+		limiter.Allow(strict.Method, "dima")
+
+		limiter.Update(RateLimitRule{Rate: 1, Burst: 1}, []RateLimitRule{strict})
+
+		allowed, _ := limiter.Allow(limitedMethod, "dima")
+		assert.False(t, allowed, "reloading the same config does not refill buckets")
+		allowed, _ = limiter.Allow(strict.Method, "dima")
+		assert.False(t, allowed)
+
+		limiter.Update(RateLimitRule{Rate: 1, Burst: 2}, []RateLimitRule{strict})
+
+		allowed, _ = limiter.Allow(limitedMethod, "dima")
+		assert.True(t, allowed, "the bucket of a changed rule is refilled")
+		allowed, _ = limiter.Allow(strict.Method, "dima")
+		assert.False(t, allowed)
+	})
+
+	t.Run("Idle buckets are dropped", func(t *testing.T) {
+		t.Parallel()
+		limiter, clock := newTestRateLimiter(RateLimitRule{Rate: 1, Burst: 1})
+
+		limiter.Allow(limitedMethod, "dima")
+		clock.now = clock.now.Add(bucketIdleTTL + time.Second)
+		limiter.Allow(limitedMethod, "ivan")
+
+		assert.Len(t, limiter.buckets, 1)
+	})
+}
+
+func TestRateLimitUnaryServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	limiter, _ := newTestRateLimiter(RateLimitRule{Rate: 0.5, Burst: 1})
+	interceptor := RateLimitUnaryServerInterceptor(limiter)
+	info := &grpc.UnaryServerInfo{FullMethod: limitedMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+	gateway := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}})
+	forwardedFor := func(address string) context.Context {
+		return metadata.NewIncomingContext(gateway, metadata.Pairs(forwardedForHeader, address))
+	}
+
+	_, err := interceptor(forwardedFor("10.0.0.1"), "req", info, handler)
+	require.NoError(t, err)
+	_, err = interceptor(forwardedFor("10.0.0.1, 10.0.0.2"), "req", info, handler)
+	require.NoError(t, err, "clients behind the gateway are told apart")
+
+	_, err = interceptor(forwardedFor("10.0.0.1"), "req", info, handler)
+
+	st := status.Convert(toStatusError(err))
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1)
+	assert.Equal(t, 2*time.Second, st.Details()[0].(*errdetails.RetryInfo).GetRetryDelay().AsDuration())
+}
+
+func TestRateLimitUnaryServerInterceptor_LimitsUnauthenticatedCalls(t *testing.T) {
+	t.Parallel()
+
+	limiter, _ := newTestRateLimiter(RateLimitRule{Rate: 1, Burst: 1})
+	interceptor := RateLimitUnaryServerInterceptor(limiter)
+	info := &grpc.UnaryServerInfo{FullMethod: limitedMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+	remote := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 40000}})
+
+	_, err := interceptor(remote, "req", info, handler)
+	require.NoError(t, err)
+	_, err = interceptor(WithPrincipal(remote, &Principal{Subject: "dima"}), "req", info, handler)
+
+	assert.Equal(t, codes.ResourceExhausted, status.Code(toStatusError(err)), "the address is limited whoever calls from it")
+}
+
+func TestSubjectRateLimitUnaryServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	limiter, _ := newTestRateLimiter(RateLimitRule{Rate: 1, Burst: 1})
+	interceptor := SubjectRateLimitUnaryServerInterceptor(limiter)
+	info := &grpc.UnaryServerInfo{FullMethod: limitedMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+	address := func(ip net.IP) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: ip, Port: 40000}})
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := interceptor(address(net.IPv4(10, 0, 0, 7)), "req", info, handler)
+		require.NoError(t, err, "calls without a principal are left to the address limit")
+	}
+
+	_, err := interceptor(WithPrincipal(address(net.IPv4(10, 0, 0, 7)), &Principal{Subject: "dima"}), "req", info, handler)
+	require.NoError(t, err)
+	_, err = interceptor(WithPrincipal(address(net.IPv4(10, 0, 0, 8)), &Principal{Subject: "dima"}), "req", info, handler)
+
+	assert.Equal(t, codes.ResourceExhausted, status.Code(toStatusError(err)), "the subject is limited wherever it calls from")
+}
+
+func TestClientAddress(t *testing.T) {
+	t.Parallel()
+
+	remote := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 40000}})
+
+	assert.Equal(t, "ip:10.0.0.7", clientAddress(WithPrincipal(remote, &Principal{Subject: "dima"})))
+	assert.Equal(t, "ip:10.0.0.7", clientAddress(remote))
+	assert.Equal(t, "ip:10.0.0.7", clientAddress(metadata.NewIncomingContext(remote, metadata.Pairs(forwardedForHeader, "1.2.3.4"))),
+		"only the gateway may forward an address")
+	gateway := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}})
+	assert.Equal(t, "ip:10.0.0.9", clientAddress(metadata.NewIncomingContext(gateway, metadata.Pairs(forwardedForHeader, "1.2.3.4, 10.0.0.9"))),
+		"addresses sent by the client are ignored")
+	assert.Equal(t, "unknown", clientAddress(context.Background()))
+}
@@ -0,0 +1,35 @@
+package apperr
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
+
+// AbortedError is returned for a request that lost a race with a concurrent
+// one and can be retried as is.
+type AbortedError struct {
+	Message string
+}
+
+func NewAbortedError(message string) *AbortedError {
+	return &AbortedError{
+		Message: message,
+	}
+}
+
+func (e AbortedError) Error() string {
+	return e.Message
+}
+
+func (e AbortedError) StatusCode() int {
+	return http.StatusConflict
+}
+
+func (e AbortedError) Code() codes.Code {
+	return codes.Aborted
+}
+
+func (e AbortedError) GRPCStatus() *status.Status {
+	return status.New(e.Code(), e.Message)
+}
@@ -1,9 +1,26 @@
 package apperr
 
-import "net/http"
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
 
 type BadRequestError struct {
-	Message string
+	Message    string
+	Violations []FieldViolation
+}
+
+// FieldViolation describes a single invalid field of a request. Field is the
+// snake_case path of the field in the request message.
+type FieldViolation struct {
+	Field       string
+	Description string
 }
 
 func NewBadRequestError(message string) *BadRequestError {
@@ -12,6 +29,56 @@ func NewBadRequestError(message string) *BadRequestError {
 	}
 }
 
+// NewValidationError converts the result of ozzo-validation into a bad request
+// that lists every invalid field.
+func NewValidationError(err error) *BadRequestError {
+	return &BadRequestError{
+		Message:    err.Error(),
+		Violations: fieldViolations("", err),
+	}
+}
+
+func fieldViolations(path string, err error) []FieldViolation {
+	errs, ok := err.(validation.Errors)
+	if !ok {
+		return []FieldViolation{{Field: path, Description: err.Error()}}
+	}
+
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var violations []FieldViolation
+	for _, key := range keys {
+		if errs[key] == nil {
+			continue
+		}
+		field := snakeCase(key)
+		if path != "" {
+			field = path + "." + field
+		}
+		violations = append(violations, fieldViolations(field, errs[key])...)
+	}
+	return violations
+}
+
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 func (e BadRequestError) Error() string {
 	return e.Message
 }
@@ -19,3 +86,28 @@ func (e BadRequestError) Error() string {
 func (e BadRequestError) StatusCode() int {
 	return http.StatusBadRequest
 }
+
+func (e BadRequestError) Code() codes.Code {
+	return codes.InvalidArgument
+}
+
+func (e BadRequestError) GRPCStatus() *status.Status {
+	st := status.New(e.Code(), e.Message)
+	if len(e.Violations) == 0 {
+		return st
+	}
+
+	details := &errdetails.BadRequest{}
+	for _, violation := range e.Violations {
+		details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       violation.Field,
+			Description: violation.Description,
+		})
+	}
+
+	detailed, err := st.WithDetails(details)
+	if err != nil {
+		return st
+	}
+	return detailed
+}
@@ -0,0 +1,36 @@
+package apperr
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusClientClosedRequest is the status the gateway answers a cancelled call
+// with; net/http has no name for it.
+const statusClientClosedRequest = 499
+
+type CanceledError struct {
+	Message string
+}
+
+func NewCanceledError(message string) *CanceledError {
+	return &CanceledError{
+		Message: message,
+	}
+}
+
+func (e CanceledError) Error() string {
+	return e.Message
+}
+
+func (e CanceledError) StatusCode() int {
+	return statusClientClosedRequest
+}
+
+func (e CanceledError) Code() codes.Code {
+	return codes.Canceled
+}
+
+func (e CanceledError) GRPCStatus() *status.Status {
+	return status.New(e.Code(), e.Message)
+}
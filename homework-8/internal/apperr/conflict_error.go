@@ -0,0 +1,33 @@
+package apperr
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
+
+type ConflictError struct {
+	Message string
+}
+
+func NewConflictError(message string) *ConflictError {
+	return &ConflictError{
+		Message: message,
+	}
+}
+
+func (e ConflictError) Error() string {
+	return e.Message
+}
+
+func (e ConflictError) StatusCode() int {
+	return http.StatusConflict
+}
+
+func (e ConflictError) Code() codes.Code {
+	return codes.AlreadyExists
+}
+
+func (e ConflictError) GRPCStatus() *status.Status {
+	return status.New(e.Code(), e.Message)
+}
@@ -0,0 +1,22 @@
+package apperr
+
+import (
+	"context"
+	"errors"
+)
+
+// FromContextOr returns CanceledError or DeadlineExceededError when err is
+// the error of a call whose ctx was cancelled or timed out, so that the caller
+// is not told the server failed when it gave up itself. The context is checked
+// as well, drivers report a cancelled query with errors of their own. Any
+// other error is replaced by fallback.
+func FromContextOr(ctx context.Context, err error, fallback error) error {
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		return NewCanceledError("Request canceled")
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return NewDeadlineExceededError("Request deadline exceeded")
+	default:
+		return fallback
+	}
+}
@@ -0,0 +1,33 @@
+package apperr
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
+
+type DeadlineExceededError struct {
+	Message string
+}
+
+func NewDeadlineExceededError(message string) *DeadlineExceededError {
+	return &DeadlineExceededError{
+		Message: message,
+	}
+}
+
+func (e DeadlineExceededError) Error() string {
+	return e.Message
+}
+
+func (e DeadlineExceededError) StatusCode() int {
+	return http.StatusGatewayTimeout
+}
+
+func (e DeadlineExceededError) Code() codes.Code {
+	return codes.DeadlineExceeded
+}
+
+func (e DeadlineExceededError) GRPCStatus() *status.Status {
+	return status.New(e.Code(), e.Message)
+}
@@ -0,0 +1,33 @@
+package apperr
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
+
+type ForbiddenError struct {
+	Message string
+}
+
+func NewForbiddenError(message string) *ForbiddenError {
+	return &ForbiddenError{
+		Message: message,
+	}
+}
+
+func (e ForbiddenError) Error() string {
+	return e.Message
+}
+
+func (e ForbiddenError) StatusCode() int {
+	return http.StatusForbidden
+}
+
+func (e ForbiddenError) Code() codes.Code {
+	return codes.PermissionDenied
+}
+
+func (e ForbiddenError) GRPCStatus() *status.Status {
+	return status.New(e.Code(), e.Message)
+}
@@ -1,6 +1,10 @@
 package apperr
 
-import "net/http"
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
 
 type InternalServerError struct {
 	Message string
@@ -19,3 +23,11 @@ func (e InternalServerError) Error() string {
 func (e InternalServerError) StatusCode() int {
 	return http.StatusInternalServerError
 }
+
+func (e InternalServerError) Code() codes.Code {
+	return codes.Internal
+}
+
+func (e InternalServerError) GRPCStatus() *status.Status {
+	return status.New(e.Code(), e.Message)
+}
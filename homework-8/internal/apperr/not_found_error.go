@@ -1,6 +1,10 @@
 package apperr
 
-import "net/http"
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
 
 type NotFoundError struct {
 	Message string
@@ -19,3 +23,11 @@ func (e NotFoundError) Error() string {
 func (e NotFoundError) StatusCode() int {
 	return http.StatusNotFound
 }
+
+func (e NotFoundError) Code() codes.Code {
+	return codes.NotFound
+}
+
+func (e NotFoundError) GRPCStatus() *status.Status {
+	return status.New(e.Code(), e.Message)
+}
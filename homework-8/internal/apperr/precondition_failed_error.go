@@ -0,0 +1,33 @@
+package apperr
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
+
+type PreconditionFailedError struct {
+	Message string
+}
+
+func NewPreconditionFailedError(message string) *PreconditionFailedError {
+	return &PreconditionFailedError{
+		Message: message,
+	}
+}
+
+func (e PreconditionFailedError) Error() string {
+	return e.Message
+}
+
+func (e PreconditionFailedError) StatusCode() int {
+	return http.StatusPreconditionFailed
+}
+
+func (e PreconditionFailedError) Code() codes.Code {
+	return codes.FailedPrecondition
+}
+
+func (e PreconditionFailedError) GRPCStatus() *status.Status {
+	return status.New(e.Code(), e.Message)
+}
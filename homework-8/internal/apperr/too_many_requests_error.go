@@ -0,0 +1,44 @@
+package apperr
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"net/http"
+	"time"
+)
+
+type TooManyRequestsError struct {
+	Message string
+	// RetryAfter is how long the caller has to wait before the call can pass.
+	RetryAfter time.Duration
+}
+
+func NewTooManyRequestsError(message string, retryAfter time.Duration) *TooManyRequestsError {
+	return &TooManyRequestsError{
+		Message:    message,
+		RetryAfter: retryAfter,
+	}
+}
+
+func (e TooManyRequestsError) Error() string {
+	return e.Message
+}
+
+func (e TooManyRequestsError) StatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+func (e TooManyRequestsError) Code() codes.Code {
+	return codes.ResourceExhausted
+}
+
+func (e TooManyRequestsError) GRPCStatus() *status.Status {
+	st := status.New(e.Code(), e.Message)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)})
+	if err != nil {
+		return st
+	}
+	return detailed
+}
@@ -0,0 +1,33 @@
+package apperr
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
+
+type UnauthenticatedError struct {
+	Message string
+}
+
+func NewUnauthenticatedError(message string) *UnauthenticatedError {
+	return &UnauthenticatedError{
+		Message: message,
+	}
+}
+
+func (e UnauthenticatedError) Error() string {
+	return e.Message
+}
+
+func (e UnauthenticatedError) StatusCode() int {
+	return http.StatusUnauthorized
+}
+
+func (e UnauthenticatedError) Code() codes.Code {
+	return codes.Unauthenticated
+}
+
+func (e UnauthenticatedError) GRPCStatus() *status.Status {
+	return status.New(e.Code(), e.Message)
+}
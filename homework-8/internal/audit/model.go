@@ -0,0 +1,221 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/audit_events"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EntityAccount      = "account"
+	EntitySubscription = "subscription"
+)
+
+// ActorSystem is recorded for changes made without an authenticated caller.
+const ActorSystem = "system"
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// Change holds a field of an entity before and after it was changed. A field
+// of an entity that did not exist, e.g. before its creation, is nil.
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff maps the JSON names of the changed fields to their changes.
+type Diff map[string]Change
+
+// Event records who changed an entity, how and when.
+type Event struct {
+	ID         uuid.UUID
+	EntityType string
+	EntityID   uuid.UUID
+	Actor      string
+	Method     string
+	Diff       Diff
+	RequestID  string
+	CreatedAt  time.Time
+}
+
+// NewEvent describes the change of an entity from before to after made by the
+// caller of ctx. Either of them may be nil for a creation or a removal. It
+// returns nil when nothing changed, e.g. for a replayed transfer.
+func NewEvent(ctx context.Context, entityType string, entityID uuid.UUID, method string, before proto.Message, after proto.Message) (*Event, error) {
+	diff, err := NewDiff(before, after)
+	if err != nil {
+		return nil, err
+	}
+	if len(diff) == 0 {
+		return nil, nil
+	}
+
+	actor := ActorSystem
+	if principal, ok := app.PrincipalFromContext(ctx); ok {
+		actor = principal.Subject
+	}
+
+	return &Event{
+		ID:         uuid.New(),
+		EntityType: entityType,
+		EntityID:   entityID,
+		Actor:      actor,
+		Method:     method,
+		Diff:       diff,
+		RequestID:  app.RequestIDFromContext(ctx),
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// NewDiff compares the JSON of the two messages, the same JSON the entities
+// are returned and published with. Unset fields take part as their zero
+// values, so that e.g. a balance dropping to zero is recorded.
+func NewDiff(before proto.Message, after proto.Message) (Diff, error) {
+	beforeFields, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := make(Diff)
+	for name, value := range afterFields {
+		if previous, ok := beforeFields[name]; !ok || !reflect.DeepEqual(previous, value) {
+			diff[name] = Change{Before: previous, After: value}
+		}
+	}
+	for name, value := range beforeFields {
+		if _, ok := afterFields[name]; !ok {
+			diff[name] = Change{Before: value}
+		}
+	}
+	return diff, nil
+}
+
+func jsonFields(message proto.Message) (map[string]interface{}, error) {
+	if message == nil || reflect.ValueOf(message).IsNil() {
+		return nil, nil
+	}
+	body, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func (e Event) MapToDto() (*audit_events.AuditEventDto, error) {
+	fields := make(map[string]interface{}, len(e.Diff))
+	for name, change := range e.Diff {
+		fields[name] = map[string]interface{}{"before": change.Before, "after": change.After}
+	}
+	diff, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return &audit_events.AuditEventDto{
+		Id:         &audit_events.UUID{Value: e.ID.String()},
+		EntityType: e.EntityType,
+		EntityId:   &audit_events.UUID{Value: e.EntityID.String()},
+		Actor:      e.Actor,
+		Method:     e.Method,
+		Diff:       diff,
+		RequestId:  e.RequestID,
+		CreatedAt:  &audit_events.Timestamp{Value: timestamppb.New(e.CreatedAt)},
+	}, nil
+}
+
+// Cursor points at the last event of a returned page. Events are listed from
+// the newest to the oldest, so the next page starts strictly after it.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+type Filter struct {
+	EntityType string
+	EntityID   uuid.UUID
+	From       time.Time
+	To         time.Time
+	After      *Cursor
+	Limit      int
+}
+
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%d|%s", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(token string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	invalid := errors.New("invalid page token")
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, invalid
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 2 {
+		return nil, invalid
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, invalid
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return nil, invalid
+	}
+
+	return &Cursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+func MapFilterFromRequest(request *audit_events.ListAuditEventsRequest) (*Filter, error) {
+	cursor, err := DecodeCursor(request.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &Filter{
+		EntityType: request.GetEntityType(),
+		After:      cursor,
+		Limit:      int(request.GetPageSize()),
+	}
+	if request.GetEntityId().GetValue() != "" {
+		if filter.EntityID, err = uuid.Parse(request.GetEntityId().GetValue()); err != nil {
+			return nil, errors.New("invalid entity_id")
+		}
+	}
+	if request.GetFrom().GetValue() != nil {
+		filter.From = request.GetFrom().GetValue().AsTime()
+	}
+	if request.GetTo().GetValue() != nil {
+		filter.To = request.GetTo().GetValue().AsTime()
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,138 @@
+//go:generate mockgen -source=./repository.go -destination=./mocks/repository.go -package=mock_audit
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"strings"
+)
+
+type Repository interface {
+	// AddEvents stores events inside the transaction that made the changes, so
+	// ctx must carry that transaction.
+	AddEvents(ctx context.Context, events ...Event) error
+	// ListEvents returns events from the newest to the oldest, starting
+	// strictly after filter.After when it is set.
+	ListEvents(ctx context.Context, filter *Filter) ([]Event, error)
+}
+
+type AuditRepository struct {
+	db database.Database
+}
+
+func NewAuditRepository(db database.Database) *AuditRepository {
+	return &AuditRepository{
+		db: db,
+	}
+}
+
+var eventColumns = []string{"id", "entity_type", "entity_id", "actor", "method", "diff", "request_id", "created_at"}
+
+// AddEvents inserts a single event and loads more of them, e.g. of an import,
+// with COPY.
+func (r *AuditRepository) AddEvents(ctx context.Context, events ...Event) error {
+	rows := make([][]interface{}, len(events))
+	for i, event := range events {
+		diff, err := json.Marshal(event.Diff)
+		if err != nil {
+			return apperr.NewInternalServerError("Internal server error")
+		}
+		rows[i] = []interface{}{event.ID, event.EntityType, event.EntityID, event.Actor, event.Method, diff, event.RequestID, event.CreatedAt}
+	}
+
+	if len(rows) > 1 {
+		if err := r.db.CopyInContext(ctx, "audit_event", eventColumns, rows); err != nil {
+			return apperr.NewInternalServerError("Internal server error")
+		}
+		return nil
+	}
+
+	query := `
+		INSERT INTO audit_event (id, entity_type, entity_id, actor, method, diff, request_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	for _, row := range rows {
+		if _, err := r.db.ExecuteContext(ctx, query, row...); err != nil {
+			return apperr.NewInternalServerError("Internal server error")
+		}
+	}
+	return nil
+}
+
+func (r *AuditRepository) ListEvents(ctx context.Context, filter *Filter) ([]Event, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	addCondition := func(condition string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = len(args)
+		}
+		conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+	}
+
+	if filter.EntityType != "" {
+		addCondition("entity_type = $%d", filter.EntityType)
+	}
+	if filter.EntityID != uuid.Nil {
+		addCondition("entity_id = $%d", filter.EntityID)
+	}
+	if !filter.From.IsZero() {
+		addCondition("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("created_at < $%d", filter.To)
+	}
+	if filter.After != nil {
+		addCondition("(created_at, id) < ($%d, $%d)", filter.After.CreatedAt, filter.After.ID)
+	}
+
+	query := "SELECT id, entity_type, entity_id, actor, method, diff, request_id, created_at FROM audit_event"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.QueryRowsContext(ctx, query, args...)
+	if err != nil {
+		return nil, apperr.NewInternalServerError("Internal server error")
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var (
+			event Event
+			diff  []byte
+		)
+		err := rows.Scan(
+			&event.ID,
+			&event.EntityType,
+			&event.EntityID,
+			&event.Actor,
+			&event.Method,
+			&diff,
+			&event.RequestID,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, apperr.NewInternalServerError("Internal server error")
+		}
+		if err := json.Unmarshal(diff, &event.Diff); err != nil {
+			return nil, apperr.NewInternalServerError("Internal server error")
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperr.NewInternalServerError("Internal server error")
+	}
+
+	return events, nil
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"testing"
+	"time"
+)
+
+func newAuditRepoFixture(t *testing.T) (*AuditRepository, sqlmock.Sqlmock) {
+	mockSqlDb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := database.InitDBWithPool(mockSqlDb)
+	require.NoError(t, err)
+	return NewAuditRepository(db), mock
+}
+
+func TestAddEventsRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		event = Event{
+			ID:         uuid.New(),
+			EntityType: EntityAccount,
+			EntityID:   uuid.New(),
+			Actor:      "dima",
+			Method:     "UpdateBankAccount",
+			Diff:       Diff{"holderName": {Before: "Dima Sudakov", After: "Dima Petrov"}},
+			RequestID:  "req-1",
+			CreatedAt:  time.Now(),
+		}
+		diff = []byte(`{"holderName":{"before":"Dima Sudakov","after":"Dima Petrov"}}`)
+	)
+
+	t.Run("Single event is inserted", func(t *testing.T) {
+		t.Parallel()
+		repo, mock := newAuditRepoFixture(t)
+		mock.ExpectExec(`INSERT INTO audit_event \(id, entity_type, entity_id, actor, method, diff, request_id, created_at\)`).
+			WithArgs(event.ID, EntityAccount, event.EntityID, "dima", "UpdateBankAccount", diff, "req-1", event.CreatedAt).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		require.NoError(t, repo.AddEvents(ctx, event))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Events of an import are copied", func(t *testing.T) {
+		t.Parallel()
+		repo, mock := newAuditRepoFixture(t)
+		mock.ExpectBegin()
+		copyEvents := mock.ExpectPrepare(`COPY "audit_event" \("id", "entity_type", "entity_id", "actor", "method", "diff", "request_id", "created_at"\) FROM STDIN`)
+		copyEvents.ExpectExec().WithArgs(event.ID, EntityAccount, event.EntityID, "dima", "UpdateBankAccount", diff, "req-1", event.CreatedAt).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		copyEvents.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+		copyEvents.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		second := event
+		second.ID = uuid.New()
+		err := database.NewTxManager(repo.db, sql.LevelDefault, 0).Do(ctx, func(ctx context.Context) error {
+			return repo.AddEvents(ctx, event, second)
+		})
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListEventsRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		entityID  = uuid.New()
+		from      = time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+		to        = time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC)
+		afterID   = uuid.New()
+		createdAt = time.Date(2023, 10, 6, 12, 0, 0, 0, time.UTC)
+		columns   = []string{"id", "entity_type", "entity_id", "actor", "method", "diff", "request_id", "created_at"}
+	)
+
+	repo, mock := newAuditRepoFixture(t)
+	mock.ExpectQuery(`^SELECT id, entity_type, entity_id, actor, method, diff, request_id, created_at FROM audit_event `+
+		`WHERE entity_type = \$1 AND entity_id = \$2 AND created_at >= \$3 AND created_at < \$4 AND \(created_at, id\) < \(\$5, \$6\) `+
+		`ORDER BY created_at DESC, id DESC LIMIT \$7$`).
+		WithArgs(EntityAccount, entityID, from, to, createdAt, afterID, 11).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(uuid.New(), EntityAccount, entityID, "admin", "FreezeBankAccount", []byte(`{"status":{"before":"active","after":"frozen"}}`), "req-2", createdAt))
+
+	events, err := repo.ListEvents(ctx, &Filter{
+		EntityType: EntityAccount,
+		EntityID:   entityID,
+		From:       from,
+		To:         to,
+		After:      &Cursor{CreatedAt: createdAt, ID: afterID},
+		Limit:      11,
+	})
+
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, Diff{"status": {Before: "active", After: "frozen"}}, events[0].Diff)
+	assert.Equal(t, "req-2", events[0].RequestID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"context"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/audit_events"
+)
+
+type AuditGrpcImpl struct {
+	service Service
+	audit_events.UnimplementedAuditServiceServer
+}
+
+func NewAuditGrpcImpl(service Service) *AuditGrpcImpl {
+	return &AuditGrpcImpl{
+		service: service,
+	}
+}
+
+func (a AuditGrpcImpl) ListAuditEvents(ctx context.Context, request *audit_events.ListAuditEventsRequest) (*audit_events.ListAuditEventsResponse, error) {
+	filter, err := MapFilterFromRequest(request)
+	if err != nil {
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	events, nextPageToken, err := a.service.ListAuditEvents(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	dtos := make([]*audit_events.AuditEventDto, len(events))
+	for i, event := range events {
+		if dtos[i], err = event.MapToDto(); err != nil {
+			return nil, apperr.NewInternalServerError("Internal server error")
+		}
+	}
+
+	return &audit_events.ListAuditEventsResponse{
+		Events:        dtos,
+		NextPageToken: nextPageToken,
+	}, nil
+}
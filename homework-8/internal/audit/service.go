@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+)
+
+type Service interface {
+	ListAuditEvents(ctx context.Context, filter *Filter) ([]Event, string, error)
+}
+
+type AuditService struct {
+	repository Repository
+}
+
+func NewAuditService(repository Repository) *AuditService {
+	return &AuditService{
+		repository: repository,
+	}
+}
+
+// ListAuditEvents is reserved to admins, the log covers the accounts of every
+// holder.
+func (s *AuditService) ListAuditEvents(ctx context.Context, filter *Filter) ([]Event, string, error) {
+	if principal, ok := app.PrincipalFromContext(ctx); ok && !principal.IsAdmin() {
+		return nil, "", apperr.NewForbiddenError(fmt.Sprintf("Audit events require the %s role", app.RoleAdmin))
+	}
+	if filter.Limit < 0 || filter.Limit > MaxPageSize {
+		return nil, "", apperr.NewBadRequestError(fmt.Sprintf("page size must be between 0 and %d", MaxPageSize))
+	}
+	if filter.Limit == 0 {
+		filter.Limit = DefaultPageSize
+	}
+	if filter.EntityType != "" && filter.EntityType != EntityAccount && filter.EntityType != EntitySubscription {
+		return nil, "", apperr.NewBadRequestError(fmt.Sprintf("entity type must be %s or %s", EntityAccount, EntitySubscription))
+	}
+	if filter.EntityID != uuid.Nil && filter.EntityType == "" {
+		return nil, "", apperr.NewBadRequestError("entity id requires entity type")
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, "", apperr.NewBadRequestError("from must be before to")
+	}
+
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+	events, err := s.repository.ListEvents(ctx, filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextPageToken string
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		last := events[pageSize-1]
+		nextPageToken = Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	return events, nextPageToken, nil
+}
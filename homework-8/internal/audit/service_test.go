@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/subscriptions"
+	"testing"
+	"time"
+)
+
+func TestNewEvent(t *testing.T) {
+	t.Parallel()
+
+	var (
+		id     = uuid.New()
+		ctx    = app.WithPrincipal(context.Background(), &app.Principal{Subject: "dima"})
+		before = &subscriptions.SubscriptionDto{Id: &subscriptions.UUID{Value: id.String()}, SubscriptionName: "Music", Price: 100, Status: "active"}
+		after  = &subscriptions.SubscriptionDto{Id: &subscriptions.UUID{Value: id.String()}, SubscriptionName: "Music", Price: 0, Status: "active"}
+	)
+
+	event, err := NewEvent(ctx, EntitySubscription, id, "UpdateSubscription", before, after)
+	require.NoError(t, err)
+	require.NotNil(t, event)
+	assert.Equal(t, "dima", event.Actor)
+	assert.Equal(t, Diff{"price": {Before: "100", After: "0"}}, event.Diff, "a field set to its zero value is a change")
+
+	var created *subscriptions.SubscriptionDto
+	event, err = NewEvent(context.Background(), EntitySubscription, id, "CreateSubscription", created, before)
+	require.NoError(t, err)
+	assert.Equal(t, ActorSystem, event.Actor)
+	assert.Equal(t, Change{After: "Music"}, event.Diff["subscriptionName"])
+
+	event, err = NewEvent(ctx, EntitySubscription, id, "UpdateSubscription", before, before)
+	require.NoError(t, err)
+	assert.Nil(t, event, "nothing changed")
+}
+
+type fakeRepository struct {
+	events []Event
+	filter *Filter
+}
+
+func (r *fakeRepository) AddEvents(ctx context.Context, events ...Event) error {
+	r.events = append(r.events, events...)
+	return nil
+}
+
+func (r *fakeRepository) ListEvents(ctx context.Context, filter *Filter) ([]Event, error) {
+	r.filter = filter
+	return r.events, nil
+}
+
+func TestAuditService_ListAuditEvents(t *testing.T) {
+	t.Parallel()
+
+	var (
+		admin     = app.WithPrincipal(context.Background(), &app.Principal{Subject: "root", Roles: []string{app.RoleAdmin}})
+		holder    = app.WithPrincipal(context.Background(), &app.Principal{Subject: "dima"})
+		createdAt = time.Date(2023, 10, 6, 12, 0, 0, 0, time.UTC)
+		events    = []Event{{ID: uuid.New(), CreatedAt: createdAt}, {ID: uuid.New(), CreatedAt: createdAt}}
+	)
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		filter        Filter
+		expectedLimit int
+		expectedCount int
+		expectedToken string
+		expectedError error
+	}{
+		{
+			name:          "Next page token after a full page",
+			ctx:           admin,
+			filter:        Filter{EntityType: EntityAccount, Limit: 1},
+			expectedLimit: 2,
+			expectedCount: 1,
+			expectedToken: Cursor{CreatedAt: createdAt, ID: events[0].ID}.Encode(),
+		},
+		{
+			name:          "Holder is forbidden",
+			ctx:           holder,
+			filter:        Filter{},
+			expectedError: apperr.NewForbiddenError("Audit events require the admin role"),
+		},
+		{
+			name:          "Entity id without entity type",
+			ctx:           admin,
+			filter:        Filter{EntityID: uuid.New()},
+			expectedError: apperr.NewBadRequestError("entity id requires entity type"),
+		},
+		{
+			name:          "Unknown entity type",
+			ctx:           admin,
+			filter:        Filter{EntityType: "transfer"},
+			expectedError: apperr.NewBadRequestError("entity type must be account or subscription"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			repository := &fakeRepository{events: events}
+
+			result, token, err := NewAuditService(repository).ListAuditEvents(tc.ctx, &tc.filter)
+
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedLimit, repository.filter.Limit)
+				assert.Len(t, result, tc.expectedCount)
+				assert.Equal(t, tc.expectedToken, token)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package billing
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	ChargeSucceeded = "succeeded"
+	ChargeFailed    = "failed"
+)
+
+type Charge struct {
+	ID             uuid.UUID `db:"id"`
+	SubscriptionID uuid.UUID `db:"subscription_id"`
+	AccountID      uuid.UUID `db:"account_id"`
+	Amount         int64     `db:"amount"`
+	Currency       string    `db:"currency"`
+	Status         string    `db:"status"`
+	Attempt        int       `db:"attempt"`
+	DueAt          time.Time `db:"due_at"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// NextChargeDate returns the start of the billing period following dueAt.
+// Subscriptions are billed monthly on the day of the month they started on,
+// or on the last day of shorter months: a subscription started on January 31
+// is charged on February 28 and on March 31 again.
+func NextChargeDate(startDate time.Time, dueAt time.Time) time.Time {
+	year, month, _ := dueAt.Date()
+	next := time.Date(year, month+1, 1, 0, 0, 0, 0, dueAt.Location())
+	lastDay := next.AddDate(0, 1, -1).Day()
+
+	day := startDate.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	hour, minute, second := dueAt.Clock()
+	return time.Date(next.Year(), next.Month(), day, hour, minute, second, dueAt.Nanosecond(), dueAt.Location())
+}
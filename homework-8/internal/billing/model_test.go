@@ -0,0 +1,33 @@
+package billing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNextChargeDate(t *testing.T) {
+	t.Parallel()
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		startDate time.Time
+		dueAt     time.Time
+		expected  time.Time
+	}{
+		{name: "Same day next month", startDate: date(2023, 1, 15), dueAt: date(2023, 3, 15), expected: date(2023, 4, 15)},
+		{name: "Clamped to the end of February", startDate: date(2023, 1, 31), dueAt: date(2023, 1, 31), expected: date(2023, 2, 28)},
+		{name: "Back to the anchor day after a short month", startDate: date(2023, 1, 31), dueAt: date(2023, 2, 28), expected: date(2023, 3, 31)},
+		{name: "Clamped to a 30 day month", startDate: date(2023, 1, 31), dueAt: date(2023, 3, 31), expected: date(2023, 4, 30)},
+		{name: "Leap year", startDate: date(2023, 12, 30), dueAt: date(2024, 1, 30), expected: date(2024, 2, 29)},
+		{name: "Across the year", startDate: date(2023, 1, 31), dueAt: date(2023, 12, 31), expected: date(2024, 1, 31)},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, NextChargeDate(tc.startDate, tc.dueAt), tc.name)
+	}
+}
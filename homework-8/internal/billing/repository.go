@@ -0,0 +1,173 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/outbox"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/subscription"
+	"time"
+)
+
+type Repository interface {
+	// ChargeNextDue charges one subscription that is due at now and returns the
+	// recorded charge, or nil when nothing is due.
+	ChargeNextDue(ctx context.Context, now time.Time, retryDelay func(attempt int) time.Duration) (*Charge, error)
+}
+
+type BillingRepository struct {
+	db        database.Database
+	txManager database.TxManager
+	rates     money.RateProvider
+}
+
+func NewBillingRepository(db database.Database, txManager database.TxManager, rates money.RateProvider) *BillingRepository {
+	return &BillingRepository{
+		db:        db,
+		txManager: txManager,
+		rates:     rates,
+	}
+}
+
+// ChargeNextDue claims the due subscription with SKIP LOCKED, so that several
+// replicas running the worker never charge the same subscription twice. The
+// account is locked by the same statement and skipped while another
+// transaction holds it, e.g. one closing the account and ending its
+// subscriptions. Billing therefore never waits for a lock and cannot deadlock
+// with them.
+// The price is converted to the currency of the account; a missing exchange
+// rate fails the charge like insufficient funds and it is retried later, so
+// does a frozen account. Subscriptions of closed accounts are never charged.
+// The changes of the account and of the status of the subscription are written
+// to the outbox in the same transaction.
+func (r *BillingRepository) ChargeNextDue(ctx context.Context, now time.Time, retryDelay func(attempt int) time.Duration) (charge *Charge, err error) {
+	err = r.txManager.Do(ctx, func(ctx context.Context) error {
+		query := `
+			SELECT s.id, s.subscription_name, s.price, s.currency, s.account_id, s.start_date, s.end_date, s.status, s.next_charge_at, s.failed_attempts, 
+			       a.holder_name, a.balance, a.opening_date, a.bank_name, a.version, a.currency, a.owner_id, a.status 
+			FROM subscription s 
+			JOIN bank_account a ON a.id = s.account_id 
+			WHERE COALESCE(s.retry_at, s.next_charge_at) <= $1 
+			  AND (s.end_date IS NULL OR s.end_date > s.next_charge_at) 
+			  AND a.status <> $2 
+			ORDER BY COALESCE(s.retry_at, s.next_charge_at) 
+			LIMIT 1 
+			FOR UPDATE OF s, a SKIP LOCKED`
+
+		var (
+			sub            subscription.Subscription
+			endDate        sql.NullTime
+			dueAt          time.Time
+			failedAttempts int
+		)
+		charged := &model.BankAccount{}
+		err := r.db.QueryRowContext(ctx, query, now, model.StatusClosed).Scan(&sub.ID, &sub.Name, &sub.Price, &sub.Currency, &sub.AccountID, &sub.StartDate, &endDate, &sub.Status, &dueAt, &failedAttempts,
+			&charged.HolderName, &charged.Balance, &charged.OpeningDate, &charged.BankName, &charged.Version, &charged.Currency, &charged.OwnerID, &charged.Status)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return apperr.NewInternalServerError("Internal server error")
+		}
+		charged.ID = sub.AccountID
+		if endDate.Valid {
+			sub.EndDate = endDate.Time
+		}
+		previousStatus := sub.Status
+
+		amount, err := money.Convert(ctx, r.rates, sub.Price, sub.Currency, charged.Currency)
+		rateMissing := errors.Is(err, money.ErrRateNotFound)
+		if err != nil && !rateMissing {
+			return apperr.NewInternalServerError("Internal server error")
+		}
+
+		charge = &Charge{
+			ID:             uuid.New(),
+			SubscriptionID: sub.ID,
+			AccountID:      sub.AccountID,
+			Amount:         amount,
+			Currency:       charged.Currency,
+			Attempt:        failedAttempts + 1,
+			DueAt:          dueAt,
+			CreatedAt:      now,
+		}
+
+		if rateMissing {
+			// keep the price the subscription asked for
+			charge.Amount, charge.Currency = sub.Price, sub.Currency
+		}
+
+		if rateMissing || !charged.CanBeDebited() || charged.Balance < amount {
+			charge.Status = ChargeFailed
+			query = `
+				UPDATE subscription 
+				SET status = $1, failed_attempts = $2, retry_at = $3 
+				WHERE id = $4`
+			_, err = r.db.ExecuteContext(ctx, query, subscription.StatusSuspended, charge.Attempt, now.Add(retryDelay(charge.Attempt)), sub.ID)
+			if err != nil {
+				return apperr.NewInternalServerError("Internal server error")
+			}
+
+			sub.Status = subscription.StatusSuspended
+			if previousStatus != sub.Status {
+				if err = subscription.AddSubscriptionEvent(ctx, r.db, outbox.SubscriptionSuspended, &sub); err != nil {
+					return err
+				}
+			}
+		} else {
+			charge.Status = ChargeSucceeded
+			err = r.db.QueryRowContext(ctx, "UPDATE bank_account SET balance = balance - $1, version = version + 1 WHERE id = $2 RETURNING balance, version", amount, sub.AccountID).
+				Scan(&charged.Balance, &charged.Version)
+			if err != nil {
+				return apperr.NewInternalServerError("Internal server error")
+			}
+
+			entries := model.NewDepositEntries(charged, -amount, fmt.Sprintf("subscription %s", sub.Name), now)
+			if err = account.InsertLedgerEntries(ctx, r.db, entries); err != nil {
+				return err
+			}
+
+			query = `
+				UPDATE subscription 
+				SET status = $1, failed_attempts = 0, retry_at = NULL, next_charge_at = $2 
+				WHERE id = $3`
+			_, err = r.db.ExecuteContext(ctx, query, subscription.StatusActive, NextChargeDate(sub.StartDate, dueAt), sub.ID)
+			if err != nil {
+				return apperr.NewInternalServerError("Internal server error")
+			}
+
+			if err = account.AddAccountEvent(ctx, r.db, outbox.AccountUpdated, charged); err != nil {
+				return err
+			}
+			sub.Status = subscription.StatusActive
+			if previousStatus != sub.Status {
+				if err = subscription.AddSubscriptionEvent(ctx, r.db, outbox.SubscriptionResumed, &sub); err != nil {
+					return err
+				}
+			}
+		}
+
+		query = `
+			INSERT INTO subscription_charge (id, subscription_id, account_id, amount, currency, status, attempt, due_at, created_at) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+		_, err = r.db.ExecuteContext(ctx, query, charge.ID, charge.SubscriptionID, charge.AccountID, charge.Amount, charge.Currency,
+			charge.Status, charge.Attempt, charge.DueAt, charge.CreatedAt)
+		if err != nil {
+			return apperr.NewInternalServerError("Internal server error")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return charge, nil
+}
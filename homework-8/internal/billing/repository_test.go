@@ -0,0 +1,227 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money"
+	mock_money "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money/mocks"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/subscription"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestChargeNextDueRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx            = context.Background()
+		now            = time.Now()
+		dueAt          = now.Add(-time.Hour)
+		subscriptionID = uuid.New()
+		accountID      = uuid.New()
+		retryDelay     = func(attempt int) time.Duration { return time.Duration(attempt) * time.Hour }
+		dueQuery       = `SELECT s.id, .* FROM subscription s\s+JOIN bank_account a ON a.id = s.account_id .* FOR UPDATE OF s, a SKIP LOCKED`
+		dueColumns     = []string{"id", "subscription_name", "price", "currency", "account_id", "start_date", "end_date", "status", "next_charge_at", "failed_attempts",
+			"holder_name", "balance", "opening_date", "bank_name", "version", "currency", "owner_id", "status"}
+	)
+	dueRow := func(price int64, currency string, status string, failedAttempts int, balance int64, accountCurrency string, accountStatus string) *sqlmock.Rows {
+		return sqlmock.NewRows(dueColumns).AddRow(subscriptionID, "Music", price, currency, accountID, dueAt, nil, status, dueAt, failedAttempts,
+			"Dima", balance, dueAt, "Ozon Bank", 1, accountCurrency, "dima", accountStatus)
+	}
+	expectEvent := func(mock sqlmock.Sqlmock, aggregateType string, aggregateID uuid.UUID, eventType string) {
+		mock.ExpectExec(`INSERT INTO outbox`).
+			WithArgs(aggregateType, aggregateID, eventType, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	tests := []struct {
+		name           string
+		mockSQL        func(mock sqlmock.Sqlmock)
+		mockRates      func(rates *mock_money.MockRateProvider)
+		expectedStatus string
+		expectedNil    bool
+	}{
+		{
+			name: "Nothing is due",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(dueQuery).WithArgs(now, "closed").WillReturnRows(sqlmock.NewRows(dueColumns))
+				mock.ExpectCommit()
+			},
+			expectedNil: true,
+		},
+		{
+			name: "Charge succeeded",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(dueQuery).WithArgs(now, "closed").
+					WillReturnRows(dueRow(100, "RUB", subscription.StatusActive, 0, 150, "RUB", "active"))
+				mock.ExpectQuery(`UPDATE bank_account SET balance = balance - \$1, version = version \+ 1 WHERE id = \$2 RETURNING balance, version`).
+					WithArgs(int64(100), accountID).
+					WillReturnRows(sqlmock.NewRows([]string{"balance", "version"}).AddRow(50, 2))
+				mock.ExpectExec(`INSERT INTO ledger_entry`).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO ledger_entry`).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`UPDATE subscription\s+SET status = \$1, failed_attempts = 0, retry_at = NULL, next_charge_at = \$2`).
+					WithArgs(subscription.StatusActive, NextChargeDate(dueAt, dueAt), subscriptionID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEvent(mock, "account", accountID, "account.updated")
+				mock.ExpectExec(`INSERT INTO subscription_charge`).
+					WithArgs(sqlmock.AnyArg(), subscriptionID, accountID, int64(100), "RUB", ChargeSucceeded, 1, dueAt, now).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: ChargeSucceeded,
+		},
+		{
+			name: "Price is converted to the currency of the account",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(dueQuery).WithArgs(now, "closed").
+					WillReturnRows(dueRow(1000, "USD", subscription.StatusActive, 0, 100000, "RUB", "active"))
+				mock.ExpectQuery(`UPDATE bank_account SET balance = balance - \$1`).
+					WithArgs(int64(92500), accountID).
+					WillReturnRows(sqlmock.NewRows([]string{"balance", "version"}).AddRow(7500, 2))
+				mock.ExpectExec(`INSERT INTO ledger_entry`).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO ledger_entry`).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`UPDATE subscription\s+SET status = \$1, failed_attempts = 0`).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEvent(mock, "account", accountID, "account.updated")
+				mock.ExpectExec(`INSERT INTO subscription_charge`).
+					WithArgs(sqlmock.AnyArg(), subscriptionID, accountID, int64(92500), "RUB", ChargeSucceeded, 1, dueAt, now).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			mockRates: func(rates *mock_money.MockRateProvider) {
+				rates.EXPECT().Rate(gomock.Any(), "USD", "RUB").Return(big.NewRat(185, 2), nil)
+			},
+			expectedStatus: ChargeSucceeded,
+		},
+		{
+			name: "Missing exchange rate fails the charge",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(dueQuery).WithArgs(now, "closed").
+					WillReturnRows(dueRow(1000, "USD", subscription.StatusActive, 0, 100000, "RUB", "active"))
+				mock.ExpectExec(`UPDATE subscription\s+SET status = \$1, failed_attempts = \$2, retry_at = \$3`).
+					WithArgs(subscription.StatusSuspended, 1, now.Add(time.Hour), subscriptionID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEvent(mock, "subscription", subscriptionID, "subscription.suspended")
+				mock.ExpectExec(`INSERT INTO subscription_charge`).
+					WithArgs(sqlmock.AnyArg(), subscriptionID, accountID, int64(1000), "USD", ChargeFailed, 1, dueAt, now).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			mockRates: func(rates *mock_money.MockRateProvider) {
+				rates.EXPECT().Rate(gomock.Any(), "USD", "RUB").Return(nil, money.ErrRateNotFound)
+			},
+			expectedStatus: ChargeFailed,
+		},
+		{
+			name: "Insufficient funds suspends the subscription",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(dueQuery).WithArgs(now, "closed").
+					WillReturnRows(dueRow(100, "RUB", subscription.StatusActive, 0, 50, "RUB", "active"))
+				mock.ExpectExec(`UPDATE subscription\s+SET status = \$1, failed_attempts = \$2, retry_at = \$3`).
+					WithArgs(subscription.StatusSuspended, 1, now.Add(time.Hour), subscriptionID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEvent(mock, "subscription", subscriptionID, "subscription.suspended")
+				mock.ExpectExec(`INSERT INTO subscription_charge`).
+					WithArgs(sqlmock.AnyArg(), subscriptionID, accountID, int64(100), "RUB", ChargeFailed, 1, dueAt, now).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: ChargeFailed,
+		},
+		{
+			name: "Failed retry keeps the subscription suspended",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(dueQuery).WithArgs(now, "closed").
+					WillReturnRows(dueRow(100, "RUB", subscription.StatusSuspended, 1, 50, "RUB", "active"))
+				mock.ExpectExec(`UPDATE subscription\s+SET status = \$1, failed_attempts = \$2, retry_at = \$3`).
+					WithArgs(subscription.StatusSuspended, 2, now.Add(2*time.Hour), subscriptionID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO subscription_charge`).
+					WithArgs(sqlmock.AnyArg(), subscriptionID, accountID, int64(100), "RUB", ChargeFailed, 2, dueAt, now).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: ChargeFailed,
+		},
+		{
+			name: "Successful retry resumes the subscription",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(dueQuery).WithArgs(now, "closed").
+					WillReturnRows(dueRow(100, "RUB", subscription.StatusSuspended, 2, 150, "RUB", "active"))
+				mock.ExpectQuery(`UPDATE bank_account SET balance = balance - \$1`).
+					WithArgs(int64(100), accountID).
+					WillReturnRows(sqlmock.NewRows([]string{"balance", "version"}).AddRow(50, 2))
+				mock.ExpectExec(`INSERT INTO ledger_entry`).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`INSERT INTO ledger_entry`).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(`UPDATE subscription\s+SET status = \$1, failed_attempts = 0`).
+					WithArgs(subscription.StatusActive, NextChargeDate(dueAt, dueAt), subscriptionID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEvent(mock, "account", accountID, "account.updated")
+				expectEvent(mock, "subscription", subscriptionID, "subscription.resumed")
+				mock.ExpectExec(`INSERT INTO subscription_charge`).
+					WithArgs(sqlmock.AnyArg(), subscriptionID, accountID, int64(100), "RUB", ChargeSucceeded, 3, dueAt, now).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: ChargeSucceeded,
+		},
+		{
+			name: "Frozen account fails the charge",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(dueQuery).WithArgs(now, "closed").
+					WillReturnRows(dueRow(100, "RUB", subscription.StatusActive, 0, 150, "RUB", "frozen"))
+				mock.ExpectExec(`UPDATE subscription\s+SET status = \$1, failed_attempts = \$2, retry_at = \$3`).
+					WithArgs(subscription.StatusSuspended, 1, now.Add(time.Hour), subscriptionID).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEvent(mock, "subscription", subscriptionID, "subscription.suspended")
+				mock.ExpectExec(`INSERT INTO subscription_charge`).
+					WithArgs(sqlmock.AnyArg(), subscriptionID, accountID, int64(100), "RUB", ChargeFailed, 1, dueAt, now).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: ChargeFailed,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			mockSqlDb, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			db, err := database.InitDBWithPool(mockSqlDb)
+			require.NoError(t, err)
+			tc.mockSQL(mock)
+			rates := mock_money.NewMockRateProvider(gomock.NewController(t))
+			if tc.mockRates != nil {
+				tc.mockRates(rates)
+			}
+
+			charge, err := NewBillingRepository(db, database.NewTxManager(db, sql.LevelDefault, 0), rates).ChargeNextDue(ctx, now, retryDelay)
+
+			require.NoError(t, err)
+			if tc.expectedNil {
+				assert.Nil(t, charge)
+			} else {
+				require.NotNil(t, charge)
+				assert.Equal(t, tc.expectedStatus, charge.Status)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
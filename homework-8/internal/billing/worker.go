@@ -0,0 +1,78 @@
+package billing
+
+import (
+	"context"
+	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
+	"time"
+)
+
+// Backoff grows the delay between charge retries exponentially up to MaxDelay.
+type Backoff struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := b.BaseDelay
+	for i := 1; i < attempt && delay < b.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > b.MaxDelay {
+		return b.MaxDelay
+	}
+	return delay
+}
+
+type Worker struct {
+	repository Repository
+	interval   time.Duration
+	batchSize  int
+	backoff    Backoff
+}
+
+func NewWorker(repository Repository, interval time.Duration, batchSize int, backoff Backoff) *Worker {
+	return &Worker{
+		repository: repository,
+		interval:   interval,
+		batchSize:  batchSize,
+		backoff:    backoff,
+	}
+}
+
+// Run charges due subscriptions every interval until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.ChargeDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ChargeDue charges at most batchSize due subscriptions and returns how many
+// charges were recorded.
+func (w *Worker) ChargeDue(ctx context.Context) int {
+	charged := 0
+	for charged < w.batchSize && ctx.Err() == nil {
+		charge, err := w.repository.ChargeNextDue(ctx, time.Now(), w.backoff.Delay)
+		if err != nil {
+			logging.Errorf(ctx, "billing: cannot charge subscription: %s", err)
+			return charged
+		}
+		if charge == nil {
+			return charged
+		}
+		charged++
+
+		if charge.Status == ChargeFailed {
+			logging.Infof(ctx, "billing: insufficient funds for subscription %s, attempt %d", charge.SubscriptionID, charge.Attempt)
+		}
+	}
+	return charged
+}
@@ -0,0 +1,28 @@
+package billing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	t.Parallel()
+
+	backoff := Backoff{BaseDelay: time.Hour, MaxDelay: 6 * time.Hour}
+
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 1, expected: time.Hour},
+		{attempt: 2, expected: 2 * time.Hour},
+		{attempt: 3, expected: 4 * time.Hour},
+		{attempt: 4, expected: 6 * time.Hour},
+		{attempt: 100, expected: 6 * time.Hour},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, backoff.Delay(tc.attempt), "attempt %d", tc.attempt)
+	}
+}
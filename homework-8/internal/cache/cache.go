@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMiss is returned by Get when the key is not cached or has expired.
+var ErrMiss = errors.New("cache: miss")
+
+// Cache keeps values for a limited time. Values are opaque to the cache;
+// callers encode them, so every implementation stores the same bytes.
+type Cache interface {
+	// Get returns ErrMiss when key is not cached.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value for ttl. A zero ttl keeps the value until it is deleted.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Add stores value like Set unless key is cached already and reports
+	// whether it did.
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Delete drops the keys. Missing keys are ignored.
+	Delete(ctx context.Context, keys ...string) error
+	// Clear drops every key of the cache, e.g. when changes may have been
+	// missed and no cached value can be trusted.
+	Clear(ctx context.Context) error
+}
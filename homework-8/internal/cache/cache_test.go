@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newCaches returns every implementation with a function moving its clock.
+func newCaches(t *testing.T) map[string]struct {
+	cache   Cache
+	advance func(d time.Duration)
+} {
+	now := time.Date(2023, 10, 6, 12, 0, 0, 0, time.UTC)
+	memory := NewMemoryCache()
+	memory.now = func() time.Time { return now }
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return map[string]struct {
+		cache   Cache
+		advance func(d time.Duration)
+	}{
+		"memory": {cache: memory, advance: func(d time.Duration) { now = now.Add(d) }},
+		"redis":  {cache: NewRedisCache(client, "bank:"), advance: server.FastForward},
+	}
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	for name, tc := range newCaches(t) {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			_, err := tc.cache.Get(ctx, "account")
+			assert.ErrorIs(t, err, ErrMiss)
+
+			require.NoError(t, tc.cache.Set(ctx, "account", []byte("balance 1000"), time.Minute))
+			require.NoError(t, tc.cache.Set(ctx, "forever", []byte("kept"), 0))
+			value, err := tc.cache.Get(ctx, "account")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("balance 1000"), value)
+
+			tc.advance(time.Minute)
+			_, err = tc.cache.Get(ctx, "account")
+			assert.ErrorIs(t, err, ErrMiss, "expired")
+			value, err = tc.cache.Get(ctx, "forever")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("kept"), value)
+
+			require.NoError(t, tc.cache.Set(ctx, "account", []byte("balance 700"), time.Minute))
+			require.NoError(t, tc.cache.Delete(ctx, "account", "missing"))
+			_, err = tc.cache.Get(ctx, "account")
+			assert.ErrorIs(t, err, ErrMiss, "deleted")
+
+			added, err := tc.cache.Add(ctx, "forever", []byte("replaced"), time.Minute)
+			require.NoError(t, err)
+			assert.False(t, added, "cached already")
+			added, err = tc.cache.Add(ctx, "account", []byte("balance 500"), time.Minute)
+			require.NoError(t, err)
+			assert.True(t, added)
+			tc.advance(time.Minute)
+			added, err = tc.cache.Add(ctx, "account", []byte("balance 300"), time.Minute)
+			require.NoError(t, err)
+			assert.True(t, added, "expired")
+			value, err = tc.cache.Get(ctx, "account")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("balance 300"), value)
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, tc.cache.Set(ctx, strconv.Itoa(i), []byte("value"), time.Minute))
+			}
+			require.NoError(t, tc.cache.Clear(ctx))
+			for _, key := range []string{"0", "1", "2", "forever"} {
+				_, err = tc.cache.Get(ctx, key)
+				assert.ErrorIs(t, err, ErrMiss, "cleared")
+			}
+		})
+	}
+}
+
+func TestRedisCache_ClearKeepsForeignKeys(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		server = miniredis.RunT(t)
+		client = redis.NewClient(&redis.Options{Addr: server.Addr()})
+		cache  = NewRedisCache(client, "bank:")
+	)
+	defer client.Close()
+
+	require.NoError(t, server.Set("sessions:1", "dima"))
+	require.NoError(t, cache.Set(ctx, "account", []byte("balance 1000"), time.Minute))
+	assert.True(t, server.Exists("bank:account"))
+
+	require.NoError(t, cache.Clear(ctx))
+	assert.False(t, server.Exists("bank:account"))
+	assert.True(t, server.Exists("sessions:1"))
+}
+
+func TestMemoryCache_SweepsExpiredEntries(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		now   = time.Date(2023, 10, 6, 12, 0, 0, 0, time.UTC)
+		cache = NewMemoryCache()
+	)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < minSweepSize-1; i++ {
+		require.NoError(t, cache.Set(ctx, strconv.Itoa(i), []byte("value"), time.Second))
+	}
+	now = now.Add(time.Second)
+	require.NoError(t, cache.Set(ctx, "fresh", []byte("value"), time.Second))
+
+	assert.Len(t, cache.entries, 1, "entries that were never read again are swept")
+}
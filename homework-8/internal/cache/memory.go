@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// minSweepSize is the number of entries below which expired entries are only
+// dropped when they are read.
+const minSweepSize = 1024
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// MemoryCache keeps values in the memory of the process. Every replica has a
+// cache of its own.
+type MemoryCache struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	nextSweep int
+	now       func() time.Time
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries:   make(map[string]memoryEntry),
+		nextSweep: minSweepSize,
+		now:       time.Now,
+	}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	if entry.expired(c.now()) {
+		delete(c.entries, key)
+		return nil, ErrMiss
+	}
+	return entry.value, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value, ttl)
+	return nil
+}
+
+func (c *MemoryCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok && !entry.expired(c.now()) {
+		return false, nil
+	}
+	c.set(key, value, ttl)
+	return true, nil
+}
+
+func (c *MemoryCache) set(key string, value []byte, ttl time.Duration) {
+	now := c.now()
+	entry := memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+	c.entries[key] = entry
+
+	// keys that are never read again would stay forever, so expired entries
+	// are swept each time the cache has doubled in size
+	if len(c.entries) >= c.nextSweep {
+		for key, entry := range c.entries {
+			if entry.expired(now) {
+				delete(c.entries, key)
+			}
+		}
+		c.nextSweep = 2 * len(c.entries)
+		if c.nextSweep < minSweepSize {
+			c.nextSweep = minSweepSize
+		}
+	}
+}
+
+func (c *MemoryCache) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func (c *MemoryCache) Clear(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]memoryEntry)
+	c.nextSweep = minSweepSize
+	return nil
+}
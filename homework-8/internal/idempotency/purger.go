@@ -0,0 +1,65 @@
+package idempotency
+
+import (
+	"context"
+	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
+	"time"
+)
+
+type Repository interface {
+	PurgeExpired(ctx context.Context, now time.Time, limit int) (int, error)
+}
+
+// Purger deletes expired idempotency records. Reserve only takes over the
+// expired record of a reused key, the records of other keys would stay
+// forever.
+type Purger struct {
+	repository Repository
+	interval   time.Duration
+	batchSize  int
+	now        func() time.Time
+}
+
+func NewPurger(repository Repository, interval time.Duration, batchSize int) *Purger {
+	return &Purger{
+		repository: repository,
+		interval:   interval,
+		batchSize:  batchSize,
+		now:        time.Now,
+	}
+}
+
+// Run purges expired records every interval until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.PurgeExpired(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpired deletes the expired records batch by batch, so that no
+// statement holds many rows locked, and returns how many it deleted.
+func (p *Purger) PurgeExpired(ctx context.Context) int {
+	now := p.now()
+	total := 0
+	for ctx.Err() == nil {
+		deleted, err := p.repository.PurgeExpired(ctx, now, p.batchSize)
+		if err != nil {
+			logging.Errorf(ctx, "idempotency: cannot purge expired keys: %s", err)
+			return total
+		}
+		total += deleted
+		if deleted < p.batchSize {
+			return total
+		}
+	}
+	return total
+}
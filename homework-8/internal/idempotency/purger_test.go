@@ -0,0 +1,70 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeRepository struct {
+	expired int
+	err     error
+	limits  []int
+}
+
+func (r *fakeRepository) PurgeExpired(ctx context.Context, now time.Time, limit int) (int, error) {
+	r.limits = append(r.limits, limit)
+	if r.err != nil {
+		return 0, r.err
+	}
+	deleted := limit
+	if r.expired < limit {
+		deleted = r.expired
+	}
+	r.expired -= deleted
+	return deleted, nil
+}
+
+func TestPurger_PurgeExpired(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		repository      *fakeRepository
+		expectedDeleted int
+		expectedBatches int
+	}{
+		{
+			name:            "Deletes expired keys batch by batch",
+			repository:      &fakeRepository{expired: 25},
+			expectedDeleted: 25,
+			expectedBatches: 3,
+		},
+		{
+			name:            "Stops after a full batch with nothing left",
+			repository:      &fakeRepository{expired: 20},
+			expectedDeleted: 20,
+			expectedBatches: 3,
+		},
+		{
+			name:            "Stops at the first failed batch",
+			repository:      &fakeRepository{expired: 25, err: errors.New("database is unavailable")},
+			expectedDeleted: 0,
+			expectedBatches: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			deleted := NewPurger(tc.repository, time.Hour, 10).PurgeExpired(context.Background())
+
+			assert.Equal(t, tc.expectedDeleted, deleted)
+			assert.Len(t, tc.repository.limits, tc.expectedBatches)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"time"
+)
+
+// reserveAttempts bounds the retries when a record disappears between the
+// failed insert and the lookup, e.g. because the original request failed.
+const reserveAttempts = 3
+
+type IdempotencyRepository struct {
+	db database.Database
+}
+
+func NewIdempotencyRepository(db database.Database) *IdempotencyRepository {
+	return &IdempotencyRepository{
+		db: db,
+	}
+}
+
+// Reserve takes over a record that has expired, or whose request has not
+// completed before its lock ran out, e.g. because the server crashed.
+func (r *IdempotencyRepository) Reserve(ctx context.Context, record *app.IdempotencyRecord) (*app.IdempotencyRecord, error) {
+	query := `
+		INSERT INTO idempotency_key (principal, key, method, request_hash, created_at, expires_at, locked_until)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (principal, key, method) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, response = NULL, created_at = EXCLUDED.created_at,
+		    expires_at = EXCLUDED.expires_at, locked_until = EXCLUDED.locked_until
+		WHERE idempotency_key.expires_at < EXCLUDED.created_at
+		   OR idempotency_key.response IS NULL AND idempotency_key.locked_until < EXCLUDED.created_at
+		RETURNING key`
+
+	for i := 0; i < reserveAttempts; i++ {
+		var key string
+		err := r.db.QueryRowContext(ctx, query, record.Principal, record.Key, record.Method, record.RequestHash,
+			record.CreatedAt, record.ExpiresAt, record.LockedUntil).Scan(&key)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, apperr.NewInternalServerError("Internal server error")
+		}
+
+		existing, err := r.get(ctx, record)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return existing, nil
+		}
+	}
+
+	return nil, apperr.NewConflictError("Request with the same idempotency key is still in progress")
+}
+
+func (r *IdempotencyRepository) Complete(ctx context.Context, record *app.IdempotencyRecord, response []byte) error {
+	query := `
+		UPDATE idempotency_key SET response = $1, locked_until = NULL
+		WHERE principal = $2 AND key = $3 AND method = $4`
+	if _, err := r.db.ExecuteContext(ctx, query, response, record.Principal, record.Key, record.Method); err != nil {
+		return apperr.NewInternalServerError("Internal server error")
+	}
+	return nil
+}
+
+func (r *IdempotencyRepository) Release(ctx context.Context, record *app.IdempotencyRecord) error {
+	query := `DELETE FROM idempotency_key WHERE principal = $1 AND key = $2 AND method = $3 AND response IS NULL`
+	if _, err := r.db.ExecuteContext(ctx, query, record.Principal, record.Key, record.Method); err != nil {
+		return apperr.NewInternalServerError("Internal server error")
+	}
+	return nil
+}
+
+// PurgeExpired deletes up to limit records that expired before now and
+// returns how many it deleted.
+func (r *IdempotencyRepository) PurgeExpired(ctx context.Context, now time.Time, limit int) (int, error) {
+	query := `
+		DELETE FROM idempotency_key
+		WHERE ctid IN (SELECT ctid FROM idempotency_key WHERE expires_at < $1 LIMIT $2)`
+	result, err := r.db.ExecuteContext(ctx, query, now, limit)
+	if err != nil {
+		return 0, apperr.NewInternalServerError("Internal server error")
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, apperr.NewInternalServerError("Internal server error")
+	}
+	return int(deleted), nil
+}
+
+func (r *IdempotencyRepository) get(ctx context.Context, record *app.IdempotencyRecord) (*app.IdempotencyRecord, error) {
+	query := `
+		SELECT principal, key, method, request_hash, response, created_at, expires_at, locked_until
+		FROM idempotency_key
+		WHERE principal = $1 AND key = $2 AND method = $3`
+
+	var (
+		existing    app.IdempotencyRecord
+		lockedUntil sql.NullTime
+	)
+	err := r.db.QueryRowContext(ctx, query, record.Principal, record.Key, record.Method).
+		Scan(&existing.Principal, &existing.Key, &existing.Method, &existing.RequestHash, &existing.Response,
+			&existing.CreatedAt, &existing.ExpiresAt, &lockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, apperr.NewInternalServerError("Internal server error")
+	}
+	existing.LockedUntil = lockedUntil.Time
+
+	return &existing, nil
+}
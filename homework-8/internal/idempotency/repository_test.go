@@ -0,0 +1,180 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"testing"
+	"time"
+)
+
+func newRepositoryWithMock(t *testing.T) (*IdempotencyRepository, sqlmock.Sqlmock) {
+	mockSqlDb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := database.InitDBWithPool(mockSqlDb)
+	require.NoError(t, err)
+	return NewIdempotencyRepository(db), mock
+}
+
+func TestReserveRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		now    = time.Date(2023, time.October, 6, 12, 0, 0, 0, time.UTC)
+		record = &app.IdempotencyRecord{
+			Principal:   "alice",
+			Key:         "key",
+			Method:      "/bank_accounts.BankAccountService/CreateBankAccount",
+			RequestHash: "hash",
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(24 * time.Hour),
+			LockedUntil: now.Add(time.Minute),
+		}
+		columns     = []string{"principal", "key", "method", "request_hash", "response", "created_at", "expires_at", "locked_until"}
+		reserveArgs = []driver.Value{record.Principal, record.Key, record.Method, record.RequestHash,
+			record.CreatedAt, record.ExpiresAt, record.LockedUntil}
+	)
+
+	tests := []struct {
+		name     string
+		prepare  func(mock sqlmock.Sqlmock)
+		expected *app.IdempotencyRecord
+		err      error
+	}{
+		{
+			name: "Reserves a new key",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`INSERT INTO idempotency_key`).
+					WithArgs(reserveArgs...).
+					WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow(record.Key))
+			},
+		},
+		{
+			name: "Returns the record that holds the key",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`INSERT INTO idempotency_key`).
+					WithArgs(reserveArgs...).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(`FROM idempotency_key\s+WHERE principal = \$1 AND key = \$2 AND method = \$3`).
+					WithArgs(record.Principal, record.Key, record.Method).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(record.Principal, record.Key, record.Method,
+						"hash", []byte("response"), now, now.Add(24*time.Hour), nil))
+			},
+			expected: &app.IdempotencyRecord{
+				Principal:   record.Principal,
+				Key:         record.Key,
+				Method:      record.Method,
+				RequestHash: "hash",
+				Response:    []byte("response"),
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(24 * time.Hour),
+			},
+		},
+		{
+			name: "Retries when the record disappears before the lookup",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`INSERT INTO idempotency_key`).
+					WithArgs(reserveArgs...).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(`FROM idempotency_key`).
+					WithArgs(record.Principal, record.Key, record.Method).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(`INSERT INTO idempotency_key`).
+					WithArgs(reserveArgs...).
+					WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow(record.Key))
+			},
+		},
+		{
+			name: "Fails on a database error",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`INSERT INTO idempotency_key`).
+					WithArgs(reserveArgs...).
+					WillReturnError(sql.ErrConnDone)
+			},
+			err: apperr.NewInternalServerError("Internal server error"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			repository, mock := newRepositoryWithMock(t)
+			tc.prepare(mock)
+
+			existing, err := repository.Reserve(ctx, record)
+
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.expected, existing)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestReserveRepo_TakesOverExpiredAndAbandonedKeys(t *testing.T) {
+	t.Parallel()
+
+	repository, mock := newRepositoryWithMock(t)
+	mock.ExpectQuery(`ON CONFLICT \(principal, key, method\) DO UPDATE.*` +
+		`WHERE idempotency_key.expires_at < EXCLUDED.created_at\s+` +
+		`OR idempotency_key.response IS NULL AND idempotency_key.locked_until < EXCLUDED.created_at`).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("key"))
+
+	_, err := repository.Reserve(context.Background(), &app.IdempotencyRecord{Key: "key"})
+
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCompleteRepo(t *testing.T) {
+	t.Parallel()
+
+	repository, mock := newRepositoryWithMock(t)
+	record := &app.IdempotencyRecord{Principal: "alice", Key: "key", Method: "method"}
+	mock.ExpectExec(`UPDATE idempotency_key SET response = \$1, locked_until = NULL`).
+		WithArgs([]byte("response"), "alice", "key", "method").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := repository.Complete(context.Background(), record, []byte("response"))
+
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReleaseRepo(t *testing.T) {
+	t.Parallel()
+
+	repository, mock := newRepositoryWithMock(t)
+	record := &app.IdempotencyRecord{Principal: "alice", Key: "key", Method: "method"}
+	mock.ExpectExec(`DELETE FROM idempotency_key WHERE principal = \$1 AND key = \$2 AND method = \$3 AND response IS NULL`).
+		WithArgs("alice", "key", "method").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := repository.Release(context.Background(), record)
+
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeExpiredRepo(t *testing.T) {
+	t.Parallel()
+
+	repository, mock := newRepositoryWithMock(t)
+	now := time.Now()
+	mock.ExpectExec(`DELETE FROM idempotency_key\s+WHERE ctid IN \(SELECT ctid FROM idempotency_key WHERE expires_at < \$1 LIMIT \$2\)`).
+		WithArgs(now, 100).
+		WillReturnResult(sqlmock.NewResult(0, 42))
+
+	deleted, err := repository.PurgeExpired(context.Background(), now, 100)
+
+	require.NoError(t, err)
+	assert.Equal(t, 42, deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
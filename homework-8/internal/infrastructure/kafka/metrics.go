@@ -0,0 +1,19 @@
+package kafka
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	sendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kafka_producer_send_duration_seconds",
+		Help:    "Time until a message is acknowledged or fails by topic.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"topic"})
+
+	sendFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_producer_send_failures_total",
+		Help: "Number of messages that could not be sent by topic.",
+	}, []string{"topic"})
+)
@@ -1,53 +1,114 @@
 package kafka
 
 import (
+	"context"
 	"github.com/IBM/sarama"
 	"github.com/pkg/errors"
+	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
+	"time"
 )
 
 type Producer struct {
 	brokers      []string
+	client       sarama.Client
 	syncProducer sarama.SyncProducer
 }
 
-func newSyncLogger(brokers []string) (sarama.SyncProducer, error) {
+func newSyncLogger(brokers []string) (sarama.Client, sarama.SyncProducer, error) {
 	syncProducerConfig := sarama.NewConfig()
 
-	syncProducerConfig.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	syncProducerConfig.Producer.Partitioner = sarama.NewHashPartitioner
 	syncProducerConfig.Producer.RequiredAcks = sarama.WaitForAll
 	syncProducerConfig.Producer.Return.Successes = true
 	syncProducerConfig.Producer.Return.Errors = true
 	syncProducerConfig.Producer.Idempotent = true
 	syncProducerConfig.Net.MaxOpenRequests = 1
 
-	syncProducer, err := sarama.NewSyncProducer(brokers, syncProducerConfig)
+	client, err := sarama.NewClient(brokers, syncProducerConfig)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error occured during connection to infrastructure brokers")
+	}
+
+	syncProducer, err := sarama.NewSyncProducerFromClient(client)
 
 	if err != nil {
-		return nil, errors.Wrap(err, "error occured during creating sync infrastructure producer")
+		_ = client.Close()
+		return nil, nil, errors.Wrap(err, "error occured during creating sync infrastructure producer")
 	}
 
-	return syncProducer, nil
+	return client, syncProducer, nil
 }
 
 func NewProducer(brokers []string) (*Producer, error) {
-	syncProducer, err := newSyncLogger(brokers)
+	client, syncProducer, err := newSyncLogger(brokers)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Producer{
 		brokers:      brokers,
+		client:       client,
 		syncProducer: syncProducer,
 	}, nil
 }
 
+const (
+	connectBaseDelay = time.Second
+	connectMaxDelay  = 30 * time.Second
+)
+
+// ConnectProducer retries NewProducer with a growing delay until the brokers
+// can be reached, so that a Kafka outage at startup is waited out. It fails
+// only when ctx is done.
+func ConnectProducer(ctx context.Context, brokers []string) (*Producer, error) {
+	delay := connectBaseDelay
+	for {
+		producer, err := NewProducer(brokers)
+		if err == nil {
+			return producer, nil
+		}
+		logging.Errorf(ctx, "cannot connect to kafka, retrying in %s: %s", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > connectMaxDelay {
+			delay = connectMaxDelay
+		}
+	}
+}
+
 func (k *Producer) SendSyncMessage(message *sarama.ProducerMessage) (partition int32, offset int64, err error) {
-	return k.syncProducer.SendMessage(message)
+	start := time.Now()
+	partition, offset, err = k.syncProducer.SendMessage(message)
+
+	sendDuration.WithLabelValues(message.Topic).Observe(time.Since(start).Seconds())
+	if err != nil {
+		sendFailures.WithLabelValues(message.Topic).Inc()
+	}
+	return partition, offset, err
 }
 
+// Ping refreshes the cluster metadata, which fails when no broker is
+// reachable.
+func (k *Producer) Ping() error {
+	if err := k.client.RefreshMetadata(); err != nil {
+		return errors.Wrap(err, "infrastructure.Connector.Ping")
+	}
+	return nil
+}
+
+// Close flushes the messages in flight. The client is closed after the
+// producer, the producer does not close a client it was built from.
 func (k *Producer) Close() error {
 	err := k.syncProducer.Close()
 	if err != nil {
+		_ = k.client.Close()
+		return errors.Wrap(err, "infrastructure.Connector.Close")
+	}
+	if err := k.client.Close(); err != nil {
 		return errors.Wrap(err, "infrastructure.Connector.Close")
 	}
 	return nil
@@ -0,0 +1,57 @@
+package money
+
+import (
+	"errors"
+	"strings"
+)
+
+// minorUnits maps the supported ISO-4217 currency codes to the number of
+// digits after the decimal separator. Amounts are stored in minor units,
+// e.g. kopecks for RUB and whole yen for JPY.
+var minorUnits = map[string]int{
+	"AMD": 2,
+	"BYN": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"KWD": 3,
+	"KZT": 2,
+	"RUB": 2,
+	"TRY": 2,
+	"USD": 2,
+}
+
+// DefaultCurrency is used for accounts created without a currency. Accounts
+// that existed before multi-currency support hold roubles.
+const DefaultCurrency = "RUB"
+
+var ErrUnknownCurrency = errors.New("must be a supported ISO 4217 currency code")
+
+// MinorUnits returns the number of minor unit digits of the currency.
+func MinorUnits(currency string) (int, error) {
+	digits, ok := minorUnits[currency]
+	if !ok {
+		return 0, ErrUnknownCurrency
+	}
+	return digits, nil
+}
+
+// ValidateCurrency is an ozzo-validation rule for currency fields. Empty
+// values are left to validation.Required.
+func ValidateCurrency(value interface{}) error {
+	currency, _ := value.(string)
+	if currency == "" {
+		return nil
+	}
+	if _, err := MinorUnits(currency); err != nil {
+		return err
+	}
+	return nil
+}
+
+// NormalizeCurrency upper-cases a currency code received from a client.
+func NormalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
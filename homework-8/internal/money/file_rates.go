@@ -0,0 +1,63 @@
+package money
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// FileRateProvider serves rates loaded from a JSON file of the form
+//
+//	{"USD/RUB": "92.50", "EUR/RUB": "99.80"}
+//
+// where "USD/RUB" is the price of one dollar in roubles. Inverse rates are
+// derived, so every pair has to be listed only once.
+type FileRateProvider struct {
+	rates map[string]*big.Rat
+}
+
+func NewFileRateProvider(path string) (*FileRateProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse rates file %s: %w", path, err)
+	}
+
+	rates := make(map[string]*big.Rat, 2*len(raw))
+	for pair, value := range raw {
+		from, to, ok := strings.Cut(pair, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid currency pair %q", pair)
+		}
+		for _, currency := range []string{from, to} {
+			if _, err := MinorUnits(currency); err != nil {
+				return nil, fmt.Errorf("invalid currency pair %q: %w", pair, err)
+			}
+		}
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate %q of %s", value, pair)
+		}
+		rates[from+"/"+to] = rate
+		if _, ok := raw[to+"/"+from]; !ok {
+			rates[to+"/"+from] = new(big.Rat).Inv(rate)
+		}
+	}
+
+	return &FileRateProvider{rates: rates}, nil
+}
+
+func (p *FileRateProvider) Rate(ctx context.Context, from string, to string) (*big.Rat, error) {
+	rate, ok := p.rates[from+"/"+to]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%s", ErrRateNotFound, from, to)
+	}
+	return new(big.Rat).Set(rate), nil
+}
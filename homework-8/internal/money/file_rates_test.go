@@ -0,0 +1,64 @@
+package money
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRates(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestFileRateProvider(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	provider, err := NewFileRateProvider(writeRates(t, `{"USD/RUB": "92.50", "EUR/USD": "1.08", "USD/EUR": "0.93"}`))
+	require.NoError(t, err)
+
+	rate, err := provider.Rate(ctx, "USD", "RUB")
+	require.NoError(t, err)
+	assert.Equal(t, big.NewRat(185, 2), rate)
+
+	rate, err = provider.Rate(ctx, "RUB", "USD")
+	require.NoError(t, err)
+	assert.Equal(t, big.NewRat(2, 185), rate, "inverse rate is derived")
+
+	rate, err = provider.Rate(ctx, "USD", "EUR")
+	require.NoError(t, err)
+	assert.Equal(t, big.NewRat(93, 100), rate, "listed rate wins over the derived one")
+
+	_, err = provider.Rate(ctx, "RUB", "EUR")
+	assert.ErrorIs(t, err, ErrRateNotFound)
+}
+
+func TestNewFileRateProvider_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "Malformed JSON", content: `{"USD/RUB": 92.50`},
+		{name: "Pair without separator", content: `{"USDRUB": "92.50"}`},
+		{name: "Unknown currency", content: `{"USD/XXX": "92.50"}`},
+		{name: "Non positive rate", content: `{"USD/RUB": "0"}`},
+		{name: "Not a number", content: `{"USD/RUB": "ninety"}`},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := NewFileRateProvider(writeRates(t, tc.content))
+			assert.Error(t, err)
+		})
+	}
+}
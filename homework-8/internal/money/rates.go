@@ -0,0 +1,66 @@
+//go:generate mockgen -source ./rates.go -destination=./mocks/rates.go -package=mock_money
+
+package money
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+type RateProvider interface {
+	// Rate returns the price of one major unit of from in major units of to.
+	Rate(ctx context.Context, from string, to string) (*big.Rat, error)
+}
+
+// Convert converts an amount in minor units of from into minor units of to.
+// The result is rounded half away from zero.
+func Convert(ctx context.Context, rates RateProvider, amount int64, from string, to string) (int64, error) {
+	if from == to {
+		return amount, nil
+	}
+
+	fromDigits, err := MinorUnits(from)
+	if err != nil {
+		return 0, err
+	}
+	toDigits, err := MinorUnits(to)
+	if err != nil {
+		return 0, err
+	}
+
+	rate, err := rates.Rate(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	converted := new(big.Rat).SetInt64(amount)
+	converted.Mul(converted, rate)
+	converted.Mul(converted, pow10(toDigits))
+	converted.Quo(converted, pow10(fromDigits))
+
+	result := round(converted)
+	if !result.IsInt64() {
+		return 0, fmt.Errorf("converted amount of %d %s does not fit into %s", amount, from, to)
+	}
+	return result.Int64(), nil
+}
+
+func pow10(n int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil))
+}
+
+func round(value *big.Rat) *big.Int {
+	num := new(big.Int).Abs(value.Num())
+	quo, rem := new(big.Int).QuoRem(num, value.Denom(), new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(value.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+	if value.Sign() < 0 {
+		quo.Neg(quo)
+	}
+	return quo
+}
@@ -0,0 +1,110 @@
+package money
+
+import (
+	"context"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	mock_money "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money/mocks"
+	"math/big"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		amount        int64
+		from          string
+		to            string
+		rate          *big.Rat
+		rateErr       error
+		expected      int64
+		expectedError error
+	}{
+		{
+			name:     "Same currency",
+			amount:   12345,
+			from:     "RUB",
+			to:       "RUB",
+			expected: 12345,
+		},
+		{
+			name:     "Dollars to roubles",
+			amount:   1050,
+			from:     "USD",
+			to:       "RUB",
+			rate:     big.NewRat(9250, 100),
+			expected: 97125,
+		},
+		{
+			name:     "Rounds half away from zero",
+			amount:   1,
+			from:     "RUB",
+			to:       "USD",
+			rate:     big.NewRat(1, 2),
+			expected: 1,
+		},
+		{
+			name:     "Negative amount",
+			amount:   -1,
+			from:     "RUB",
+			to:       "USD",
+			rate:     big.NewRat(1, 2),
+			expected: -1,
+		},
+		{
+			name:     "Currency without minor units",
+			amount:   100,
+			from:     "USD",
+			to:       "JPY",
+			rate:     big.NewRat(150, 1),
+			expected: 150,
+		},
+		{
+			name:     "Currency with three minor digits",
+			amount:   100,
+			from:     "KWD",
+			to:       "USD",
+			rate:     big.NewRat(325, 100),
+			expected: 33,
+		},
+		{
+			name:          "Unknown currency",
+			amount:        100,
+			from:          "XXX",
+			to:            "USD",
+			expectedError: ErrUnknownCurrency,
+		},
+		{
+			name:          "Rate not found",
+			amount:        100,
+			from:          "USD",
+			to:            "TRY",
+			rateErr:       ErrRateNotFound,
+			expectedError: ErrRateNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			rates := mock_money.NewMockRateProvider(gomock.NewController(t))
+			if tc.rate != nil || tc.rateErr != nil {
+				rates.EXPECT().Rate(ctx, tc.from, tc.to).Return(tc.rate, tc.rateErr)
+			}
+
+			result, err := Convert(ctx, rates, tc.amount, tc.from, tc.to)
+
+			if tc.expectedError != nil {
+				assert.ErrorIs(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, result)
+			}
+		})
+	}
+}
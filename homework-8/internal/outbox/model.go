@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+const (
+	AggregateAccount      = "account"
+	AggregateSubscription = "subscription"
+)
+
+const (
+	AccountCreated        = "account.created"
+	AccountUpdated        = "account.updated"
+	AccountDeleted        = "account.deleted"
+	AccountClosed         = "account.closed"
+	AccountFrozen         = "account.frozen"
+	AccountUnfrozen       = "account.unfrozen"
+	SubscriptionCreated   = "subscription.created"
+	SubscriptionUpdated   = "subscription.updated"
+	SubscriptionCancelled = "subscription.cancelled"
+	SubscriptionSuspended = "subscription.suspended"
+	SubscriptionResumed   = "subscription.resumed"
+)
+
+// Event is a domain event stored in the same transaction as the change it
+// describes and published to Kafka later by the Relay.
+type Event struct {
+	ID            int64     `db:"id"`
+	AggregateType string    `db:"aggregate_type"`
+	AggregateID   uuid.UUID `db:"aggregate_id"`
+	EventType     string    `db:"event_type"`
+	Payload       []byte    `db:"payload"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+// NewEvent serializes payload with protojson, so consumers get the same JSON
+// as the gateway returns.
+func NewEvent(aggregateType string, aggregateID uuid.UUID, eventType string, payload proto.Message) (Event, error) {
+	body, err := protojson.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		EventType:     eventType,
+		Payload:       body,
+		CreatedAt:     time.Now(),
+	}, nil
+}
@@ -0,0 +1,89 @@
+package outbox
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	logging "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/logging"
+	"time"
+)
+
+type Producer interface {
+	SendSyncMessage(message *sarama.ProducerMessage) (partition int32, offset int64, err error)
+}
+
+// Relay publishes outbox events to Kafka. Events are marked as published only
+// after the broker acknowledged them, so delivery is at-least-once.
+type Relay struct {
+	repository Repository
+	producer   Producer
+	topic      string
+	interval   time.Duration
+	batchSize  int
+}
+
+func NewRelay(repository Repository, producer Producer, topic string, interval time.Duration, batchSize int) *Relay {
+	return &Relay{
+		repository: repository,
+		producer:   producer,
+		topic:      topic,
+		interval:   interval,
+		batchSize:  batchSize,
+	}
+}
+
+// Run publishes pending events every interval until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.PublishPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublishPending drains the outbox batch by batch and returns how many events
+// were published.
+func (r *Relay) PublishPending(ctx context.Context) int {
+	total := 0
+	for ctx.Err() == nil {
+		published, err := r.repository.PublishPending(ctx, r.batchSize, r.publish)
+		if err != nil {
+			logging.Errorf(ctx, "outbox: cannot publish events: %s", err)
+			return total
+		}
+		total += published
+		if published < r.batchSize {
+			return total
+		}
+	}
+	return total
+}
+
+func (r *Relay) publish(event Event) error {
+	_, _, err := r.producer.SendSyncMessage(NewMessage(r.topic, event))
+	if err != nil {
+		logging.Errorf(context.Background(), "outbox: cannot publish event %d: %s", event.ID, err)
+	}
+	return err
+}
+
+// NewMessage keys the message by the aggregate id, so all events of one
+// aggregate land in the same partition and keep their order.
+func NewMessage(topic string, event Event) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(event.AggregateID.String()),
+		Value: sarama.ByteEncoder(event.Payload),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("event-type"), Value: []byte(event.EventType)},
+			{Key: []byte("aggregate-type"), Value: []byte(event.AggregateType)},
+		},
+		Timestamp: event.CreatedAt,
+	}
+}
@@ -0,0 +1,123 @@
+package outbox
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+)
+
+// relayLockID is the advisory lock taken by the relay. Only one replica
+// publishes at a time, which keeps the events of an aggregate in order.
+const relayLockID = 7_001
+
+// Add stores events inside the transaction that changed the aggregates, so ctx
+// must carry that transaction.
+func Add(ctx context.Context, db database.Database, events ...Event) error {
+	query := `
+		INSERT INTO outbox (aggregate_type, aggregate_id, event_type, payload, created_at) 
+		VALUES ($1, $2, $3, $4, $5)`
+
+	for _, event := range events {
+		_, err := db.ExecuteContext(ctx, query, event.AggregateType, event.AggregateID, event.EventType, event.Payload, event.CreatedAt)
+		if err != nil {
+			return apperr.NewInternalServerError("Internal server error")
+		}
+	}
+
+	return nil
+}
+
+// AddBatch is Add for many events at once, e.g. of a bulk import. It loads
+// them with COPY.
+func AddBatch(ctx context.Context, db database.Database, events []Event) error {
+	rows := make([][]interface{}, len(events))
+	for i, event := range events {
+		rows[i] = []interface{}{event.AggregateType, event.AggregateID, event.EventType, event.Payload, event.CreatedAt}
+	}
+
+	err := db.CopyInContext(ctx, "outbox", []string{"aggregate_type", "aggregate_id", "event_type", "payload", "created_at"}, rows)
+	if err != nil {
+		return apperr.NewInternalServerError("Internal server error")
+	}
+	return nil
+}
+
+type Repository interface {
+	// PublishPending passes unpublished events to publish in creation order and
+	// marks the ones that were published. It stops at the first failed publish,
+	// so the rest is retried by the next call.
+	PublishPending(ctx context.Context, limit int, publish func(Event) error) (int, error)
+}
+
+type OutboxRepository struct {
+	db        database.Database
+	txManager database.TxManager
+}
+
+func NewOutboxRepository(db database.Database, txManager database.TxManager) *OutboxRepository {
+	return &OutboxRepository{
+		db:        db,
+		txManager: txManager,
+	}
+}
+
+func (r *OutboxRepository) PublishPending(ctx context.Context, limit int, publish func(Event) error) (published int, err error) {
+	err = r.txManager.Do(ctx, func(ctx context.Context) error {
+		var locked bool
+		if err := r.db.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", relayLockID).Scan(&locked); err != nil {
+			return apperr.NewInternalServerError("Internal server error")
+		}
+		if !locked {
+			return nil
+		}
+
+		query := `
+			SELECT id, aggregate_type, aggregate_id, event_type, payload, created_at 
+			FROM outbox 
+			WHERE published_at IS NULL 
+			ORDER BY id 
+			LIMIT $1`
+
+		rows, err := r.db.QueryRowsContext(ctx, query, limit)
+		if err != nil {
+			return apperr.NewInternalServerError("Internal server error")
+		}
+		var events []Event
+		for rows.Next() {
+			var event Event
+			if err = rows.Scan(&event.ID, &event.AggregateType, &event.AggregateID, &event.EventType, &event.Payload, &event.CreatedAt); err != nil {
+				rows.Close()
+				return apperr.NewInternalServerError("Internal server error")
+			}
+			events = append(events, event)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return apperr.NewInternalServerError("Internal server error")
+		}
+
+		var ids []int64
+		for _, event := range events {
+			if publish(event) != nil {
+				break
+			}
+			ids = append(ids, event.ID)
+		}
+
+		if len(ids) > 0 {
+			_, err = r.db.ExecuteContext(ctx, "UPDATE outbox SET published_at = now() WHERE id = ANY($1)", pq.Array(ids))
+			if err != nil {
+				return apperr.NewInternalServerError("Internal server error")
+			}
+		}
+
+		published = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return published, nil
+}
@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"testing"
+	"time"
+)
+
+func TestPublishPendingRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx         = context.Background()
+		aggregateID = uuid.New()
+		columns     = []string{"id", "aggregate_type", "aggregate_id", "event_type", "payload", "created_at"}
+	)
+
+	tests := []struct {
+		name              string
+		locked            bool
+		failOn            int64
+		expectedPublished int
+		expectedMarked    []int64
+	}{
+		{
+			name:              "Publishes all pending events",
+			locked:            true,
+			expectedPublished: 2,
+			expectedMarked:    []int64{1, 2},
+		},
+		{
+			name:              "Stops at the first failed event",
+			locked:            true,
+			failOn:            2,
+			expectedPublished: 1,
+			expectedMarked:    []int64{1},
+		},
+		{
+			name:              "Another replica holds the lock",
+			locked:            false,
+			expectedPublished: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			mockSqlDb, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			db, err := database.InitDBWithPool(mockSqlDb)
+			require.NoError(t, err)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT pg_try_advisory_xact_lock\(\$1\)`).
+				WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(tc.locked))
+			if tc.locked {
+				mock.ExpectQuery(`FROM outbox\s+WHERE published_at IS NULL`).
+					WithArgs(10).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(1, AggregateAccount, aggregateID, AccountCreated, []byte(`{}`), time.Now()).
+						AddRow(2, AggregateAccount, aggregateID, AccountUpdated, []byte(`{}`), time.Now()))
+				mock.ExpectExec(`UPDATE outbox SET published_at = now\(\) WHERE id = ANY\(\$1\)`).
+					WithArgs(pq.Array(tc.expectedMarked)).
+					WillReturnResult(sqlmock.NewResult(0, int64(len(tc.expectedMarked))))
+			}
+			mock.ExpectCommit()
+
+			var published []string
+			count, err := NewOutboxRepository(db, database.NewTxManager(db, sql.LevelDefault, 0)).PublishPending(ctx, 10, func(event Event) error {
+				if event.ID == tc.failOn {
+					return errors.New("kafka is unavailable")
+				}
+				published = append(published, event.EventType)
+				return nil
+			})
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedPublished, count)
+			assert.Len(t, published, tc.expectedPublished)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
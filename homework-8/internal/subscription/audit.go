@@ -0,0 +1,86 @@
+package subscription
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/audit"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/subscriptions"
+	"time"
+)
+
+// AuditedService records every change made through the wrapped Service in the
+// audit log, in the transaction of the change. Reads are passed through.
+type AuditedService struct {
+	Service
+	txManager database.TxManager
+	auditLog  audit.Repository
+}
+
+func NewAuditedService(service Service, txManager database.TxManager, auditLog audit.Repository) *AuditedService {
+	return &AuditedService{
+		Service:   service,
+		txManager: txManager,
+		auditLog:  auditLog,
+	}
+}
+
+func (s *AuditedService) CreateSubscription(ctx context.Context, subscription Subscription) (created *Subscription, err error) {
+	err = s.txManager.Do(ctx, func(ctx context.Context) error {
+		if created, err = s.Service.CreateSubscription(ctx, subscription); err != nil {
+			return err
+		}
+		return s.record(ctx, "CreateSubscription", nil, created)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *AuditedService) UpdateSubscription(ctx context.Context, id uuid.UUID, subscription Subscription) (*Subscription, error) {
+	return s.change(ctx, "UpdateSubscription", id, func(ctx context.Context) (*Subscription, error) {
+		return s.Service.UpdateSubscription(ctx, id, subscription)
+	})
+}
+
+func (s *AuditedService) CancelSubscription(ctx context.Context, id uuid.UUID, endDate time.Time) (*Subscription, error) {
+	return s.change(ctx, "CancelSubscription", id, func(ctx context.Context) (*Subscription, error) {
+		return s.Service.CancelSubscription(ctx, id, endDate)
+	})
+}
+
+// change locks the subscription before reading it, so that the state recorded
+// as before is the one fn changes in the same transaction.
+func (s *AuditedService) change(ctx context.Context, method string, id uuid.UUID, fn func(ctx context.Context) (*Subscription, error)) (changed *Subscription, err error) {
+	err = s.txManager.Do(ctx, func(ctx context.Context) error {
+		before, err := s.Service.LockSubscription(ctx, id)
+		if err != nil {
+			return err
+		}
+		if changed, err = fn(ctx); err != nil {
+			return err
+		}
+		return s.record(ctx, method, before, changed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+func (s *AuditedService) record(ctx context.Context, method string, before *Subscription, after *Subscription) error {
+	var beforeDto *subscriptions.SubscriptionDto
+	if before != nil {
+		beforeDto = before.MapToDto()
+	}
+	event, err := audit.NewEvent(ctx, audit.EntitySubscription, after.ID, method, beforeDto, after.MapToDto())
+	if err != nil {
+		return apperr.NewInternalServerError("Internal server error")
+	}
+	if event == nil {
+		return nil
+	}
+	return s.auditLog.AddEvents(ctx, *event)
+}
@@ -0,0 +1,65 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/audit"
+	mock_audit "gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/audit/mocks"
+	"testing"
+	"time"
+)
+
+func TestAuditedService_CancelSubscription(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		id        = uuid.New()
+		accountID = uuid.New()
+		startDate = time.Now().Add(-24 * time.Hour)
+		endDate   = time.Now()
+		columns   = []string{"id", "subscription_name", "price", "start_date", "end_date", "account_id", "status", "currency"}
+	)
+
+	mockSqlDb, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := database.InitDBWithPool(mockSqlDb)
+	require.NoError(t, err)
+	txManager := database.NewTxManager(db, sql.LevelDefault, 0)
+	auditLog := mock_audit.NewMockRepository(gomock.NewController(t))
+	audited := NewAuditedService(NewSubscriptionService(NewSubscriptionRepository(db, txManager)), txManager, auditLog)
+
+	subscriptionRow := func(end interface{}) *sqlmock.Rows {
+		return sqlmock.NewRows(columns).AddRow(id, "Music", 100, startDate, end, accountID, StatusActive, "RUB")
+	}
+
+	mock.ExpectBegin()
+	// the state recorded as before is read under the locks of the change
+	mock.ExpectQuery(`FROM subscription\s+WHERE id = \$1$`).WithArgs(id).WillReturnRows(subscriptionRow(nil))
+	mock.ExpectQuery(`SELECT currency, status FROM bank_account WHERE id = \$1 FOR UPDATE`).
+		WithArgs(accountID).
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "status"}).AddRow("RUB", "active"))
+	mock.ExpectQuery(`FROM subscription\s+WHERE id = \$1\s+FOR UPDATE`).WithArgs(id).WillReturnRows(subscriptionRow(nil))
+	mock.ExpectQuery(`FROM subscription\s+WHERE id = \$1\s+FOR UPDATE`).WithArgs(id).WillReturnRows(subscriptionRow(nil))
+	mock.ExpectQuery(`UPDATE subscription\s+SET end_date = \$1`).WithArgs(endDate, id).WillReturnRows(subscriptionRow(endDate))
+	mock.ExpectExec(`INSERT INTO outbox`).WillReturnResult(sqlmock.NewResult(1, 1))
+	auditLog.EXPECT().AddEvents(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, events ...audit.Event) error {
+		require.Len(t, events, 1)
+		assert.Equal(t, "CancelSubscription", events[0].Method)
+		assert.Contains(t, events[0].Diff, "endDate")
+		return nil
+	})
+	mock.ExpectCommit()
+
+	cancelled, err := audited.CancelSubscription(ctx, id, endDate)
+
+	require.NoError(t, err)
+	assert.Equal(t, endDate, cancelled.EndDate)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
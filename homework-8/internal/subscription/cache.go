@@ -0,0 +1,54 @@
+package subscription
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+// AccountInvalidator drops cached bank accounts, which are read together with
+// their subscriptions.
+type AccountInvalidator interface {
+	Invalidate(ctx context.Context, ids ...uuid.UUID)
+}
+
+// InvalidatingRepository drops the cached account of every subscription
+// changed through the wrapped Repository.
+type InvalidatingRepository struct {
+	Repository
+	accounts AccountInvalidator
+}
+
+func NewInvalidatingRepository(repository Repository, accounts AccountInvalidator) *InvalidatingRepository {
+	return &InvalidatingRepository{
+		Repository: repository,
+		accounts:   accounts,
+	}
+}
+
+func (r *InvalidatingRepository) CreateSubscription(ctx context.Context, subscription Subscription) (*Subscription, error) {
+	created, err := r.Repository.CreateSubscription(ctx, subscription)
+	if err != nil {
+		return nil, err
+	}
+	r.accounts.Invalidate(ctx, created.AccountID)
+	return created, nil
+}
+
+func (r *InvalidatingRepository) UpdateSubscription(ctx context.Context, id uuid.UUID, subscription Subscription) (*Subscription, error) {
+	updated, err := r.Repository.UpdateSubscription(ctx, id, subscription)
+	if err != nil {
+		return nil, err
+	}
+	r.accounts.Invalidate(ctx, updated.AccountID)
+	return updated, nil
+}
+
+func (r *InvalidatingRepository) CancelSubscription(ctx context.Context, id uuid.UUID, endDate time.Time) (*Subscription, error) {
+	cancelled, err := r.Repository.CancelSubscription(ctx, id, endDate)
+	if err != nil {
+		return nil, err
+	}
+	r.accounts.Invalidate(ctx, cancelled.AccountID)
+	return cancelled, nil
+}
@@ -0,0 +1,68 @@
+package subscription
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"testing"
+	"time"
+)
+
+type fakeRepository struct {
+	Repository
+	subscription *Subscription
+	err          error
+}
+
+func (r fakeRepository) CreateSubscription(ctx context.Context, subscription Subscription) (*Subscription, error) {
+	return r.subscription, r.err
+}
+
+func (r fakeRepository) CancelSubscription(ctx context.Context, id uuid.UUID, endDate time.Time) (*Subscription, error) {
+	return r.subscription, r.err
+}
+
+type fakeInvalidator struct {
+	ids []uuid.UUID
+}
+
+func (i *fakeInvalidator) Invalidate(ctx context.Context, ids ...uuid.UUID) {
+	i.ids = append(i.ids, ids...)
+}
+
+func TestInvalidatingRepository(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx          = context.Background()
+		accountID    = uuid.New()
+		subscription = &Subscription{ID: uuid.New(), Name: "Music", Price: 100, AccountID: accountID}
+	)
+
+	t.Run("Change drops the account", func(t *testing.T) {
+		t.Parallel()
+		accounts := &fakeInvalidator{}
+		repo := NewInvalidatingRepository(fakeRepository{subscription: subscription}, accounts)
+
+		_, err := repo.CreateSubscription(ctx, *subscription)
+		require.NoError(t, err)
+		_, err = repo.CancelSubscription(ctx, subscription.ID, time.Now())
+		require.NoError(t, err)
+
+		assert.Equal(t, []uuid.UUID{accountID, accountID}, accounts.ids)
+	})
+
+	t.Run("Failed change keeps the account", func(t *testing.T) {
+		t.Parallel()
+		accounts := &fakeInvalidator{}
+		failure := apperr.NewNotFoundError("Subscription not found")
+		repo := NewInvalidatingRepository(fakeRepository{err: failure}, accounts)
+
+		_, err := repo.CancelSubscription(ctx, subscription.ID, time.Now())
+
+		assert.Equal(t, failure, err)
+		assert.Empty(t, accounts.ids)
+	})
+}
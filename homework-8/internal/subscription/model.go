@@ -3,54 +3,83 @@ package subscription
 import (
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/subscriptions"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"time"
 )
 
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+)
+
 type Subscription struct {
 	ID        uuid.UUID `db:"id"`
 	Name      string    `db:"subscription_name"`
-	Price     int       `db:"price"`
+	Price     int64     `db:"price"`
 	StartDate time.Time `db:"start_date"`
 	EndDate   time.Time `db:"end_date"`
 	AccountID uuid.UUID `db:"account_id"`
+	Status    string    `db:"status"`
+	Currency  string    `db:"currency"`
 }
 
 func (s Subscription) Validate() error {
 	return validation.ValidateStruct(&s,
 		validation.Field(&s.Name, validation.Required),
-		validation.Field(&s.Price, validation.Required, validation.Min(0)),
+		validation.Field(&s.Price, validation.Required, validation.Min(int64(0))),
+		// an empty currency means the currency of the account
+		validation.Field(&s.Currency, validation.By(money.ValidateCurrency)),
 		validation.Field(&s.EndDate, validation.Min(time.Now())),
 	)
 }
 
+// IsActive reports whether the subscription has not ended by the given moment.
+// A zero EndDate means the subscription is open-ended.
+func (s Subscription) IsActive(now time.Time) bool {
+	return s.EndDate.IsZero() || s.EndDate.After(now)
+}
+
 func MapFromDto(dto *subscriptions.SubscriptionDto) (*Subscription, error) {
-	id, err := uuid.Parse(dto.GetId().GetValue())
-	if err != nil {
-		return nil, err
+	var id uuid.UUID
+	if dto.GetId().GetValue() != "" {
+		parsed, err := uuid.Parse(dto.GetId().GetValue())
+		if err != nil {
+			return nil, err
+		}
+		id = parsed
 	}
 	accountId, _ := uuid.Parse(dto.GetAccountId().GetValue())
-	return &Subscription{
+	subscription := &Subscription{
 		ID:        id,
 		Name:      dto.GetSubscriptionName(),
-		Price:     int(dto.GetPrice()),
+		Price:     dto.GetPrice(),
 		StartDate: dto.GetStartDate().GetValue().AsTime(),
-		EndDate:   dto.GetEndDate().GetValue().AsTime(),
 		AccountID: accountId,
-	}, nil
+		Currency:  money.NormalizeCurrency(dto.GetCurrency()),
+	}
+	if dto.GetEndDate().GetValue() != nil {
+		subscription.EndDate = dto.GetEndDate().GetValue().AsTime()
+	}
+	return subscription, nil
 }
 
 func (s Subscription) MapToDto() *subscriptions.SubscriptionDto {
 
-	return &subscriptions.SubscriptionDto{
+	dto := &subscriptions.SubscriptionDto{
 		Id:               &subscriptions.UUID{Value: s.ID.String()},
 		SubscriptionName: s.Name,
-		Price:            int32(s.Price),
+		Price:            s.Price,
 		StartDate:        &subscriptions.Timestamp{Value: timestamppb.New(s.StartDate)},
-		EndDate:          &subscriptions.Timestamp{Value: timestamppb.New(s.EndDate)},
 		AccountId:        &subscriptions.UUID{Value: s.AccountID.String()},
+		Status:           s.Status,
+		Currency:         s.Currency,
+	}
+	if !s.EndDate.IsZero() {
+		dto.EndDate = &subscriptions.Timestamp{Value: timestamppb.New(s.EndDate)}
 	}
+	return dto
 }
 
 func MapFromDtoList(dto []*subscriptions.SubscriptionDto) ([]Subscription, error) {
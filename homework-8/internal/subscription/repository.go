@@ -8,76 +8,301 @@ import (
 	"github.com/google/uuid"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/outbox"
+	"time"
 )
 
 type Repository interface {
 	CreateSubscription(ctx context.Context, subscription Subscription) (*Subscription, error)
 	GetSubscriptionByID(ctx context.Context, id uuid.UUID) (*Subscription, error)
+	UpdateSubscription(ctx context.Context, id uuid.UUID, subscription Subscription) (*Subscription, error)
+	CancelSubscription(ctx context.Context, id uuid.UUID, endDate time.Time) (*Subscription, error)
+	ListSubscriptionsByAccount(ctx context.Context, accountID uuid.UUID, activeOnly bool) ([]Subscription, error)
+	// LockSubscription locks the account of the subscription and then the
+	// subscription until the transaction of ctx ends.
+	LockSubscription(ctx context.Context, id uuid.UUID) (*Subscription, error)
 }
 
 type SubscriptionRepository struct {
-	db database.Database
+	db        database.Database
+	txManager database.TxManager
 }
 
-func NewSubscriptionRepository(db database.Database) *SubscriptionRepository {
+func NewSubscriptionRepository(db database.Database, txManager database.TxManager) *SubscriptionRepository {
 	return &SubscriptionRepository{
-		db: db,
+		db:        db,
+		txManager: txManager,
 	}
 }
 
-func (r SubscriptionRepository) CreateSubscription(ctx context.Context, subscription Subscription) (*Subscription, error) {
-	query := `
-		INSERT INTO subscription (subscription_name, price, start_date, end_date, account_id) 
-		VALUES ($1, $2, $3, $4, $5) 
-		RETURNING id`
-
-	var id uuid.UUID
-	err := r.db.QueryRow(
-		query,
-		subscription.Name,
-		subscription.Price,
-		subscription.StartDate,
-		subscription.EndDate,
-		subscription.AccountID,
-	).Scan(&id)
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const subscriptionColumns = "id, subscription_name, price, start_date, end_date, account_id, status, currency"
+
+// accountStatusClosed mirrors model.StatusClosed, the account model imports this package.
+const accountStatusClosed = "closed"
+
+func scanSubscription(row rowScanner) (*Subscription, error) {
+	var (
+		subscription Subscription
+		endDate      sql.NullTime
+	)
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.Name,
+		&subscription.Price,
+		&subscription.StartDate,
+		&endDate,
+		&subscription.AccountID,
+		&subscription.Status,
+		&subscription.Currency,
+	)
 	if err != nil {
-		return nil, apperr.NewInternalServerError("Internal server error")
+		return nil, err
 	}
+	subscription.EndDate = endDate.Time
 
-	createdSubscription := Subscription{
-		ID:        id,
-		Name:      subscription.Name,
-		Price:     subscription.Price,
-		StartDate: subscription.StartDate,
-		EndDate:   subscription.EndDate,
-		AccountID: subscription.AccountID,
+	return &subscription, nil
+}
+
+func (r SubscriptionRepository) CreateSubscription(ctx context.Context, subscription Subscription) (*Subscription, error) {
+	var created *Subscription
+	err := r.txManager.Do(ctx, func(ctx context.Context) error {
+		accountCurrency, err := r.lockAccount(ctx, subscription.AccountID)
+		if err != nil {
+			return err
+		}
+		if subscription.Currency == "" {
+			subscription.Currency = accountCurrency
+		}
+		if err := r.checkActiveNameIsFree(ctx, subscription.AccountID, subscription.Name, uuid.Nil); err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO subscription (id, subscription_name, price, start_date, end_date, account_id, next_charge_at, currency) 
+			VALUES ($1, $2, $3, $4, $5, $6, $4, $7) 
+			RETURNING ` + subscriptionColumns
+
+		created, err = scanSubscription(r.db.QueryRowContext(ctx,
+			query,
+			subscription.ID,
+			subscription.Name,
+			subscription.Price,
+			subscription.StartDate,
+			sql.NullTime{Time: subscription.EndDate, Valid: !subscription.EndDate.IsZero()},
+			subscription.AccountID,
+			subscription.Currency,
+		))
+		if err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+
+		return AddSubscriptionEvent(ctx, r.db, outbox.SubscriptionCreated, created)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return &createdSubscription, nil
+	return created, nil
 }
 
 func (r SubscriptionRepository) GetSubscriptionByID(ctx context.Context, id uuid.UUID) (*Subscription, error) {
 	query := `
-		SELECT id, subscription_name, price, start_date, end_date, account_id 
+		SELECT ` + subscriptionColumns + ` 
 		FROM subscription 
 		WHERE id = $1`
 
-	var subscription Subscription
+	subscription, err := scanSubscription(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperr.NewNotFoundError(fmt.Sprintf("Subscription with ID: %s not found", id))
+		}
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
 
-	err := r.db.QueryRow(query, id).Scan(
-		&subscription.ID,
-		&subscription.Name,
-		&subscription.Price,
-		&subscription.StartDate,
-		&sql.NullTime{Time: subscription.EndDate, Valid: true},
-		&subscription.AccountID,
-	)
+	return subscription, nil
+}
+
+func (r SubscriptionRepository) UpdateSubscription(ctx context.Context, id uuid.UUID, subscription Subscription) (*Subscription, error) {
+	var updated *Subscription
+	err := r.txManager.Do(ctx, func(ctx context.Context) error {
+		current, err := r.LockSubscription(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !current.IsActive(time.Now()) {
+			return apperr.NewBadRequestError(fmt.Sprintf("Subscription with ID: %s is cancelled", id))
+		}
+		if subscription.Currency != "" && subscription.Currency != current.Currency {
+			return apperr.NewBadRequestError(fmt.Sprintf("Currency of subscription with ID: %s cannot be changed", id))
+		}
+		if err = r.checkActiveNameIsFree(ctx, current.AccountID, subscription.Name, id); err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE subscription 
+			SET subscription_name = $1, price = $2 
+			WHERE id = $3 
+			RETURNING ` + subscriptionColumns
+
+		updated, err = scanSubscription(r.db.QueryRowContext(ctx, query, subscription.Name, subscription.Price, id))
+		if err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+
+		return AddSubscriptionEvent(ctx, r.db, outbox.SubscriptionUpdated, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// CancelSubscription ends the subscription at endDate. The row is kept so that
+// the history of the account stays intact.
+func (r SubscriptionRepository) CancelSubscription(ctx context.Context, id uuid.UUID, endDate time.Time) (*Subscription, error) {
+	var cancelled *Subscription
+	err := r.txManager.Do(ctx, func(ctx context.Context) error {
+		current, err := r.lockSubscription(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !current.IsActive(time.Now()) {
+			return apperr.NewBadRequestError(fmt.Sprintf("Subscription with ID: %s is already cancelled", id))
+		}
+		if endDate.Before(current.StartDate) {
+			return apperr.NewBadRequestError("End date must not be before the start date")
+		}
+
+		query := `
+			UPDATE subscription 
+			SET end_date = $1 
+			WHERE id = $2 
+			RETURNING ` + subscriptionColumns
+
+		cancelled, err = scanSubscription(r.db.QueryRowContext(ctx, query, endDate, id))
+		if err != nil {
+			return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+
+		return AddSubscriptionEvent(ctx, r.db, outbox.SubscriptionCancelled, cancelled)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cancelled, nil
+}
+
+func (r SubscriptionRepository) ListSubscriptionsByAccount(ctx context.Context, accountID uuid.UUID, activeOnly bool) ([]Subscription, error) {
+	query := `
+		SELECT ` + subscriptionColumns + ` 
+		FROM subscription 
+		WHERE account_id = $1`
+	if activeOnly {
+		query += " AND (end_date IS NULL OR end_date > now())"
+	}
+	query += " ORDER BY start_date, id"
+
+	rows, err := r.db.QueryRowsContext(ctx, query, accountID)
+	if err != nil {
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
+	defer rows.Close()
+
+	subscriptions := make([]Subscription, 0)
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+		}
+		subscriptions = append(subscriptions, *subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
+
+	return subscriptions, nil
+}
+
+// AddSubscriptionEvent writes an event about subscription to the outbox inside
+// the transaction that changed it, so ctx must carry that transaction.
+func AddSubscriptionEvent(ctx context.Context, db database.Database, eventType string, subscription *Subscription) error {
+	event, err := outbox.NewEvent(outbox.AggregateSubscription, subscription.ID, eventType, subscription.MapToDto())
+	if err != nil {
+		return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
+	return outbox.Add(ctx, db, event)
+}
+
+// lockAccount serializes subscription changes of one account, so that the
+// check for a duplicate active name cannot race with a concurrent insert.
+// It returns the currency of the account.
+func (r SubscriptionRepository) lockAccount(ctx context.Context, accountID uuid.UUID) (string, error) {
+	var currency, status string
+	err := r.db.QueryRowContext(ctx, "SELECT currency, status FROM bank_account WHERE id = $1 FOR UPDATE", accountID).Scan(&currency, &status)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, apperr.NewNotFoundError(fmt.Sprintf("Subscription with ID: %d not found", id))
+			return "", apperr.NewNotFoundError(fmt.Sprintf("Bank account with ID: %s not found", accountID))
 		}
-		return nil, apperr.NewInternalServerError("Internal server error")
+		return "", apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
+	if status == accountStatusClosed {
+		return "", apperr.NewBadRequestError(fmt.Sprintf("Bank account with ID: %s is closed", accountID))
 	}
+	return currency, nil
+}
 
-	return &subscription, nil
+// LockSubscription takes the locks in the order CloseBankAccount does, account
+// first, so that the two cannot deadlock. The account a subscription belongs
+// to never changes, so it is read before it is locked.
+func (r SubscriptionRepository) LockSubscription(ctx context.Context, id uuid.UUID) (*Subscription, error) {
+	subscription, err := r.GetSubscriptionByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.lockAccount(ctx, subscription.AccountID); err != nil {
+		return nil, err
+	}
+	return r.lockSubscription(ctx, id)
+}
+
+func (r SubscriptionRepository) lockSubscription(ctx context.Context, id uuid.UUID) (*Subscription, error) {
+	query := `
+		SELECT ` + subscriptionColumns + ` 
+		FROM subscription 
+		WHERE id = $1 
+		FOR UPDATE`
+
+	subscription, err := scanSubscription(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperr.NewNotFoundError(fmt.Sprintf("Subscription with ID: %s not found", id))
+		}
+		return nil, apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
+
+	return subscription, nil
+}
+
+func (r SubscriptionRepository) checkActiveNameIsFree(ctx context.Context, accountID uuid.UUID, name string, exceptID uuid.UUID) error {
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM subscription 
+			WHERE account_id = $1 AND subscription_name = $2 AND id <> $3 
+			  AND (end_date IS NULL OR end_date > now()))`
+
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, query, accountID, name, exceptID).Scan(&exists); err != nil {
+		return apperr.FromContextOr(ctx, err, apperr.NewInternalServerError("Internal server error"))
+	}
+	if exists {
+		return apperr.NewConflictError(fmt.Sprintf("Bank account already has an active subscription %q", name))
+	}
+	return nil
 }
@@ -0,0 +1,322 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
+	"testing"
+	"time"
+)
+
+type subscriptionRepoFixture struct {
+	mockSqlDb sqlmock.Sqlmock
+	repo      Repository
+}
+
+func newSubscriptionRepoFixture(t *testing.T) *subscriptionRepoFixture {
+	mockSqlDb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+
+	sqlDatabase, err := database.InitDBWithPool(mockSqlDb)
+	if err != nil {
+		t.Fatalf("Error creating mock database: %v", err)
+	}
+	return &subscriptionRepoFixture{
+		mockSqlDb: mock,
+		repo:      NewSubscriptionRepository(sqlDatabase, database.NewTxManager(sqlDatabase, sql.LevelDefault, 0)),
+	}
+}
+
+func TestCreateSubscriptionRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx          = context.Background()
+		accountID, _ = uuid.Parse("a7115d4e-65af-487f-a3ca-bf7ca9747c4c")
+		subscription = Subscription{ID: uuid.New(), Name: "Music", Price: 100, StartDate: time.Now(), AccountID: accountID}
+		columns      = []string{"id", "subscription_name", "price", "start_date", "end_date", "account_id", "status", "currency"}
+	)
+
+	tests := []struct {
+		name          string
+		mockSQL       func(mock sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name: "Success",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT currency, status FROM bank_account WHERE id = \$1 FOR UPDATE`).
+					WithArgs(accountID).
+					WillReturnRows(sqlmock.NewRows([]string{"currency", "status"}).AddRow("RUB", "active"))
+				mock.ExpectQuery(`SELECT EXISTS`).
+					WithArgs(accountID, "Music", uuid.Nil).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectQuery(`INSERT INTO subscription`).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(subscription.ID, subscription.Name, subscription.Price, subscription.StartDate, nil, accountID, StatusActive, "RUB"))
+				mock.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("subscription", subscription.ID, "subscription.created", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Fail, active subscription with the same name",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT currency, status FROM bank_account WHERE id = \$1 FOR UPDATE`).
+					WithArgs(accountID).
+					WillReturnRows(sqlmock.NewRows([]string{"currency", "status"}).AddRow("RUB", "active"))
+				mock.ExpectQuery(`SELECT EXISTS`).
+					WithArgs(accountID, "Music", uuid.Nil).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+			expectedError: apperr.NewConflictError(`Bank account already has an active subscription "Music"`),
+		},
+		{
+			name: "Fail, account not found",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT currency, status FROM bank_account WHERE id = \$1 FOR UPDATE`).
+					WithArgs(accountID).
+					WillReturnRows(sqlmock.NewRows([]string{"currency", "status"}))
+				mock.ExpectRollback()
+			},
+			expectedError: apperr.NewNotFoundError("Bank account with ID: a7115d4e-65af-487f-a3ca-bf7ca9747c4c not found"),
+		},
+		{
+			name: "Fail, account closed",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT currency, status FROM bank_account WHERE id = \$1 FOR UPDATE`).
+					WithArgs(accountID).
+					WillReturnRows(sqlmock.NewRows([]string{"currency", "status"}).AddRow("RUB", "closed"))
+				mock.ExpectRollback()
+			},
+			expectedError: apperr.NewBadRequestError("Bank account with ID: a7115d4e-65af-487f-a3ca-bf7ca9747c4c is closed"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fixture := newSubscriptionRepoFixture(t)
+			tc.mockSQL(fixture.mockSqlDb)
+
+			created, err := fixture.repo.CreateSubscription(ctx, subscription)
+
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err)
+				assert.Nil(t, created)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, subscription.ID, created.ID)
+				assert.True(t, created.EndDate.IsZero())
+			}
+			assert.NoError(t, fixture.mockSqlDb.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCancelSubscriptionRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		id        = uuid.New()
+		accountID = uuid.New()
+		startDate = time.Now().Add(-24 * time.Hour)
+		columns   = []string{"id", "subscription_name", "price", "start_date", "end_date", "account_id", "status", "currency"}
+	)
+
+	tests := []struct {
+		name          string
+		endDate       time.Time
+		currentEnd    interface{}
+		expectUpdate  bool
+		expectedError error
+	}{
+		{
+			name:         "Success",
+			endDate:      time.Now(),
+			currentEnd:   nil,
+			expectUpdate: true,
+		},
+		{
+			name:          "Fail, already cancelled",
+			endDate:       time.Now(),
+			currentEnd:    time.Now().Add(-time.Hour),
+			expectedError: apperr.NewBadRequestError("Subscription with ID: " + id.String() + " is already cancelled"),
+		},
+		{
+			name:          "Fail, end date before start date",
+			endDate:       startDate.Add(-time.Hour),
+			currentEnd:    nil,
+			expectedError: apperr.NewBadRequestError("End date must not be before the start date"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fixture := newSubscriptionRepoFixture(t)
+
+			fixture.mockSqlDb.ExpectBegin()
+			fixture.mockSqlDb.ExpectQuery(`FROM subscription\s+WHERE id = \$1\s+FOR UPDATE`).
+				WithArgs(id).
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "Music", 100, startDate, tc.currentEnd, accountID, StatusActive, "RUB"))
+			if tc.expectUpdate {
+				fixture.mockSqlDb.ExpectQuery(`UPDATE subscription\s+SET end_date = \$1`).
+					WithArgs(tc.endDate, id).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "Music", 100, startDate, tc.endDate, accountID, StatusActive, "RUB"))
+				fixture.mockSqlDb.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("subscription", id, "subscription.cancelled", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				fixture.mockSqlDb.ExpectCommit()
+			} else {
+				fixture.mockSqlDb.ExpectRollback()
+			}
+
+			cancelled, err := fixture.repo.CancelSubscription(ctx, id, tc.endDate)
+
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err)
+				assert.Nil(t, cancelled)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.endDate, cancelled.EndDate)
+			}
+			assert.NoError(t, fixture.mockSqlDb.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateSubscriptionRepo(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		id        = uuid.New()
+		accountID = uuid.New()
+		startDate = time.Now().Add(-24 * time.Hour)
+		columns   = []string{"id", "subscription_name", "price", "start_date", "end_date", "account_id", "status", "currency"}
+	)
+
+	tests := []struct {
+		name          string
+		mockSQL       func(mock sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name: "Success, locks the account before the subscription",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`FROM subscription\s+WHERE id = \$1$`).
+					WithArgs(id).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "Music", 100, startDate, nil, accountID, StatusActive, "RUB"))
+				mock.ExpectQuery(`SELECT currency, status FROM bank_account WHERE id = \$1 FOR UPDATE`).
+					WithArgs(accountID).
+					WillReturnRows(sqlmock.NewRows([]string{"currency", "status"}).AddRow("RUB", "active"))
+				mock.ExpectQuery(`FROM subscription\s+WHERE id = \$1\s+FOR UPDATE`).
+					WithArgs(id).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "Music", 100, startDate, nil, accountID, StatusActive, "RUB"))
+				mock.ExpectQuery(`SELECT EXISTS`).
+					WithArgs(accountID, "Movies", id).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectQuery(`UPDATE subscription\s+SET subscription_name = \$1, price = \$2`).
+					WithArgs("Movies", int64(200), id).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "Movies", 200, startDate, nil, accountID, StatusActive, "RUB"))
+				mock.ExpectExec(`INSERT INTO outbox`).
+					WithArgs("subscription", id, "subscription.updated", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Fail, account closed",
+			mockSQL: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`FROM subscription\s+WHERE id = \$1$`).
+					WithArgs(id).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow(id, "Music", 100, startDate, nil, accountID, StatusActive, "RUB"))
+				mock.ExpectQuery(`SELECT currency, status FROM bank_account WHERE id = \$1 FOR UPDATE`).
+					WithArgs(accountID).
+					WillReturnRows(sqlmock.NewRows([]string{"currency", "status"}).AddRow("RUB", "closed"))
+				mock.ExpectRollback()
+			},
+			expectedError: apperr.NewBadRequestError("Bank account with ID: " + accountID.String() + " is closed"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fixture := newSubscriptionRepoFixture(t)
+			tc.mockSQL(fixture.mockSqlDb)
+
+			updated, err := fixture.repo.UpdateSubscription(ctx, id, Subscription{Name: "Movies", Price: 200})
+
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err)
+				assert.Nil(t, updated)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "Movies", updated.Name)
+			}
+			assert.NoError(t, fixture.mockSqlDb.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetSubscriptionByIDRepo_ContextErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		err           error
+		expectedError error
+	}{
+		{
+			name:          "Cancelled",
+			err:           context.Canceled,
+			expectedError: apperr.NewCanceledError("Request canceled"),
+		},
+		{
+			name:          "Timed out",
+			err:           context.DeadlineExceeded,
+			expectedError: apperr.NewDeadlineExceededError("Request deadline exceeded"),
+		},
+		{
+			name:          "Database error",
+			err:           sql.ErrConnDone,
+			expectedError: apperr.NewInternalServerError("Internal server error"),
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			fixture := newSubscriptionRepoFixture(t)
+			id := uuid.New()
+			fixture.mockSqlDb.ExpectQuery(`FROM subscription\s+WHERE id = \$1`).WithArgs(id).WillReturnError(tc.err)
+
+			_, err := fixture.repo.GetSubscriptionByID(context.Background(), id)
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.NoError(t, fixture.mockSqlDb.ExpectationsWereMet())
+		})
+	}
+}
@@ -3,7 +3,9 @@ package subscription
 import (
 	"context"
 	"github.com/google/uuid"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/apperr"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/pkg/subscriptions"
+	"time"
 )
 
 type SubscriptionGrpcImpl struct {
@@ -20,7 +22,7 @@ func NewSubscriptionGrpcImpl(service Service) *SubscriptionGrpcImpl {
 func (s SubscriptionGrpcImpl) CreateSubscription(ctx context.Context, request *subscriptions.CreateSubscriptionRequest) (*subscriptions.CreateSubscriptionResponse, error) {
 	subscriptionRequest, err := MapFromDto(request.GetSubscription())
 	if err != nil {
-		return nil, err
+		return nil, apperr.NewBadRequestError(err.Error())
 	}
 
 	createdSubscription, err := s.service.CreateSubscription(ctx, *subscriptionRequest)
@@ -36,7 +38,7 @@ func (s SubscriptionGrpcImpl) CreateSubscription(ctx context.Context, request *s
 func (s SubscriptionGrpcImpl) GetSubscriptionById(ctx context.Context, request *subscriptions.GetSubscriptionByIdRequest) (*subscriptions.GetSubscriptionResponse, error) {
 	id, err := uuid.Parse(request.GetId().GetValue())
 	if err != nil {
-		return nil, err
+		return nil, apperr.NewBadRequestError(err.Error())
 	}
 
 	subscription, err := s.service.GetSubscriptionById(ctx, id)
@@ -48,3 +50,60 @@ func (s SubscriptionGrpcImpl) GetSubscriptionById(ctx context.Context, request *
 		Subscription: subscription.MapToDto(),
 	}, nil
 }
+
+func (s SubscriptionGrpcImpl) UpdateSubscription(ctx context.Context, request *subscriptions.UpdateSubscriptionRequest) (*subscriptions.UpdateSubscriptionResponse, error) {
+	id, err := uuid.Parse(request.GetId().GetValue())
+	if err != nil {
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+	subscriptionRequest, err := MapFromDto(request.GetSubscription())
+	if err != nil {
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	updatedSubscription, err := s.service.UpdateSubscription(ctx, id, *subscriptionRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &subscriptions.UpdateSubscriptionResponse{
+		Subscription: updatedSubscription.MapToDto(),
+	}, nil
+}
+
+func (s SubscriptionGrpcImpl) CancelSubscription(ctx context.Context, request *subscriptions.CancelSubscriptionRequest) (*subscriptions.CancelSubscriptionResponse, error) {
+	id, err := uuid.Parse(request.GetId().GetValue())
+	if err != nil {
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	var endDate time.Time
+	if request.GetEndDate().GetValue() != nil {
+		endDate = request.GetEndDate().GetValue().AsTime()
+	}
+
+	cancelledSubscription, err := s.service.CancelSubscription(ctx, id, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &subscriptions.CancelSubscriptionResponse{
+		Subscription: cancelledSubscription.MapToDto(),
+	}, nil
+}
+
+func (s SubscriptionGrpcImpl) ListSubscriptionsByAccount(ctx context.Context, request *subscriptions.ListSubscriptionsByAccountRequest) (*subscriptions.ListSubscriptionsByAccountResponse, error) {
+	accountID, err := uuid.Parse(request.GetAccountId().GetValue())
+	if err != nil {
+		return nil, apperr.NewBadRequestError(err.Error())
+	}
+
+	accountSubscriptions, err := s.service.ListSubscriptionsByAccount(ctx, accountID, request.GetActiveOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	return &subscriptions.ListSubscriptionsByAccountResponse{
+		Subscriptions: MapToDtoList(accountSubscriptions),
+	}, nil
+}
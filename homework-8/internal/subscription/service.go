@@ -10,6 +10,12 @@ import (
 type Service interface {
 	CreateSubscription(ctx context.Context, subscription Subscription) (*Subscription, error)
 	GetSubscriptionById(ctx context.Context, id uuid.UUID) (*Subscription, error)
+	// LockSubscription reads the subscription and locks it and its account
+	// until the transaction of ctx ends.
+	LockSubscription(ctx context.Context, id uuid.UUID) (*Subscription, error)
+	UpdateSubscription(ctx context.Context, id uuid.UUID, subscription Subscription) (*Subscription, error)
+	CancelSubscription(ctx context.Context, id uuid.UUID, endDate time.Time) (*Subscription, error)
+	ListSubscriptionsByAccount(ctx context.Context, accountID uuid.UUID, activeOnly bool) ([]Subscription, error)
 }
 
 type SubscriptionService struct {
@@ -24,7 +30,10 @@ func NewSubscriptionService(repository Repository) *SubscriptionService {
 
 func (s *SubscriptionService) CreateSubscription(ctx context.Context, subscription Subscription) (*Subscription, error) {
 	if err := subscription.Validate(); err != nil {
-		return nil, apperr.NewBadRequestError(err.Error())
+		return nil, apperr.NewValidationError(err)
+	}
+	if subscription.ID == uuid.Nil {
+		subscription.ID = uuid.New()
 	}
 	subscription.StartDate = time.Now()
 
@@ -43,3 +52,41 @@ func (s *SubscriptionService) GetSubscriptionById(ctx context.Context, id uuid.U
 	}
 	return subscription, nil
 }
+
+func (s *SubscriptionService) LockSubscription(ctx context.Context, id uuid.UUID) (*Subscription, error) {
+	return s.repository.LockSubscription(ctx, id)
+}
+
+func (s *SubscriptionService) UpdateSubscription(ctx context.Context, id uuid.UUID, subscription Subscription) (*Subscription, error) {
+	if err := subscription.Validate(); err != nil {
+		return nil, apperr.NewValidationError(err)
+	}
+
+	updatedSubscription, err := s.repository.UpdateSubscription(ctx, id, subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	return updatedSubscription, nil
+}
+
+func (s *SubscriptionService) CancelSubscription(ctx context.Context, id uuid.UUID, endDate time.Time) (*Subscription, error) {
+	if endDate.IsZero() {
+		endDate = time.Now()
+	}
+
+	cancelledSubscription, err := s.repository.CancelSubscription(ctx, id, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return cancelledSubscription, nil
+}
+
+func (s *SubscriptionService) ListSubscriptionsByAccount(ctx context.Context, accountID uuid.UUID, activeOnly bool) ([]Subscription, error) {
+	subscriptions, err := s.repository.ListSubscriptionsByAccount(ctx, accountID, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
@@ -25,7 +25,7 @@ func (b *BankAccountBuilder) HolderName(val string) *BankAccountBuilder {
 	return b
 }
 
-func (b *BankAccountBuilder) Balance(val int) *BankAccountBuilder {
+func (b *BankAccountBuilder) Balance(val int64) *BankAccountBuilder {
 	b.instance.Balance = val
 	return b
 }
@@ -45,6 +45,26 @@ func (b *BankAccountBuilder) Subscriptions(val []subscription.Subscription) *Ban
 	return b
 }
 
+func (b *BankAccountBuilder) Version(val int64) *BankAccountBuilder {
+	b.instance.Version = val
+	return b
+}
+
+func (b *BankAccountBuilder) Currency(val string) *BankAccountBuilder {
+	b.instance.Currency = val
+	return b
+}
+
+func (b *BankAccountBuilder) OwnerID(val string) *BankAccountBuilder {
+	b.instance.OwnerID = val
+	return b
+}
+
+func (b *BankAccountBuilder) Status(val string) *BankAccountBuilder {
+	b.instance.Status = val
+	return b
+}
+
 func (b *BankAccountBuilder) Build() *model.BankAccount {
 	return b.instance
 }
@@ -57,7 +77,11 @@ func (b *BankAccountBuilder) Valid() *BankAccountBuilder {
 		Balance(1000).
 		OpeningDate(time.Time{}).
 		BankName("Sberbank").
-		Subscriptions(make([]subscription.Subscription, 0))
+		Subscriptions(make([]subscription.Subscription, 0)).
+		Version(model.InitialVersion).
+		Currency("RUB").
+		OwnerID("dima").
+		Status(model.StatusActive)
 }
 
 func (b *BankAccountBuilder) Invalid() *BankAccountBuilder {
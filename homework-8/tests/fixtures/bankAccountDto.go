@@ -25,8 +25,8 @@ func (b *BankAccountDtoBuilder) HolderName(val string) *BankAccountDtoBuilder {
 	return b
 }
 
-func (b *BankAccountDtoBuilder) Balance(val int) *BankAccountDtoBuilder {
-	b.instance.Balance = int32(val)
+func (b *BankAccountDtoBuilder) Balance(val int64) *BankAccountDtoBuilder {
+	b.instance.Balance = val
 	return b
 }
 
@@ -45,6 +45,16 @@ func (b *BankAccountDtoBuilder) Subscriptions(val []*subscriptions.SubscriptionD
 	return b
 }
 
+func (b *BankAccountDtoBuilder) Version(val int64) *BankAccountDtoBuilder {
+	b.instance.Version = val
+	return b
+}
+
+func (b *BankAccountDtoBuilder) Currency(val string) *BankAccountDtoBuilder {
+	b.instance.Currency = val
+	return b
+}
+
 func (b *BankAccountDtoBuilder) Build() *bank_accounts.BankAccountDto {
 	return b.instance
 }
@@ -57,7 +67,9 @@ func (b *BankAccountDtoBuilder) Valid() *BankAccountDtoBuilder {
 		Balance(1000).
 		OpeningDate(time.Time{}).
 		BankName("Sberbank").
-		Subscriptions(make([]*subscriptions.SubscriptionDto, 0))
+		Subscriptions(make([]*subscriptions.SubscriptionDto, 0)).
+		Version(1).
+		Currency("RUB")
 }
 
 func (b *BankAccountDtoBuilder) Invalid() *BankAccountDtoBuilder {
@@ -3,10 +3,12 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"github.com/google/uuid"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/account/model"
 	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/app/database"
+	"gitlab.ozon.dev/sudakov.dima.2014/homework-3/internal/money"
 	"log"
 	"sync"
 	"testing"
@@ -37,7 +39,7 @@ func (d *TDB) TearDown() {
 }
 
 func (d *TDB) Truncate() {
-	rows, err := d.DB.QueryRows("SELECT table_name FROM information_schema.tables WHERE table_schema='public' AND table_type='BASE TABLE' AND table_name != 'goose_db_version'")
+	rows, err := d.DB.QueryRowsContext(context.Background(), "SELECT table_name FROM information_schema.tables WHERE table_schema='public' AND table_type='BASE TABLE' AND table_name != 'goose_db_version'")
 	if err != nil {
 		panic(err)
 	}
@@ -61,7 +63,7 @@ func (d *TDB) Truncate() {
 func (d *TDB) ExistsById(id uuid.UUID, tableName string) (bool, error) {
 	query := "SELECT EXISTS(SELECT 1 FROM " + tableName + " WHERE id = $1)"
 	var exists bool
-	err := d.DB.QueryRow(query, id).Scan(&exists)
+	err := d.DB.QueryRowContext(context.Background(), query, id).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
@@ -72,7 +74,7 @@ func (d *TDB) DeleteBankAccounts(accountIDs []uuid.UUID) error {
 	query := `DELETE FROM bank_account WHERE id = $1`
 
 	for _, id := range accountIDs {
-		_, err := d.DB.Execute(query, id)
+		_, err := d.DB.ExecuteContext(context.Background(), query, id)
 		if err != nil {
 			return err
 		}
@@ -82,15 +84,20 @@ func (d *TDB) DeleteBankAccounts(accountIDs []uuid.UUID) error {
 }
 
 func (d *TDB) InsertSampleData(accounts []model.BankAccount) error {
-	tx, err := d.DB.BeginTx()
+	tx, err := d.DB.BeginTx(context.Background(), nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
 	for _, account := range accounts {
-		_, err := tx.Exec("INSERT INTO bank_account (id, holder_name, balance, opening_date, bank_name) VALUES ($1, $2, $3, $4, $5)",
-			account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName)
+		// bank_account.currency has no default, every account names its own
+		currency := account.Currency
+		if currency == "" {
+			currency = money.DefaultCurrency
+		}
+		_, err := tx.Exec("INSERT INTO bank_account (id, holder_name, balance, opening_date, bank_name, currency, owner_id) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			account.ID, account.HolderName, account.Balance, account.OpeningDate, account.BankName, currency, account.OwnerID)
 		if err != nil {
 			return err
 		}